@@ -0,0 +1,269 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+// Priority classifies github API calls so the rate limit budget can be
+// shared between them. Lower values are more important.
+type Priority int
+
+const (
+	// PriorityCritical calls, like merging, may use the reserved tokens
+	PriorityCritical Priority = iota
+	// PriorityLoop is the munge loop. It is the default.
+	PriorityLoop
+	// PriorityReport is for reports
+	PriorityReport
+	// PriorityBackground is for things like refreshing the list of users
+	PriorityBackground
+
+	numPriorities = int(PriorityBackground) + 1
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityCritical:
+		return "critical"
+	case PriorityLoop:
+		return "loop"
+	case PriorityReport:
+		return "report"
+	case PriorityBackground:
+		return "background"
+	}
+	return "unknown"
+}
+
+// WithPriority returns a Config which makes all of its calls at priority `p`.
+// It shares the rate limit, cache and analytics of `config`.
+func (config *Config) WithPriority(p Priority) *Config {
+	root := config.root()
+	client := root.clients[p]
+	if client == nil {
+		return config
+	}
+	derived := *config
+	derived.parent = root
+	derived.client = client
+	derived.transport = root.transports[p]
+	return &derived
+}
+
+// WithPriority returns a copy of the object which makes all of its calls at
+// priority `p`.
+func (obj *MungeObject) WithPriority(p Priority) *MungeObject {
+	derived := *obj
+	derived.config = obj.config.WithPriority(p)
+	return &derived
+}
+
+// setPriorityTransport makes `transport` the one used for calls at priority
+// `p`. The client for it talks to the same github as config.client, if set.
+func (config *Config) setPriorityTransport(p Priority, transport http.RoundTripper) {
+	client := github.NewClient(&http.Client{
+		Transport: transport,
+	})
+	if config.client != nil {
+		client.BaseURL = config.client.BaseURL
+		client.UploadURL = config.client.UploadURL
+	}
+	config.transports[p] = transport
+	config.clients[p] = client
+}
+
+// root is the Config all others were derived from with WithPriority
+func (config *Config) root() *Config {
+	if config.parent != nil {
+		return config.parent
+	}
+	return config
+}
+
+// stats returns the analytics shared by all priorities
+func (config *Config) stats() *analytics {
+	return &config.root().analytics
+}
+
+// callBudget makes sure we do not run out of github API tokens. Tokens below
+// `reserve` may only be used by critical calls, and each lower priority
+// leaves a larger floor untouched. If non-critical calls are using tokens
+// fast enough to run out before the limit resets they are spaced out so what
+// they may spend lasts until then.
+type callBudget struct {
+	sync.Mutex
+	remaining int
+	resetTime time.Time
+	reserve   int
+
+	// When each priority last got a token
+	last [numPriorities]time.Time
+	// Tokens used at each priority and in total since windowStart, the
+	// time the current rate limit window was first seen.
+	used        [numPriorities]int
+	windowUsed  int
+	windowStart time.Time
+}
+
+func newCallBudget(reserve int) *callBudget {
+	now := time.Now()
+	return &callBudget{
+		remaining:   reserve + 500, // put in 500 so we at least have a couple to check our real limits
+		resetTime:   now.Add(1 * time.Minute),
+		reserve:     reserve,
+		windowStart: now,
+	}
+}
+
+// floor is how many tokens calls at priority `p` must leave unused
+func (c *callBudget) floor(p Priority) int {
+	switch p {
+	case PriorityCritical:
+		return 0
+	case PriorityLoop:
+		return c.reserve
+	case PriorityReport:
+		return c.reserve + c.reserve/2
+	}
+	return 2 * c.reserve
+}
+
+// delay returns how long a call at priority `p` must wait before it may use a
+// token. c.Lock() MUST be held.
+func (c *callBudget) delay(p Priority, now time.Time) time.Duration {
+	untilReset := c.resetTime.Sub(now)
+	if untilReset <= 0 {
+		// The limit has reset, the response will tell us the new one
+		return 0
+	}
+	spendable := c.remaining - c.floor(p)
+	if spendable <= 0 {
+		return untilReset + (1 * time.Minute)
+	}
+	if p == PriorityCritical || c.runsOutAt(spendable, now).IsZero() {
+		return 0
+	}
+	interval := untilReset / time.Duration(spendable)
+	if next := c.last[p].Add(interval); next.After(now) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+func (c *callBudget) getToken(p Priority) {
+	for {
+		c.Lock()
+		now := time.Now()
+		sleepTime := c.delay(p, now)
+		if sleepTime == 0 {
+			c.remaining--
+			c.last[p] = now
+			c.used[p]++
+			c.windowUsed++
+			c.Unlock()
+			return
+		}
+		c.Unlock()
+		if sleepTime > time.Minute {
+			glog.Errorf("*****************")
+			glog.Errorf("Ran out of github API tokens for %v calls. Sleeping for %v minutes", p, sleepTime.Minutes())
+			glog.Errorf("*****************")
+		}
+		time.Sleep(sleepTime)
+	}
+}
+
+// runsOutAt predicts when `tokens` will be used up at the rate we have used
+// tokens in this window. It is zero if they will last until the reset.
+// c.Lock() MUST be held.
+func (c *callBudget) runsOutAt(tokens int, now time.Time) time.Time {
+	elapsed := now.Sub(c.windowStart)
+	if c.windowUsed == 0 || elapsed <= 0 {
+		return time.Time{}
+	}
+	perToken := elapsed / time.Duration(c.windowUsed)
+	exhausted := now.Add(perToken * time.Duration(tokens))
+	if exhausted.After(c.resetTime) {
+		return time.Time{}
+	}
+	return exhausted
+}
+
+// exhaustionTime predicts when we will run out of tokens at the rate we have
+// used them in this window. It is zero if they will last until the reset.
+// c.Lock() MUST be held.
+func (c *callBudget) exhaustionTime(now time.Time) time.Time {
+	return c.runsOutAt(c.remaining, now)
+}
+
+// update records the rate limit github reports in a response
+func (c *callBudget) update(resp *http.Response) {
+	c.Lock()
+	defer c.Unlock()
+	// GraphQL has its own limit, which must not be mistaken for ours
+	if resp.Header.Get(headerRateResource) != "" && resp.Header.Get(headerRateResource) != "core" {
+		return
+	}
+	if remaining := resp.Header.Get(headerRateRemaining); remaining != "" {
+		c.remaining, _ = strconv.Atoi(remaining)
+	}
+	if reset := resp.Header.Get(headerRateReset); reset != "" {
+		if v, _ := strconv.ParseInt(reset, 10, 64); v != 0 {
+			resetTime := time.Unix(v, 0)
+			if resetTime.After(c.resetTime) {
+				// A new rate limit window
+				c.windowStart = time.Now()
+				c.windowUsed = 0
+			}
+			c.resetTime = resetTime
+		}
+	}
+}
+
+// callLimitRoundTripper takes a token from the shared budget for every call
+// at its priority. Merges are always critical.
+type callLimitRoundTripper struct {
+	budget   *callBudget
+	priority Priority
+	delegate http.RoundTripper
+}
+
+func (c *callLimitRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	delegate := c.delegate
+	if delegate == nil {
+		delegate = http.DefaultTransport
+	}
+	p := c.priority
+	if req.Method == "PUT" && strings.HasSuffix(req.URL.Path, "/merge") {
+		p = PriorityCritical
+	}
+	c.budget.getToken(p)
+	resp, err := delegate.RoundTrip(req)
+	if resp != nil {
+		c.budget.update(resp)
+	}
+	return resp, err
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	github_test "k8s.io/contrib/mungegithub/github/testing"
+)
+
+func TestCallLimitDelay(t *testing.T) {
+	now := time.Unix(10000, 0)
+	tests := []struct {
+		name      string
+		remaining int
+		reset     time.Duration
+		last      time.Duration // before now
+		used      int           // in the 10 minutes before now
+		priority  Priority
+		expected  time.Duration
+	}{
+		{
+			name:      "critical uses the reserve",
+			remaining: 10,
+			reset:     time.Hour,
+			priority:  PriorityCritical,
+		},
+		{
+			name:      "critical waits when nothing is left",
+			remaining: 0,
+			reset:     time.Hour,
+			priority:  PriorityCritical,
+			expected:  time.Hour + time.Minute,
+		},
+		{
+			name:      "loop may not use the reserve",
+			remaining: 100,
+			reset:     time.Hour,
+			priority:  PriorityLoop,
+			expected:  time.Hour + time.Minute,
+		},
+		{
+			name:      "report leaves more than the loop",
+			remaining: 140,
+			reset:     time.Hour,
+			priority:  PriorityReport,
+			expected:  time.Hour + time.Minute,
+		},
+		{
+			name:      "loop is spread over the window",
+			remaining: 160,
+			reset:     time.Hour,
+			last:      20 * time.Second,
+			used:      600,
+			priority:  PriorityLoop,
+			expected:  40 * time.Second,
+		},
+		{
+			name:      "no wait if the last call was long enough ago",
+			remaining: 160,
+			reset:     time.Hour,
+			last:      3 * time.Minute,
+			used:      600,
+			priority:  PriorityLoop,
+		},
+		{
+			name:      "no wait if the tokens last until the reset",
+			remaining: 160,
+			reset:     time.Hour,
+			last:      20 * time.Second,
+			used:      5,
+			priority:  PriorityLoop,
+		},
+		{
+			name:      "background waits for the reset",
+			remaining: 200,
+			reset:     time.Hour,
+			priority:  PriorityBackground,
+			expected:  time.Hour + time.Minute,
+		},
+		{
+			name:      "anything goes after the reset",
+			remaining: 0,
+			reset:     -time.Second,
+			priority:  PriorityBackground,
+		},
+	}
+	for _, test := range tests {
+		c := newCallBudget(100)
+		c.remaining = test.remaining
+		c.resetTime = now.Add(test.reset)
+		c.windowStart = now.Add(-10 * time.Minute)
+		c.windowUsed = test.used
+		c.last[test.priority] = now.Add(-test.last)
+		if d := c.delay(test.priority, now); d != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, d)
+		}
+	}
+}
+
+func TestExhaustionTime(t *testing.T) {
+	now := time.Unix(10000, 0)
+	c := newCallBudget(100)
+	c.windowStart = now.Add(-10 * time.Minute)
+	c.resetTime = now.Add(30 * time.Minute)
+
+	// 1 token a second, 20 minutes left
+	c.windowUsed = 600
+	c.remaining = 1200
+	if e := c.exhaustionTime(now); !e.Equal(now.Add(20 * time.Minute)) {
+		t.Errorf("expected exhaustion in 20 minutes, got %v", e.Sub(now))
+	}
+	// 1 token a second, 60 minutes left
+	c.remaining = 3600
+	if e := c.exhaustionTime(now); !e.IsZero() {
+		t.Errorf("expected no exhaustion before reset, got %v", e.Sub(now))
+	}
+}
+
+func TestWithPriority(t *testing.T) {
+	client, server, mux := github_test.InitServer(t, nil, nil, nil, nil, nil)
+	defer server.Close()
+	mux.HandleFunc("/users/bob", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"login": "bob"}`))
+	})
+
+	budget := newCallBudget(0)
+	config := &Config{Org: "o", Project: "r"}
+	config.SetClient(client)
+	config.apiLimit = budget
+	for p := Priority(0); int(p) < numPriorities; p++ {
+		config.setPriorityTransport(p, &callLimitRoundTripper{budget: budget, priority: p})
+	}
+
+	report := config.WithPriority(PriorityReport)
+	if _, err := report.GetUser("bob"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if budget.used[PriorityReport] != 1 {
+		t.Errorf("expected the call to be made at report priority: %v", budget.used)
+	}
+	if report.root() != config || config.analytics.GetUser.Count != 1 {
+		t.Errorf("expected analytics to be shared with the original config")
+	}
+	if again := config.WithPriority(PriorityReport); again.client != report.client {
+		t.Errorf("expected the client of a priority to be reused")
+	}
+}
@@ -0,0 +1,503 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+)
+
+const (
+	// How many PRs to ask for in one GraphQL query
+	bulkBatchSize = 25
+)
+
+// prefetched is data fetched in bulk before an object is munged. Each field
+// is used instead of a REST call until something invalidates it, after which
+// we go back to REST.
+type prefetched struct {
+	// events is nil if the PR had too many events to fetch in bulk
+	events []github.IssueEvent
+	status *github.CombinedStatus
+	// commits and files are nil if the PR had too many to fetch in bulk.
+	// GraphQL only knows the files of the whole PR, so the commits have no
+	// Files.
+	commits []github.RepositoryCommit
+	files   []github.CommitFile
+	// pushes are the commits of the PR which github saw pushed, with when.
+	// It is nil if the PR had too many commits to fetch in bulk.
+	pushes []headChange
+}
+
+// The only events mungers look at. Others, like 'subscribed', are only
+// available from REST.
+const bulkPRQuery = `
+fragment prFields on PullRequest {
+  number title body state merged mergeable url createdAt updatedAt mergedAt additions deletions
+  author { login avatarUrl }
+  headRefName headRefOid baseRefName baseRefOid
+  timelineItems(first: 100, itemTypes: [LABELED_EVENT, UNLABELED_EVENT, CLOSED_EVENT, REOPENED_EVENT, MERGED_EVENT, HEAD_REF_FORCE_PUSHED_EVENT]) {
+    pageInfo { hasNextPage }
+    nodes {
+      __typename
+      ... on LabeledEvent { createdAt actor { login } label { name } }
+      ... on UnlabeledEvent { createdAt actor { login } label { name } }
+      ... on ClosedEvent { createdAt actor { login } }
+      ... on ReopenedEvent { createdAt actor { login } }
+      ... on MergedEvent { createdAt actor { login } commit { oid } }
+      ... on HeadRefForcePushedEvent { createdAt actor { login } afterCommit { oid } }
+    }
+  }
+  headCommit: commits(last: 1) {
+    nodes { commit { oid status { state contexts { context state description targetUrl createdAt } } } }
+  }
+  commits(first: 100) {
+    pageInfo { hasNextPage }
+    nodes { commit { oid message pushedDate author { name email date user { login } } committer { name email date } } }
+  }
+  files(first: 100) {
+    pageInfo { hasNextPage }
+    nodes { path additions deletions }
+  }
+}
+`
+
+type gqlActor struct {
+	Login     string `json:"login"`
+	AvatarURL string `json:"avatarUrl"`
+}
+
+type gqlCommitRef struct {
+	OID string `json:"oid"`
+}
+
+type gqlLabel struct {
+	Name string `json:"name"`
+}
+
+type gqlEvent struct {
+	Typename    string        `json:"__typename"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	Actor       *gqlActor     `json:"actor"`
+	Label       *gqlLabel     `json:"label"`
+	Commit      *gqlCommitRef `json:"commit"`
+	AfterCommit *gqlCommitRef `json:"afterCommit"`
+}
+
+type gqlGitActor struct {
+	Name  string     `json:"name"`
+	Email string     `json:"email"`
+	Date  *time.Time `json:"date"`
+	User  *gqlActor  `json:"user"`
+}
+
+type gqlPageInfo struct {
+	HasNextPage bool `json:"hasNextPage"`
+}
+
+type gqlStatusContext struct {
+	Context     string    `json:"context"`
+	State       string    `json:"state"`
+	Description string    `json:"description"`
+	TargetURL   string    `json:"targetUrl"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type gqlPullRequest struct {
+	Number      int        `json:"number"`
+	Title       string     `json:"title"`
+	Body        string     `json:"body"`
+	State       string     `json:"state"`
+	Merged      bool       `json:"merged"`
+	Mergeable   string     `json:"mergeable"`
+	URL         string     `json:"url"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	MergedAt    *time.Time `json:"mergedAt"`
+	Additions   int        `json:"additions"`
+	Deletions   int        `json:"deletions"`
+	Author      *gqlActor  `json:"author"`
+	HeadRefName string     `json:"headRefName"`
+	HeadRefOid  string     `json:"headRefOid"`
+	BaseRefName string     `json:"baseRefName"`
+	BaseRefOid  string     `json:"baseRefOid"`
+
+	TimelineItems struct {
+		PageInfo gqlPageInfo `json:"pageInfo"`
+		Nodes    []gqlEvent  `json:"nodes"`
+	} `json:"timelineItems"`
+
+	HeadCommit struct {
+		Nodes []struct {
+			Commit struct {
+				OID    string `json:"oid"`
+				Status *struct {
+					State    string             `json:"state"`
+					Contexts []gqlStatusContext `json:"contexts"`
+				} `json:"status"`
+			} `json:"commit"`
+		} `json:"nodes"`
+	} `json:"headCommit"`
+
+	Commits struct {
+		PageInfo gqlPageInfo `json:"pageInfo"`
+		Nodes    []struct {
+			Commit struct {
+				OID        string       `json:"oid"`
+				Message    string       `json:"message"`
+				PushedDate *time.Time   `json:"pushedDate"`
+				Author     *gqlGitActor `json:"author"`
+				Committer  *gqlGitActor `json:"committer"`
+			} `json:"commit"`
+		} `json:"nodes"`
+	} `json:"commits"`
+
+	Files struct {
+		PageInfo gqlPageInfo `json:"pageInfo"`
+		Nodes    []struct {
+			Path      string `json:"path"`
+			Additions int    `json:"additions"`
+			Deletions int    `json:"deletions"`
+		} `json:"nodes"`
+	} `json:"files"`
+}
+
+type gqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type gqlResponse struct {
+	Data struct {
+		Repository map[string]*gqlPullRequest `json:"repository"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func newString(s string) *string { return &s }
+func newBool(b bool) *bool       { return &b }
+func newInt(i int) *int          { return &i }
+
+func gqlUser(actor *gqlActor) *github.User {
+	if actor == nil {
+		return nil
+	}
+	user := &github.User{Login: newString(actor.Login)}
+	if actor.AvatarURL != "" {
+		user.AvatarURL = newString(actor.AvatarURL)
+	}
+	return user
+}
+
+func gqlCommitAuthor(actor *gqlGitActor) *github.CommitAuthor {
+	if actor == nil {
+		return nil
+	}
+	return &github.CommitAuthor{
+		Name:  newString(actor.Name),
+		Email: newString(actor.Email),
+		Date:  actor.Date,
+	}
+}
+
+func (g *gqlPullRequest) pullRequest() *github.PullRequest {
+	number := g.Number
+	merged := g.Merged
+	created := g.CreatedAt
+	updated := g.UpdatedAt
+	state := "open"
+	if g.State != "OPEN" {
+		state = "closed"
+	}
+	pr := &github.PullRequest{
+		Number:    &number,
+		State:     &state,
+		Title:     newString(g.Title),
+		Body:      newString(g.Body),
+		CreatedAt: &created,
+		UpdatedAt: &updated,
+		User:      gqlUser(g.Author),
+		Merged:    &merged,
+		MergedAt:  g.MergedAt,
+		Additions: newInt(g.Additions),
+		Deletions: newInt(g.Deletions),
+		HTMLURL:   newString(g.URL),
+		Head:      &github.PullRequestBranch{Ref: newString(g.HeadRefName), SHA: newString(g.HeadRefOid)},
+		Base:      &github.PullRequestBranch{Ref: newString(g.BaseRefName), SHA: newString(g.BaseRefOid)},
+	}
+	// UNKNOWN leaves Mergeable nil, just like REST while github computes it
+	switch g.Mergeable {
+	case "MERGEABLE":
+		pr.Mergeable = newBool(true)
+	case "CONFLICTING":
+		pr.Mergeable = newBool(false)
+	}
+	return pr
+}
+
+var gqlEventNames = map[string]string{
+	"LabeledEvent":            "labeled",
+	"UnlabeledEvent":          "unlabeled",
+	"ClosedEvent":             "closed",
+	"ReopenedEvent":           "reopened",
+	"MergedEvent":             "merged",
+	"HeadRefForcePushedEvent": "head_ref_force_pushed",
+}
+
+func (g *gqlPullRequest) events() []github.IssueEvent {
+	if g.TimelineItems.PageInfo.HasNextPage {
+		return nil
+	}
+	events := []github.IssueEvent{}
+	for i := range g.TimelineItems.Nodes {
+		node := &g.TimelineItems.Nodes[i]
+		name, ok := gqlEventNames[node.Typename]
+		if !ok {
+			continue
+		}
+		created := node.CreatedAt
+		event := github.IssueEvent{
+			Event:     newString(name),
+			CreatedAt: &created,
+			Actor:     gqlUser(node.Actor),
+		}
+		if node.Label != nil {
+			event.Label = &github.Label{Name: newString(node.Label.Name)}
+		}
+		if node.Commit != nil {
+			event.CommitID = newString(node.Commit.OID)
+		}
+		if node.AfterCommit != nil {
+			event.CommitID = newString(node.AfterCommit.OID)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func (g *gqlPullRequest) commits() []github.RepositoryCommit {
+	if g.Commits.PageInfo.HasNextPage {
+		return nil
+	}
+	commits := []github.RepositoryCommit{}
+	for i := range g.Commits.Nodes {
+		c := &g.Commits.Nodes[i].Commit
+		commit := github.RepositoryCommit{
+			SHA: newString(c.OID),
+			Commit: &github.Commit{
+				SHA:       newString(c.OID),
+				Message:   newString(c.Message),
+				Author:    gqlCommitAuthor(c.Author),
+				Committer: gqlCommitAuthor(c.Committer),
+			},
+		}
+		if c.Author != nil {
+			commit.Author = gqlUser(c.Author.User)
+		}
+		commits = append(commits, commit)
+	}
+	return commits
+}
+
+// pushes returns when the commits of the PR were pushed, in the order of the
+// PR. Commits github has no push date for, like ones pushed before it started
+// recording them, are left out.
+func (g *gqlPullRequest) pushes() []headChange {
+	if g.Commits.PageInfo.HasNextPage {
+		return nil
+	}
+	pushes := []headChange{}
+	for _, node := range g.Commits.Nodes {
+		if node.Commit.PushedDate == nil {
+			continue
+		}
+		pushes = append(pushes, headChange{SHA: node.Commit.OID, Time: *node.Commit.PushedDate})
+	}
+	return pushes
+}
+
+func (g *gqlPullRequest) files() []github.CommitFile {
+	if g.Files.PageInfo.HasNextPage {
+		return nil
+	}
+	files := []github.CommitFile{}
+	for _, f := range g.Files.Nodes {
+		files = append(files, github.CommitFile{
+			Filename:  newString(f.Path),
+			Additions: newInt(f.Additions),
+			Deletions: newInt(f.Deletions),
+			Changes:   newInt(f.Additions + f.Deletions),
+		})
+	}
+	return files
+}
+
+func (g *gqlPullRequest) combinedStatus() *github.CombinedStatus {
+	if len(g.HeadCommit.Nodes) == 0 {
+		return nil
+	}
+	commit := g.HeadCommit.Nodes[0].Commit
+	if commit.OID != g.HeadRefOid {
+		return nil
+	}
+	// REST says 'pending' with no statuses when there are none
+	combined := &github.CombinedStatus{
+		State:    newString("pending"),
+		SHA:      newString(commit.OID),
+		Statuses: []github.RepoStatus{},
+	}
+	if commit.Status == nil {
+		return combined
+	}
+	combined.State = newString(strings.ToLower(commit.Status.State))
+	for _, c := range commit.Status.Contexts {
+		created := c.CreatedAt
+		combined.Statuses = append(combined.Statuses, github.RepoStatus{
+			Context:     newString(c.Context),
+			State:       newString(strings.ToLower(c.State)),
+			Description: newString(c.Description),
+			TargetURL:   newString(c.TargetURL),
+			CreatedAt:   &created,
+		})
+	}
+	total := len(combined.Statuses)
+	combined.TotalCount = &total
+	return combined
+}
+
+// buildBulkQuery asks for every PR in `numbers` under the alias prN, where N
+// is the index of the PR in `numbers`.
+func buildBulkQuery(org, project string, numbers []int) *gqlRequest {
+	vars := map[string]interface{}{
+		"owner": org,
+		"name":  project,
+	}
+	params := []string{"$owner: String!", "$name: String!"}
+	fields := []string{}
+	for i, num := range numbers {
+		vars[fmt.Sprintf("n%d", i)] = num
+		params = append(params, fmt.Sprintf("$n%d: Int!", i))
+		fields = append(fields, fmt.Sprintf("    pr%d: pullRequest(number: $n%d) { ...prFields }", i, i))
+	}
+	query := fmt.Sprintf("query(%s) {\n  repository(owner: $owner, name: $name) {\n%s\n  }\n}\n%s",
+		strings.Join(params, ", "), strings.Join(fields, "\n"), bulkPRQuery)
+	return &gqlRequest{Query: query, Variables: vars}
+}
+
+// graphqlURL is where the GraphQL API lives for the REST API the client uses
+func (config *Config) graphqlURL() string {
+	u, _ := url.Parse("graphql")
+	return config.client.BaseURL.ResolveReference(u).String()
+}
+
+// fetchPRsInBulk gets the PR, the events mungers need, the commits, the files
+// and the combined status of every PR in `numbers` with one GraphQL query. PRs which could not
+// be fetched are missing from the result and should be fetched over REST.
+func (config *Config) fetchPRsInBulk(numbers []int) (map[int]*MungeObject, error) {
+	b, err := json.Marshal(buildBulkQuery(config.Org, config.Project, numbers))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", config.graphqlURL(), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	transport := config.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	config.stats().GraphQL.Call(config, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("graphql returned %d: %s", resp.StatusCode, body)
+	}
+	out := &gqlResponse{}
+	if err := json.Unmarshal(body, out); err != nil {
+		return nil, err
+	}
+	// Errors for single PRs still return data for the others
+	for _, e := range out.Errors {
+		glog.V(2).Infof("GraphQL error: %s", e.Message)
+	}
+
+	objs := map[int]*MungeObject{}
+	for i, num := range numbers {
+		g := out.Data.Repository[fmt.Sprintf("pr%d", i)]
+		if g == nil || g.Number != num {
+			continue
+		}
+		objs[num] = &MungeObject{
+			config: config,
+			pr:     g.pullRequest(),
+			prefetched: &prefetched{
+				events:  g.events(),
+				status:  g.combinedStatus(),
+				commits: g.commits(),
+				files:   g.files(),
+				pushes:  g.pushes(),
+			},
+		}
+	}
+	return objs, nil
+}
+
+// prefetch fills in the PRs among `objs` with data fetched in bulk. Anything
+// which is not fetched is filled in lazily over REST.
+func (config *Config) prefetch(objs []*MungeObject) {
+	numbers := []int{}
+	byNumber := map[int]*MungeObject{}
+	for _, obj := range objs {
+		if obj.IsPR() {
+			numbers = append(numbers, *obj.Issue.Number)
+			byNumber[*obj.Issue.Number] = obj
+		}
+	}
+	if len(numbers) == 0 {
+		return
+	}
+	fetched, err := config.fetchPRsInBulk(numbers)
+	if err != nil {
+		glog.Errorf("Unable to fetch PRs %v in bulk, falling back to REST: %v", numbers, err)
+		return
+	}
+	for num, f := range fetched {
+		obj := byNumber[num]
+		obj.pr = f.pr
+		obj.prefetched = f.prefetched
+		obj.observeHead()
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	github_test "k8s.io/contrib/mungegithub/github/testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestBuildBulkQuery(t *testing.T) {
+	req := buildBulkQuery("o", "r", []int{5, 7})
+	if req.Variables["owner"] != "o" || req.Variables["name"] != "r" {
+		t.Errorf("unexpected repo variables: %v", req.Variables)
+	}
+	if req.Variables["n0"] != 5 || req.Variables["n1"] != 7 {
+		t.Errorf("unexpected PR variables: %v", req.Variables)
+	}
+	for _, want := range []string{"$n0: Int!", "$n1: Int!", "pr0: pullRequest(number: $n0)", "pr1: pullRequest(number: $n1)", "fragment prFields"} {
+		if !strings.Contains(req.Query, want) {
+			t.Errorf("query does not contain %q:\n%s", want, req.Query)
+		}
+	}
+}
+
+func TestFetchPRsInBulk(t *testing.T) {
+	pr := github_test.PullRequest("bob", false, true, true)
+	pr.Number = intPtr(1)
+	events := github_test.Events([]github_test.LabelTime{
+		{User: "alice", Label: "lgtm", Time: 10},
+	})
+	events = append(events, github.IssueEvent{
+		Event:     stringPtr("head_ref_force_pushed"),
+		CommitID:  stringPtr("mysha"),
+		CreatedAt: timePtr(time.Unix(20, 0)),
+		Actor:     &github.User{Login: stringPtr("bob")},
+	})
+	status := github_test.Status("mysha", []string{"unit"}, []string{"e2e"}, nil, nil)
+	pr.Additions = intPtr(30)
+	pr.Deletions = intPtr(5)
+	pr.MergedAt = timePtr(time.Unix(30, 0))
+	pr.Base = &github.PullRequestBranch{Ref: stringPtr("master"), SHA: stringPtr("basesha")}
+	commits := github_test.Commits(1, 15)
+	commits[0].Commit.Message = stringPtr("fix it")
+	commits[0].Files = []github.CommitFile{
+		{Filename: stringPtr("a.go"), Additions: intPtr(30), Deletions: intPtr(5)},
+	}
+
+	client, server, mux := github_test.InitServer(t, nil, nil, nil, nil, nil)
+	defer server.Close()
+	github_test.ServeGraphQL(t, mux, map[int]github_test.GraphQLPR{
+		1: {PR: pr, Events: events, Status: status, Commits: commits},
+	})
+	config := &Config{Org: "o", Project: "r"}
+	config.SetClient(client)
+
+	objs, err := config.fetchPRsInBulk([]int{1, 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(objs) != 1 {
+		t.Fatalf("expected only PR 1, got %v", objs)
+	}
+	obj := objs[1]
+	if obj == nil {
+		t.Fatalf("PR 1 was not fetched")
+	}
+	if *obj.pr.Number != 1 || *obj.pr.User.Login != "bob" || *obj.pr.Head.SHA != "mysha" || obj.pr.Mergeable == nil || !*obj.pr.Mergeable {
+		t.Errorf("unexpected PR: %#v", obj.pr)
+	}
+	if *obj.pr.Additions != 30 || *obj.pr.Deletions != 5 || obj.pr.MergedAt == nil || !obj.pr.MergedAt.Equal(time.Unix(30, 0)) || *obj.pr.Base.SHA != "basesha" {
+		t.Errorf("unexpected PR size, merge time or base: %#v", obj.pr)
+	}
+
+	gotCommits := obj.prefetched.commits
+	if len(gotCommits) != 1 || *gotCommits[0].SHA != "mysha0" || *gotCommits[0].Commit.Message != "fix it" || !gotCommits[0].Commit.Committer.Date.Equal(time.Unix(15, 0)) {
+		t.Errorf("unexpected commits: %#v", gotCommits)
+	}
+	gotFiles := obj.prefetched.files
+	if len(gotFiles) != 1 || *gotFiles[0].Filename != "a.go" || *gotFiles[0].Additions != 30 || *gotFiles[0].Changes != 35 {
+		t.Errorf("unexpected files: %#v", gotFiles)
+	}
+
+	got := obj.prefetched.events
+	if len(got) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(got))
+	}
+	if *got[0].Event != "labeled" || *got[0].Label.Name != "lgtm" || *got[0].Actor.Login != "alice" || !got[0].CreatedAt.Equal(time.Unix(10, 0)) {
+		t.Errorf("unexpected label event: %#v", got[0])
+	}
+	if *got[1].Event != "head_ref_force_pushed" || *got[1].CommitID != "mysha" {
+		t.Errorf("unexpected push event: %#v", got[1])
+	}
+
+	gotStatus := obj.prefetched.status
+	if gotStatus == nil || *gotStatus.State != "failure" || *gotStatus.SHA != "mysha" || len(gotStatus.Statuses) != 2 {
+		t.Fatalf("unexpected status: %#v", gotStatus)
+	}
+	states := map[string]string{}
+	for _, s := range gotStatus.Statuses {
+		states[*s.Context] = *s.State
+	}
+	if !reflect.DeepEqual(states, map[string]string{"unit": "success", "e2e": "failure"}) {
+		t.Errorf("unexpected contexts: %v", states)
+	}
+}
+
+func TestPrefetchFallsBackToREST(t *testing.T) {
+	pr := github_test.PullRequest("bob", false, true, true)
+	restEvents := github_test.Events([]github_test.LabelTime{
+		{User: "alice", Label: "lgtm", Time: 10},
+		{User: "alice", Label: "ok-to-merge", Time: 20},
+	})
+	restStatus := github_test.Status("mysha", []string{"unit"}, nil, nil, nil)
+
+	tests := []struct {
+		name          string
+		graphql       bool
+		changeLabels  bool
+		expectEvents  int
+		expectRESTPRs bool
+	}{
+		{name: "bulk", graphql: true, expectEvents: 1},
+		{name: "labels changed", graphql: true, changeLabels: true, expectEvents: 2},
+		{name: "no graphql", expectEvents: 2, expectRESTPRs: true},
+	}
+	for _, test := range tests {
+		client, server, mux := github_test.InitServer(t, nil, pr, restEvents, nil, restStatus)
+		if test.graphql {
+			github_test.ServeGraphQL(t, mux, map[int]github_test.GraphQLPR{
+				1: {PR: pr, Events: restEvents[:1], Status: restStatus},
+			})
+		} else {
+			mux.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			})
+		}
+		mux.HandleFunc("/repos/o/r/issues/1/labels", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("[]"))
+		})
+		config := &Config{Org: "o", Project: "r"}
+		config.SetClient(client)
+
+		obj := &MungeObject{config: config, Issue: github_test.Issue("bob", 1, nil, true)}
+		config.prefetch([]*MungeObject{obj})
+		if test.expectRESTPRs != (obj.pr == nil) {
+			t.Errorf("%s: unexpected prefetched PR %v", test.name, obj.pr)
+		}
+		if test.changeLabels {
+			obj.AddLabels([]string{"foo"})
+		}
+		events, err := obj.GetEvents()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if len(events) != test.expectEvents {
+			t.Errorf("%s: expected %d events, got %d", test.name, test.expectEvents, len(events))
+		}
+		if !obj.IsStatusSuccess([]string{"unit"}) {
+			t.Errorf("%s: expected status success", test.name)
+		}
+		server.Close()
+	}
+}
@@ -22,9 +22,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strconv"
 	"strings"
-	"sync"
 	"text/tabwriter"
 	"time"
 
@@ -39,69 +37,26 @@ import (
 
 const (
 	// stolen from https://groups.google.com/forum/#!msg/golang-nuts/a9PitPAHSSU/ziQw1-QHw3EJ
-	maxInt          = int(^uint(0) >> 1)
-	tokenLimit      = 500 // How many github api tokens to not use
-	asyncTokenLimit = 400 // How many github api tokens to not use for 'asyc' calls
+	maxInt     = int(^uint(0) >> 1)
+	tokenLimit = 500 // How many github api tokens to reserve for critical calls
 
 	headerRateRemaining = "X-RateLimit-Remaining"
 	headerRateReset     = "X-RateLimit-Reset"
-)
-
-type callLimitRoundTripper struct {
-	sync.Mutex
-	delegate  http.RoundTripper
-	remaining int
-	resetTime time.Time
-}
-
-func (c *callLimitRoundTripper) getTokenExcept(remaining int) {
-	c.Lock()
-	if c.remaining > remaining {
-		c.remaining--
-		c.Unlock()
-		return
-	}
-	resetTime := c.resetTime
-	c.Unlock()
-	sleepTime := resetTime.Sub(time.Now()) + (1 * time.Minute)
-	if sleepTime > 0 {
-		glog.Errorf("*****************")
-		glog.Errorf("Ran out of github API tokens. Sleeping for %v minutes", sleepTime.Minutes())
-		glog.Errorf("*****************")
-	}
-	// negative duration is fine, it means we are past the github api reset and we won't sleep
-	time.Sleep(sleepTime)
-}
+	headerRateResource  = "X-RateLimit-Resource"
 
-func (c *callLimitRoundTripper) getToken() {
-	c.getTokenExcept(tokenLimit)
-}
+	// MergeStrategyMerge creates a merge commit
+	MergeStrategyMerge = "merge"
+	// MergeStrategySquash squashes all commits in the PR into one commit
+	MergeStrategySquash = "squash"
+	// MergeStrategyRebase rebases the commits in the PR onto the base branch
+	MergeStrategyRebase = "rebase"
 
-func (c *callLimitRoundTripper) getAsyncToken() {
-	c.getTokenExcept(asyncTokenLimit)
-}
+	// MergeCommitMessage is the message of merge commits made by MergePR
+	MergeCommitMessage = "Auto commit by PR queue bot"
 
-func (c *callLimitRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	if c.delegate == nil {
-		c.delegate = http.DefaultTransport
-	}
-	// TODO Be smart about which should use getToken and which should use getAsyncToken()
-	c.getToken()
-	resp, err := c.delegate.RoundTrip(req)
-	c.Lock()
-	defer c.Unlock()
-	if resp != nil {
-		if remaining := resp.Header.Get(headerRateRemaining); remaining != "" {
-			c.remaining, _ = strconv.Atoi(remaining)
-		}
-		if reset := resp.Header.Get(headerRateReset); reset != "" {
-			if v, _ := strconv.ParseInt(reset, 10, 64); v != 0 {
-				c.resetTime = time.Unix(v, 0)
-			}
-		}
-	}
-	return resp, err
-}
+	// squash and rebase merges are only available in this API preview
+	mergeMethodPreview = "application/vnd.github.polaris-preview+json"
+)
 
 // By default github responds to PR requests with:
 //    Cache-Control:[private, max-age=60, s-maxage=60]
@@ -130,9 +85,18 @@ func (r *zeroCacheRoundTripper) RoundTrip(req *http.Request) (*http.Response, er
 // methods for doing so.
 type Config struct {
 	client   *github.Client
-	apiLimit *callLimitRoundTripper
-	Org      string
-	Project  string
+	apiLimit *callBudget
+	// transport is the http transport under client
+	transport http.RoundTripper
+	// clients and transports make calls at each priority, see WithPriority
+	clients    [numPriorities]*github.Client
+	transports [numPriorities]http.RoundTripper
+	// parent is set on Configs made by WithPriority
+	parent *Config
+	// heads is what we have seen of PR heads, see headTracker()
+	heads   *headTracker
+	Org     string
+	Project string
 
 	Token     string
 	TokenFile string
@@ -146,6 +110,17 @@ type Config struct {
 	// Defaults to 30 seconds.
 	PendingWaitTime *time.Duration
 
+	// How many API tokens only critical calls, like merges, may use
+	CriticalReserve int
+
+	// If true, get PRs, their events and statuses with a few GraphQL
+	// queries instead of many REST calls
+	BulkFetch bool
+
+	// File to save the head SHAs of PRs when labels were added to, so they
+	// survive restarts. Empty keeps them in memory only.
+	HeadStateFile string
+
 	useMemoryCache bool
 
 	// When we clear analytics we store the last values here
@@ -160,10 +135,10 @@ type analytic struct {
 
 func (a *analytic) Call(config *Config, response *github.Response) {
 	if response != nil && response.Response.Header.Get(httpcache.XFromCache) != "" {
-		config.analytics.cachedAPICount++
+		config.stats().cachedAPICount++
 		a.CachedCount++
 	}
-	config.analytics.apiCount++
+	config.stats().apiCount++
 	a.Count++
 }
 
@@ -180,9 +155,12 @@ type analytics struct {
 	GetIssue          analytic
 	ListIssues        analytic
 	ListIssueEvents   analytic
+	ListComments      analytic
 	ListCommits       analytic
 	GetCommit         analytic
+	ListFiles         analytic
 	GetCombinedStatus analytic
+	ListStatuses      analytic
 	SetStatus         analytic
 	GetPR             analytic
 	AssignPR          analytic
@@ -192,6 +170,10 @@ type analytics struct {
 	CreateComment     analytic
 	Merge             analytic
 	GetUser           analytic
+	GraphQL           analytic
+	ListTeams         analytic
+	ListTeamMembers   analytic
+	ListOrgMembers    analytic
 }
 
 func (a analytics) print() {
@@ -206,9 +188,12 @@ func (a analytics) print() {
 	fmt.Fprintf(w, "GetIssue\t%d\t\n", a.GetIssue.Count)
 	fmt.Fprintf(w, "ListIssues\t%d\t\n", a.ListIssues.Count)
 	fmt.Fprintf(w, "ListIssueEvents\t%d\t\n", a.ListIssueEvents.Count)
+	fmt.Fprintf(w, "ListComments\t%d\t\n", a.ListComments.Count)
 	fmt.Fprintf(w, "ListCommits\t%d\t\n", a.ListCommits.Count)
 	fmt.Fprintf(w, "GetCommit\t%d\t\n", a.GetCommit.Count)
+	fmt.Fprintf(w, "ListFiles\t%d\t\n", a.ListFiles.Count)
 	fmt.Fprintf(w, "GetCombinedStatus\t%d\t\n", a.GetCombinedStatus.Count)
+	fmt.Fprintf(w, "ListStatuses\t%d\t\n", a.ListStatuses.Count)
 	fmt.Fprintf(w, "SetStatus\t%d\t\n", a.SetStatus.Count)
 	fmt.Fprintf(w, "GetPR\t%d\t\n", a.GetPR.Count)
 	fmt.Fprintf(w, "AssignPR\t%d\t\n", a.AssignPR.Count)
@@ -218,6 +203,10 @@ func (a analytics) print() {
 	fmt.Fprintf(w, "CreateComment\t%d\t\n", a.CreateComment.Count)
 	fmt.Fprintf(w, "Merge\t%d\t\n", a.Merge.Count)
 	fmt.Fprintf(w, "GetUser\t%d\t\n", a.GetUser.Count)
+	fmt.Fprintf(w, "GraphQL\t%d\t\n", a.GraphQL.Count)
+	fmt.Fprintf(w, "ListTeams\t%d\t\n", a.ListTeams.Count)
+	fmt.Fprintf(w, "ListTeamMembers\t%d\t\n", a.ListTeamMembers.Count)
+	fmt.Fprintf(w, "ListOrgMembers\t%d\t\n", a.ListOrgMembers.Count)
 	w.Flush()
 	glog.V(2).Infof("\n%v", buf)
 }
@@ -229,7 +218,15 @@ type MungeObject struct {
 	Issue   *github.Issue
 	pr      *github.PullRequest
 	commits []github.RepositoryCommit
+	files   []github.CommitFile
 	events  []github.IssueEvent
+
+	// prefetched is set if the PR was fetched in bulk
+	prefetched *prefetched
+	// pushed is when the commits were pushed if we had to fetch that
+	// separately, see pushes()
+	pushed        []headChange
+	pushesFetched bool
 }
 
 // DebugStats is a structure that tells information about how we have interacted
@@ -242,6 +239,11 @@ type DebugStats struct {
 	NextLoopTime   time.Time
 	LimitRemaining int
 	LimitResetTime time.Time
+	// LimitExhaustionTime is when we will run out of tokens at the current
+	// rate. Zero if they will last until LimitResetTime.
+	LimitExhaustionTime time.Time
+	// LimitUsed is how many tokens each priority has used
+	LimitUsed map[string]int
 }
 
 // TestObject should NEVER be used outside of _test.go code. It creates a
@@ -263,6 +265,9 @@ func (config *Config) AddRootFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().StringVar(&config.TokenFile, "token-file", "", "The file containing the OAuth Token to use for requests.")
 	cmd.PersistentFlags().IntVar(&config.MinPRNumber, "min-pr-number", 0, "The minimum PR to start with")
 	cmd.PersistentFlags().IntVar(&config.MaxPRNumber, "max-pr-number", maxInt, "The maximum PR to start with")
+	cmd.PersistentFlags().IntVar(&config.CriticalReserve, "api-critical-reserve", tokenLimit, "How many github API tokens only critical calls, like merges, may use. Reports and background work leave even more unused")
+	cmd.PersistentFlags().BoolVar(&config.BulkFetch, "bulk-fetch", false, "If true, fetch PRs, their events and statuses in bulk with the GraphQL API. Anything else is still fetched over REST")
+	cmd.PersistentFlags().StringVar(&config.HeadStateFile, "head-state-file", "", "File to save the head SHAs of PRs when labels like lgtm were added to, so they survive restarts. If empty, they are only kept in memory")
 	cmd.PersistentFlags().BoolVar(&config.DryRun, "dry-run", false, "If true, don't actually merge anything")
 	cmd.PersistentFlags().BoolVar(&config.useMemoryCache, "use-http-cache", true, "If true, use a client side HTTP cache for API requests.")
 	cmd.PersistentFlags().StringVar(&config.Org, "organization", "kubernetes", "The github organization to scan")
@@ -295,39 +300,48 @@ func (config *Config) PreExecute() error {
 	//    webCacheRoundTripper // if we are using the cache
 	//    callLimitRoundTripper ** always
 	//    [http.DefaultTransport] ** always implicit
+	// Every priority gets its own chain, sharing the budget, cache and token.
 
-	var transport http.RoundTripper
-
-	callLimitTransport := &callLimitRoundTripper{
-		remaining: tokenLimit + 500, // put in 500 so we at least have a couple to check our real limits
-		resetTime: time.Now().Add(1 * time.Minute),
-	}
-	config.apiLimit = callLimitTransport
-	transport = callLimitTransport
+	config.apiLimit = newCallBudget(config.CriticalReserve)
 
+	var cache httpcache.Cache
 	if config.useMemoryCache {
-		t := httpcache.NewMemoryCacheTransport()
-		t.Transport = transport
+		cache = httpcache.NewMemoryCache()
+	}
+	var tokenSource oauth2.TokenSource
+	if len(token) > 0 {
+		tokenSource = oauth2.ReuseTokenSource(nil, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	}
 
-		zeroCacheTransport := &zeroCacheRoundTripper{
-			delegate: t,
+	for p := Priority(0); int(p) < numPriorities; p++ {
+		var transport http.RoundTripper
+		transport = &callLimitRoundTripper{
+			budget:   config.apiLimit,
+			priority: p,
 		}
 
-		transport = zeroCacheTransport
-	}
+		if cache != nil {
+			t := httpcache.NewTransport(cache)
+			t.Transport = transport
 
-	if len(token) > 0 {
-		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
-		transport = &oauth2.Transport{
-			Base:   transport,
-			Source: oauth2.ReuseTokenSource(nil, ts),
+			zeroCacheTransport := &zeroCacheRoundTripper{
+				delegate: t,
+			}
+
+			transport = zeroCacheTransport
 		}
-	}
 
-	client := &http.Client{
-		Transport: transport,
+		if tokenSource != nil {
+			transport = &oauth2.Transport{
+				Base:   transport,
+				Source: tokenSource,
+			}
+		}
+		config.setPriorityTransport(p, transport)
 	}
-	config.client = github.NewClient(client)
+
+	config.transport = config.transports[PriorityLoop]
+	config.client = config.clients[PriorityLoop]
 	config.ResetAPICount()
 	return nil
 }
@@ -346,6 +360,11 @@ func (config *Config) GetDebugStats() DebugStats {
 	defer config.apiLimit.Unlock()
 	d.LimitRemaining = config.apiLimit.remaining
 	d.LimitResetTime = config.apiLimit.resetTime
+	d.LimitExhaustionTime = config.apiLimit.exhaustionTime(time.Now())
+	d.LimitUsed = map[string]int{}
+	for p, used := range config.apiLimit.used {
+		d.LimitUsed[Priority(p).String()] = used
+	}
 	return d
 }
 
@@ -375,7 +394,7 @@ func (config *Config) SetClient(client *github.Client) {
 // GetObject will return an object (with only the issue filled in)
 func (config *Config) GetObject(num int) (*MungeObject, error) {
 	issue, resp, err := config.client.Issues.Get(config.Org, config.Project, num)
-	config.analytics.GetIssue.Call(config, resp)
+	config.stats().GetIssue.Call(config, resp)
 	if err != nil {
 		glog.Errorf("GetObject: %v", err)
 		return nil, err
@@ -387,24 +406,13 @@ func (config *Config) GetObject(num int) (*MungeObject, error) {
 	return obj, nil
 }
 
-// LastModifiedTime returns the time the last commit was made
-// BUG: this should probably return the last time a git push happened or something like that.
-func (obj *MungeObject) LastModifiedTime() *time.Time {
-	var lastModified *time.Time
-	commits, err := obj.GetCommits()
-	if err != nil {
-		return lastModified
-	}
-	for _, commit := range commits {
-		if commit.Commit == nil || commit.Commit.Committer == nil || commit.Commit.Committer.Date == nil {
-			glog.Errorf("PR %d: Found invalid RepositoryCommit: %v", *obj.Issue.Number, commit)
-			continue
-		}
-		if lastModified == nil || commit.Commit.Committer.Date.After(*lastModified) {
-			lastModified = commit.Commit.Committer.Date
-		}
+// ObjectFromIssue returns an object for an issue which was already retrieved,
+// for example by ListAllIssues, without asking github for it again.
+func (config *Config) ObjectFromIssue(issue *github.Issue) *MungeObject {
+	return &MungeObject{
+		config: config,
+		Issue:  issue,
 	}
-	return lastModified
 }
 
 // labelEvent returns the most recent event where the given label was added to an issue
@@ -439,7 +447,7 @@ func (obj *MungeObject) LabelTime(label string) *time.Time {
 // LabelCreator returns the login name of the user who (last) created the given label
 func (obj *MungeObject) LabelCreator(label string) string {
 	event := obj.labelEvent(label)
-	if event == nil {
+	if event == nil || event.Actor == nil || event.Actor.Login == nil {
 		return ""
 	}
 	return *event.Actor.Login
@@ -493,8 +501,9 @@ func GetLabelsWithPrefix(labels []github.Label, prefix string) []string {
 func (obj *MungeObject) AddLabels(labels []string) error {
 	config := obj.config
 	prNum := *obj.Issue.Number
-	config.analytics.AddLabels.Call(config, nil)
+	config.stats().AddLabels.Call(config, nil)
 	glog.Infof("Adding labels %v to PR %d", labels, prNum)
+	obj.forgetEvents()
 	if config.DryRun {
 		return nil
 	}
@@ -527,8 +536,9 @@ func (obj *MungeObject) RemoveLabel(label string) error {
 		obj.Issue.Labels = append(obj.Issue.Labels[:which], obj.Issue.Labels[which+1:]...)
 	}
 
-	config.analytics.RemoveLabels.Call(config, nil)
+	config.stats().RemoveLabels.Call(config, nil)
 	glog.Infof("Removing label %q to PR %d", label, prNum)
+	obj.forgetEvents()
 	if config.DryRun {
 		return nil
 	}
@@ -552,7 +562,7 @@ func (config *Config) fetchAllCollaborators() ([]github.User, error) {
 		if err != nil {
 			return nil, err
 		}
-		config.analytics.ListCollaborators.Call(config, response)
+		config.stats().ListCollaborators.Call(config, response)
 		result = append(result, users...)
 		if response.LastPage == 0 || response.LastPage <= page {
 			break
@@ -592,10 +602,105 @@ func (config *Config) UsersWithAccess() ([]github.User, []github.User, error) {
 	return pushUsers, pullUsers, nil
 }
 
+// TeamMembers returns the members of each of the `teams` in the org, by the
+// name used in `teams`. Teams may be given by name or by slug. If only some of
+// the teams could be listed their members are returned along with the error.
+func (config *Config) TeamMembers(teams []string) (map[string][]github.User, error) {
+	wanted := map[string]bool{}
+	for _, team := range teams {
+		wanted[team] = true
+	}
+	out := map[string][]github.User{}
+	failed := map[string]error{}
+	page := 1
+	for {
+		listOpts := &github.ListOptions{PerPage: 100, Page: page}
+		orgTeams, response, err := config.client.Organizations.ListTeams(config.Org, listOpts)
+		config.stats().ListTeams.Call(config, response)
+		if err != nil {
+			return nil, err
+		}
+		for _, team := range orgTeams {
+			if team.ID == nil {
+				continue
+			}
+			name := ""
+			if team.Name != nil && wanted[*team.Name] {
+				name = *team.Name
+			} else if team.Slug != nil && wanted[*team.Slug] {
+				name = *team.Slug
+			} else {
+				continue
+			}
+			members, err := config.fetchAllTeamMembers(*team.ID)
+			if err != nil {
+				failed[name] = err
+				continue
+			}
+			out[name] = members
+		}
+		if response.LastPage == 0 || response.LastPage <= page {
+			break
+		}
+		page++
+	}
+	errs := []string{}
+	for _, team := range teams {
+		if err, ok := failed[team]; ok {
+			errs = append(errs, fmt.Sprintf("unable to list members of team %q: %v", team, err))
+		} else if _, ok := out[team]; !ok {
+			errs = append(errs, fmt.Sprintf("team %q not found in %s", team, config.Org))
+		}
+	}
+	if len(errs) > 0 {
+		return out, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return out, nil
+}
+
+func (config *Config) fetchAllTeamMembers(team int) ([]github.User, error) {
+	page := 1
+	var result []github.User
+	for {
+		listOpts := &github.OrganizationListTeamMembersOptions{ListOptions: github.ListOptions{PerPage: 100, Page: page}}
+		users, response, err := config.client.Organizations.ListTeamMembers(team, listOpts)
+		config.stats().ListTeamMembers.Call(config, response)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, users...)
+		if response.LastPage == 0 || response.LastPage <= page {
+			break
+		}
+		page++
+	}
+	return result, nil
+}
+
+// OrgMembers returns every member of the org
+func (config *Config) OrgMembers() ([]github.User, error) {
+	page := 1
+	var result []github.User
+	for {
+		listOpts := &github.ListMembersOptions{ListOptions: github.ListOptions{PerPage: 100, Page: page}}
+		users, response, err := config.client.Organizations.ListMembers(config.Org, listOpts)
+		config.stats().ListOrgMembers.Call(config, response)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, users...)
+		if response.LastPage == 0 || response.LastPage <= page {
+			break
+		}
+		page++
+	}
+	return result, nil
+}
+
 // GetUser will return information about the github user with the given login name
 func (config *Config) GetUser(login string) (*github.User, error) {
 	user, response, err := config.client.Users.Get(login)
-	config.analytics.GetUser.Call(config, response)
+	config.stats().GetUser.Call(config, response)
 	return user, err
 }
 
@@ -609,13 +714,16 @@ func (obj *MungeObject) IsPR() bool {
 
 // GetEvents returns a list of all events for a given pr.
 func (obj *MungeObject) GetEvents() ([]github.IssueEvent, error) {
+	if obj.prefetched != nil && obj.prefetched.events != nil {
+		return obj.prefetched.events, nil
+	}
 	config := obj.config
 	prNum := *obj.Issue.Number
 	events := []github.IssueEvent{}
 	page := 1
 	for {
 		eventPage, response, err := config.client.Issues.ListIssueEvents(config.Org, config.Project, prNum, &github.ListOptions{PerPage: 100, Page: page})
-		config.analytics.ListIssueEvents.Call(config, response)
+		config.stats().ListIssueEvents.Call(config, response)
 		if err != nil {
 			glog.Errorf("Error getting events for issue: %v", err)
 			return nil, err
@@ -630,6 +738,29 @@ func (obj *MungeObject) GetEvents() ([]github.IssueEvent, error) {
 	return events, nil
 }
 
+// GetComments returns all of the comments on the issue or PR
+func (obj *MungeObject) GetComments() ([]github.IssueComment, error) {
+	config := obj.config
+	prNum := *obj.Issue.Number
+	comments := []github.IssueComment{}
+	page := 1
+	for {
+		listOpts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100, Page: page}}
+		commentPage, response, err := config.client.Issues.ListComments(config.Org, config.Project, prNum, listOpts)
+		config.stats().ListComments.Call(config, response)
+		if err != nil {
+			glog.Errorf("Error getting comments for issue %d: %v", prNum, err)
+			return nil, err
+		}
+		comments = append(comments, commentPage...)
+		if response.LastPage == 0 || response.LastPage <= page {
+			break
+		}
+		page++
+	}
+	return comments, nil
+}
+
 func computeStatus(combinedStatus *github.CombinedStatus, requiredContexts []string) string {
 	states := sets.String{}
 	providers := sets.String{}
@@ -665,6 +796,9 @@ func computeStatus(combinedStatus *github.CombinedStatus, requiredContexts []str
 }
 
 func (obj *MungeObject) getCombinedStatus() (status *github.CombinedStatus) {
+	if obj.prefetched != nil && obj.prefetched.status != nil {
+		return obj.prefetched.status
+	}
 	config := obj.config
 	pr, err := obj.GetPR()
 	if err != nil {
@@ -676,7 +810,7 @@ func (obj *MungeObject) getCombinedStatus() (status *github.CombinedStatus) {
 	}
 	// TODO If we have more than 100 statuses we need to deal with paging.
 	combinedStatus, response, err := config.client.Repositories.GetCombinedStatus(config.Org, config.Project, *pr.Head.SHA, &github.ListOptions{})
-	config.analytics.GetCombinedStatus.Call(config, response)
+	config.stats().GetCombinedStatus.Call(config, response)
 	if err != nil {
 		glog.Errorf("Failed to get combined status: %v", err)
 		return nil
@@ -699,7 +833,8 @@ func (obj *MungeObject) SetStatus(state, url, description, context string) error
 	}
 	ref := *pr.Head.SHA
 	glog.Infof("PR %d setting %q Github status to %q", *obj.Issue.Number, context, description)
-	config.analytics.SetStatus.Call(config, nil)
+	obj.forgetStatus()
+	config.stats().SetStatus.Call(config, nil)
 	if config.DryRun {
 		return nil
 	}
@@ -710,6 +845,35 @@ func (obj *MungeObject) SetStatus(state, url, description, context string) error
 	return err
 }
 
+// GetStatuses returns every status ever set on the head of the PR, not just
+// the most recent status for each context. Newest statuses are first.
+func (obj *MungeObject) GetStatuses() ([]github.RepoStatus, error) {
+	config := obj.config
+	pr, err := obj.GetPR()
+	if err != nil {
+		return nil, err
+	}
+	if pr.Head == nil || pr.Head.SHA == nil {
+		return nil, fmt.Errorf("PR %d has no head SHA", *obj.Issue.Number)
+	}
+	statuses := []github.RepoStatus{}
+	page := 1
+	for {
+		statusPage, response, err := config.client.Repositories.ListStatuses(config.Org, config.Project, *pr.Head.SHA, &github.ListOptions{PerPage: 100, Page: page})
+		config.stats().ListStatuses.Call(config, response)
+		if err != nil {
+			glog.Errorf("Error getting statuses for PR %d: %v", *obj.Issue.Number, err)
+			return nil, err
+		}
+		statuses = append(statuses, statusPage...)
+		if response.LastPage == 0 || response.LastPage <= page {
+			break
+		}
+		page++
+	}
+	return statuses, nil
+}
+
 // GetStatus returns the actual requested status, or nil if not found
 func (obj *MungeObject) GetStatus(context string) *github.RepoStatus {
 	combinedStatus := obj.getCombinedStatus()
@@ -768,6 +932,7 @@ func timeout(sleepTime time.Duration, c chan bool) {
 func (obj *MungeObject) doWaitStatus(pending bool, requiredContexts []string, c chan error) {
 	config := obj.config
 	for {
+		obj.forgetStatus()
 		status := obj.GetStatusState(requiredContexts)
 		var done bool
 		if pending {
@@ -831,17 +996,22 @@ func (obj *MungeObject) WaitForNotPending(requiredContexts []string) error {
 	}
 }
 
-// GetCommits returns all of the commits for a given PR
+// GetCommits returns all of the commits for a given PR. Commits fetched in
+// bulk have no Files, use ListFiles for the files changed by the PR.
 func (obj *MungeObject) GetCommits() ([]github.RepositoryCommit, error) {
 	if obj.commits != nil {
 		return obj.commits, nil
 	}
+	if obj.prefetched != nil && obj.prefetched.commits != nil {
+		obj.commits = obj.prefetched.commits
+		return obj.commits, nil
+	}
 	config := obj.config
 	commits := []github.RepositoryCommit{}
 	page := 0
 	for {
 		commitsPage, response, err := config.client.PullRequests.ListCommits(config.Org, config.Project, *obj.Issue.Number, &github.ListOptions{PerPage: 100, Page: page})
-		config.analytics.ListCommits.Call(config, response)
+		config.stats().ListCommits.Call(config, response)
 		if err != nil {
 			glog.Errorf("Error commits for PR %d: %v", *obj.Issue.Number, err)
 			return nil, err
@@ -860,7 +1030,7 @@ func (obj *MungeObject) GetCommits() ([]github.RepositoryCommit, error) {
 			continue
 		}
 		commit, response, err := config.client.Repositories.GetCommit(config.Org, config.Project, *c.SHA)
-		config.analytics.GetCommit.Call(config, response)
+		config.stats().GetCommit.Call(config, response)
 		if err != nil {
 			glog.Errorf("Can't load commit %s %s %s: %v", config.Org, config.Project, *c.SHA, err)
 			continue
@@ -871,20 +1041,66 @@ func (obj *MungeObject) GetCommits() ([]github.RepositoryCommit, error) {
 	return filledCommits, nil
 }
 
+// ListFiles returns the files changed by the PR, with the lines added and
+// deleted in each.
+func (obj *MungeObject) ListFiles() ([]github.CommitFile, error) {
+	if obj.files != nil {
+		return obj.files, nil
+	}
+	if obj.prefetched != nil && obj.prefetched.files != nil {
+		obj.files = obj.prefetched.files
+		return obj.files, nil
+	}
+	config := obj.config
+	files := []github.CommitFile{}
+	page := 1
+	for {
+		filesPage, response, err := config.client.PullRequests.ListFiles(config.Org, config.Project, *obj.Issue.Number, &github.ListOptions{PerPage: 100, Page: page})
+		config.stats().ListFiles.Call(config, response)
+		if err != nil {
+			glog.Errorf("Error listing files for PR %d: %v", *obj.Issue.Number, err)
+			return nil, err
+		}
+		files = append(files, filesPage...)
+		if response.LastPage == 0 || response.LastPage <= page {
+			break
+		}
+		page++
+	}
+	obj.files = files
+	return files, nil
+}
+
 // RefreshPR will get the PR again, in case anything changed since last time
 func (obj *MungeObject) RefreshPR() (*github.PullRequest, error) {
 	config := obj.config
 	issueNum := *obj.Issue.Number
 	pr, response, err := config.client.PullRequests.Get(config.Org, config.Project, issueNum)
-	config.analytics.GetPR.Call(config, response)
+	config.stats().GetPR.Call(config, response)
 	if err != nil {
 		glog.Errorf("Error getting PR# %d: %v", issueNum, err)
 		return nil, err
 	}
 	obj.pr = pr
+	obj.observeHead()
+	obj.forgetStatus()
 	return pr, nil
 }
 
+// forgetStatus makes the next status lookup go to github
+func (obj *MungeObject) forgetStatus() {
+	if obj.prefetched != nil {
+		obj.prefetched.status = nil
+	}
+}
+
+// forgetEvents makes the next events lookup go to github
+func (obj *MungeObject) forgetEvents() {
+	if obj.prefetched != nil {
+		obj.prefetched.events = nil
+	}
+}
+
 // GetPR will update the PR in the object.
 func (obj *MungeObject) GetPR() (*github.PullRequest, error) {
 	if obj.pr != nil {
@@ -901,7 +1117,7 @@ func (obj *MungeObject) AssignPR(owner string) error {
 	config := obj.config
 	prNum := *obj.Issue.Number
 	assignee := &github.IssueRequest{Assignee: &owner}
-	config.analytics.AssignPR.Call(config, nil)
+	config.stats().AssignPR.Call(config, nil)
 	glog.Infof("Assigning PR# %d  to %v", prNum, owner)
 	if config.DryRun {
 		return nil
@@ -920,7 +1136,7 @@ func (obj *MungeObject) ClosePR() error {
 	if err != nil {
 		return err
 	}
-	config.analytics.ClosePR.Call(config, nil)
+	config.stats().ClosePR.Call(config, nil)
 	glog.Infof("Closing PR# %d", *pr.Number)
 	if config.DryRun {
 		return nil
@@ -943,7 +1159,7 @@ func (obj *MungeObject) OpenPR(numTries int) error {
 	if err != nil {
 		return err
 	}
-	config.analytics.OpenPR.Call(config, nil)
+	config.stats().OpenPR.Call(config, nil)
 	glog.Infof("Opening PR# %d", *pr.Number)
 	if config.DryRun {
 		return nil
@@ -973,7 +1189,7 @@ func (obj *MungeObject) GetFileContents(file, sha string) (string, error) {
 		getOpts.Ref = sha
 	}
 	output, _, response, err := config.client.Repositories.GetContents(config.Org, config.Project, file, getOpts)
-	config.analytics.GetContents.Call(config, response)
+	config.stats().GetContents.Call(config, response)
 	if err != nil {
 		err = fmt.Errorf("unable to get %q at commit %q", file, sha)
 		// I'm using .V(2) because .generated docs is still not in the repo...
@@ -993,20 +1209,66 @@ func (obj *MungeObject) GetFileContents(file, sha string) (string, error) {
 	return string(b), nil
 }
 
+// MergeOptions describe how a PR should be merged
+type MergeOptions struct {
+	// Strategy is one of MergeStrategyMerge, MergeStrategySquash or
+	// MergeStrategyRebase. Empty means MergeStrategyMerge.
+	Strategy string
+	// CommitTitle and CommitMessage are used for the merge or squash
+	// commit. They are ignored when rebasing.
+	CommitTitle   string
+	CommitMessage string
+}
+
+type mergeRequest struct {
+	CommitTitle   string `json:"commit_title,omitempty"`
+	CommitMessage string `json:"commit_message,omitempty"`
+	MergeMethod   string `json:"merge_method,omitempty"`
+}
+
+func (config *Config) doMerge(prNum int, opts *MergeOptions) error {
+	body := &mergeRequest{
+		CommitTitle:   opts.CommitTitle,
+		CommitMessage: opts.CommitMessage,
+	}
+	u := fmt.Sprintf("repos/%v/%v/pulls/%d/merge", config.Org, config.Project, prNum)
+	if opts.Strategy != "" && opts.Strategy != MergeStrategyMerge {
+		body.MergeMethod = opts.Strategy
+	}
+	req, err := config.client.NewRequest("PUT", u, body)
+	if err != nil {
+		return err
+	}
+	if body.MergeMethod != "" {
+		req.Header.Set("Accept", mergeMethodPreview)
+	}
+	_, err = config.client.Do(req, &github.PullRequestMergeResult{})
+	return err
+}
+
 // MergePR will merge the given PR, duh
 // "who" is who is doing the merging, like "submit-queue"
 func (obj *MungeObject) MergePR(who string) error {
+	return obj.MergePRWithOptions(who, &MergeOptions{
+		Strategy:      MergeStrategyMerge,
+		CommitMessage: MergeCommitMessage,
+	})
+}
+
+// MergePRWithOptions will merge the given PR using the strategy and commit
+// message in `opts`. "who" is who is doing the merging, like "submit-queue"
+func (obj *MungeObject) MergePRWithOptions(who string, opts *MergeOptions) error {
 	config := obj.config
 	prNum := *obj.Issue.Number
-	config.analytics.Merge.Call(config, nil)
-	glog.Infof("Merging PR# %d", prNum)
+	config.stats().Merge.Call(config, nil)
+	glog.Infof("Merging PR# %d using %q", prNum, opts.Strategy)
 	if config.DryRun {
 		return nil
 	}
 	mergeBody := "Automatic merge from " + who
 	obj.WriteComment(mergeBody)
 
-	_, _, err := config.client.PullRequests.Merge(config.Org, config.Project, prNum, "Auto commit by PR queue bot")
+	err := config.doMerge(prNum, opts)
 
 	// The github API https://developer.github.com/v3/pulls/#merge-a-pull-request-merge-button indicates
 	// we will only get the bellow error if we provided a particular sha to merge PUT. We aren't doing that
@@ -1016,7 +1278,7 @@ func (obj *MungeObject) MergePR(who string) error {
 	// then merge this PR, so try again.
 	if err != nil && strings.Contains(err.Error(), "branch was modified. Review and try the merge again.") {
 		if mergeable, _ := obj.IsMergeable(); mergeable {
-			_, _, err = config.client.PullRequests.Merge(config.Org, config.Project, prNum, "Auto commit by PR queue bot")
+			err = config.doMerge(prNum, opts)
 		}
 	}
 	if err != nil {
@@ -1030,7 +1292,7 @@ func (obj *MungeObject) MergePR(who string) error {
 func (obj *MungeObject) WriteComment(msg string) error {
 	config := obj.config
 	prNum := *obj.Issue.Number
-	config.analytics.CreateComment.Call(config, nil)
+	config.stats().CreateComment.Call(config, nil)
 	glog.Infof("Commenting %q in %d", msg, prNum)
 	if config.DryRun {
 		return nil
@@ -1102,10 +1364,11 @@ func (config *Config) ForEachIssueDo(fn MungeFunction) error {
 			ListOptions: github.ListOptions{PerPage: 100, Page: page},
 		}
 		issues, response, err := config.client.Issues.ListByRepo(config.Org, config.Project, listOpts)
-		config.analytics.ListIssues.Call(config, response)
+		config.stats().ListIssues.Call(config, response)
 		if err != nil {
 			return err
 		}
+		objs := []*MungeObject{}
 		for i := range issues {
 			issue := &issues[i]
 			if issue.Number == nil {
@@ -1124,14 +1387,25 @@ func (config *Config) ForEachIssueDo(fn MungeFunction) error {
 				glog.V(6).Infof("Dropping %d > %d", *issue.Number, config.MaxPRNumber)
 				continue
 			}
-			glog.V(2).Infof("----==== %d ====----", *issue.Number)
-			glog.V(8).Infof("Issue %d labels: %v isPR: %v", *issue.Number, issue.Labels, issue.PullRequestLinks != nil)
-			obj := MungeObject{
+			objs = append(objs, &MungeObject{
 				config: config,
 				Issue:  issue,
+			})
+		}
+		for start := 0; start < len(objs); start += bulkBatchSize {
+			end := start + bulkBatchSize
+			if end > len(objs) {
+				end = len(objs)
 			}
-			if err := fn(&obj); err != nil {
-				continue
+			if config.BulkFetch {
+				config.prefetch(objs[start:end])
+			}
+			for _, obj := range objs[start:end] {
+				glog.V(2).Infof("----==== %d ====----", *obj.Issue.Number)
+				glog.V(8).Infof("Issue %d labels: %v isPR: %v", *obj.Issue.Number, obj.Issue.Labels, obj.Issue.PullRequestLinks != nil)
+				if err := fn(obj); err != nil {
+					continue
+				}
 			}
 		}
 		if response.LastPage == 0 || response.LastPage <= page {
@@ -1152,7 +1426,7 @@ func (config *Config) ListAllIssues(listOpts *github.IssueListByRepoOptions) ([]
 		glog.V(4).Infof("Fetching page %d of issues", page)
 		listOpts.ListOptions = github.ListOptions{PerPage: 100, Page: page}
 		issues, response, err := config.client.Issues.ListByRepo(config.Org, config.Project, listOpts)
-		config.analytics.ListIssues.Call(config, response)
+		config.stats().ListIssues.Call(config, response)
 		if err != nil {
 			return nil, err
 		}
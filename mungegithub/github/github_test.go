@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
@@ -239,6 +240,49 @@ func TestForEachIssueDo(t *testing.T) {
 	}
 }
 
+func TestListFiles(t *testing.T) {
+	pages := [][]github.CommitFile{
+		{{Filename: stringPtr("a.go")}, {Filename: stringPtr("b.go")}},
+		{{Filename: stringPtr("c.go")}},
+	}
+	client, server, mux := github_test.InitServer(t, nil, nil, nil, nil, nil)
+	defer server.Close()
+	count := 0
+	mux.HandleFunc("/repos/o/r/pulls/1/files", func(w http.ResponseWriter, r *http.Request) {
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || page < 1 || page > len(pages) {
+			t.Errorf("Unexpected page: %q", r.URL.Query().Get("page"))
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		count++
+		w.Header().Add("Link", fmt.Sprintf("<https://api.github.com/?page=%d>; rel=\"last\"", len(pages)))
+		data, err := json.Marshal(pages[page-1])
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		w.Write(data)
+	})
+	config := &Config{Org: "o", Project: "r"}
+	config.SetClient(client)
+	obj := &MungeObject{config: config, Issue: github_test.Issue("bob", 1, nil, true)}
+
+	files, err := obj.ListFiles()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := []string{}
+	for _, file := range files {
+		names = append(names, *file.Filename)
+	}
+	if !reflect.DeepEqual(names, []string{"a.go", "b.go", "c.go"}) {
+		t.Errorf("expected each file once, got %v", names)
+	}
+	if count != len(pages) {
+		t.Errorf("expected %d fetches, got %d", len(pages), count)
+	}
+}
+
 func TestComputeStatus(t *testing.T) {
 	contextS := []string{"context"}
 	otherS := []string{"other context"}
@@ -462,3 +506,66 @@ func TestRemoveLabel(t *testing.T) {
 		server.Close()
 	}
 }
+
+func TestMergePRWithOptions(t *testing.T) {
+	tests := []struct {
+		opts     MergeOptions
+		expected mergeRequest
+		preview  bool
+	}{
+		{
+			opts:     MergeOptions{Strategy: MergeStrategyMerge, CommitMessage: "message"},
+			expected: mergeRequest{CommitMessage: "message"},
+		},
+		{
+			opts:     MergeOptions{Strategy: MergeStrategySquash, CommitTitle: "title (#1)", CommitMessage: "body"},
+			expected: mergeRequest{CommitTitle: "title (#1)", CommitMessage: "body", MergeMethod: "squash"},
+			preview:  true,
+		},
+		{
+			opts:     MergeOptions{Strategy: MergeStrategyRebase},
+			expected: mergeRequest{MergeMethod: "rebase"},
+			preview:  true,
+		},
+	}
+	for testNum, test := range tests {
+		client, server, mux := github_test.InitServer(t, github_test.Issue("", 1, nil, true), nil, nil, nil, nil)
+		config := &Config{}
+		config.Org = "o"
+		config.Project = "r"
+		config.SetClient(client)
+		mux.HandleFunc("/repos/o/r/issues/1/comments", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("{}"))
+		})
+		var got mergeRequest
+		accept := ""
+		mux.HandleFunc("/repos/o/r/pulls/1/merge", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "PUT" {
+				t.Errorf("%d: Unexpected method: %s", testNum, r.Method)
+			}
+			accept = r.Header.Get("Accept")
+			if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+				t.Errorf("%d: Unable to decode merge request: %v", testNum, err)
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("{}"))
+		})
+
+		obj, err := config.GetObject(1)
+		if err != nil {
+			t.Fatalf("%d: unable to get issue: %v", testNum, err)
+		}
+		opts := test.opts
+		if err := obj.MergePRWithOptions("test", &opts); err != nil {
+			t.Errorf("%d: unexpected error: %v", testNum, err)
+		}
+		if got != test.expected {
+			t.Errorf("%d: expected merge request %#v, got %#v", testNum, test.expected, got)
+		}
+		if (accept == mergeMethodPreview) != test.preview {
+			t.Errorf("%d: unexpected Accept header %q", testNum, accept)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Events which github records when the head of a PR changes. Plain pushes
+// are not issue events, see pushes().
+var headChangeEvents = map[string]bool{
+	"head_ref_force_pushed": true,
+}
+
+// How many label SHAs we remember. Once there are more, those of the labels
+// added longest ago are forgotten.
+const maxLabelSHAs = 10000
+
+// headChange is the head of a PR becoming SHA at Time
+type headChange struct {
+	SHA  string
+	Time time.Time
+}
+
+type byTime []headChange
+
+func (b byTime) Len() int           { return len(b) }
+func (b byTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byTime) Less(i, j int) bool { return b[i].Time.Before(b[j].Time) }
+
+// observedHead is the head SHA we saw for a PR and when we first saw it
+type observedHead struct {
+	headChange
+	// changed is true if we saw the head change to SHA, as opposed to it
+	// being the head when we first looked at the PR
+	changed bool
+}
+
+// labelSHA is the head SHA of a PR when a label was added at Time
+type labelSHA struct {
+	Time time.Time
+	SHA  string
+}
+
+// headTracker remembers what we have seen of PR heads across munge loops.
+// github only records plain pushes on the commits, and only in GraphQL, so
+// we also notice them ourselves.
+type headTracker struct {
+	sync.Mutex
+	heads map[int]observedHead
+	// labelSHAs maps "number/label" to the head SHA when the label was
+	// last added. They are saved to file, if any, to survive restarts.
+	labelSHAs map[string]labelSHA
+	file      string
+	// maxLabelSHAs is how many label SHAs we remember
+	maxLabelSHAs int
+}
+
+var headTrackerLock sync.Mutex
+
+func (config *Config) headTracker() *headTracker {
+	config = config.root()
+	headTrackerLock.Lock()
+	defer headTrackerLock.Unlock()
+	if config.heads == nil {
+		config.heads = &headTracker{
+			heads:        map[int]observedHead{},
+			labelSHAs:    map[string]labelSHA{},
+			file:         config.HeadStateFile,
+			maxLabelSHAs: maxLabelSHAs,
+		}
+		config.heads.load()
+	}
+	return config.heads
+}
+
+// load reads the label SHAs saved by a previous run
+func (t *headTracker) load() {
+	if t.file == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(t.file)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		glog.Errorf("Unable to read label SHAs from %s: %v", t.file, err)
+		return
+	}
+	if err := json.Unmarshal(b, &t.labelSHAs); err != nil {
+		glog.Errorf("Unable to parse label SHAs from %s: %v", t.file, err)
+		t.labelSHAs = map[string]labelSHA{}
+	}
+}
+
+// setLabelSHA remembers the head SHA when a label of a PR was added, saving
+// the label SHAs to file, if any. Must be called with the lock held.
+func (t *headTracker) setLabelSHA(key string, l labelSHA) {
+	t.labelSHAs[key] = l
+	if len(t.labelSHAs) > t.maxLabelSHAs {
+		keys := []string{}
+		for k := range t.labelSHAs {
+			keys = append(keys, k)
+		}
+		sort.Sort(byLabelTime{keys, t.labelSHAs})
+		for _, k := range keys[:len(keys)-t.maxLabelSHAs] {
+			delete(t.labelSHAs, k)
+		}
+	}
+	if t.file == "" {
+		return
+	}
+	b, err := json.Marshal(t.labelSHAs)
+	if err != nil {
+		glog.Errorf("Unable to save label SHAs: %v", err)
+		return
+	}
+	// Write and rename so a crash never leaves half a file behind
+	tmp := t.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		glog.Errorf("Unable to save label SHAs to %s: %v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, t.file); err != nil {
+		glog.Errorf("Unable to save label SHAs to %s: %v", t.file, err)
+	}
+}
+
+// byLabelTime sorts label SHA keys by when the label was added
+type byLabelTime struct {
+	keys []string
+	shas map[string]labelSHA
+}
+
+func (b byLabelTime) Len() int      { return len(b.keys) }
+func (b byLabelTime) Swap(i, j int) { b.keys[i], b.keys[j] = b.keys[j], b.keys[i] }
+func (b byLabelTime) Less(i, j int) bool {
+	return b.shas[b.keys[i]].Time.Before(b.shas[b.keys[j]].Time)
+}
+
+// observeHead records the head SHA of the PR we just got from github
+func (obj *MungeObject) observeHead() {
+	if obj.pr == nil || obj.pr.Head == nil || obj.pr.Head.SHA == nil {
+		return
+	}
+	sha := *obj.pr.Head.SHA
+	t := obj.config.headTracker()
+	t.Lock()
+	defer t.Unlock()
+	old, ok := t.heads[*obj.Issue.Number]
+	if ok && old.SHA == sha {
+		return
+	}
+	if ok {
+		glog.V(2).Infof("PR %d head changed from %s to %s", *obj.Issue.Number, old.SHA, sha)
+	}
+	t.heads[*obj.Issue.Number] = observedHead{
+		headChange: headChange{SHA: sha, Time: time.Now()},
+		changed:    ok,
+	}
+}
+
+// HeadSHA returns the SHA of the head of the PR
+func (obj *MungeObject) HeadSHA() (string, error) {
+	pr, err := obj.GetPR()
+	if err != nil {
+		return "", err
+	}
+	if pr.Head == nil || pr.Head.SHA == nil {
+		return "", fmt.Errorf("PR %d has no head SHA", *obj.Issue.Number)
+	}
+	return *pr.Head.SHA, nil
+}
+
+// pushes returns when github saw the commits of the PR pushed, nil if that
+// is unknown. Only GraphQL knows, so PRs which were not fetched in bulk are
+// fetched again, once.
+func (obj *MungeObject) pushes() []headChange {
+	if obj.prefetched != nil && obj.prefetched.pushes != nil {
+		return obj.prefetched.pushes
+	}
+	if obj.pushesFetched {
+		return obj.pushed
+	}
+	obj.pushesFetched = true
+	fetched, err := obj.config.fetchPRsInBulk([]int{*obj.Issue.Number})
+	if err != nil {
+		glog.V(2).Infof("Unable to get when the commits of PR %d were pushed: %v", *obj.Issue.Number, err)
+		return nil
+	}
+	if f, ok := fetched[*obj.Issue.Number]; ok {
+		obj.pushed = f.prefetched.pushes
+	}
+	return obj.pushed
+}
+
+// headChanges returns every known change of the head of the PR, oldest first.
+// `pushes` are added to the force pushes github recorded as events.
+func (obj *MungeObject) headChanges(pushes []headChange) []headChange {
+	changes := []headChange{}
+	seen := map[string]bool{}
+	events, err := obj.GetEvents()
+	if err == nil {
+		for _, event := range events {
+			if event.Event == nil || !headChangeEvents[*event.Event] || event.CommitID == nil || event.CreatedAt == nil {
+				continue
+			}
+			changes = append(changes, headChange{SHA: *event.CommitID, Time: *event.CreatedAt})
+			seen[*event.CommitID] = true
+		}
+	}
+	for _, push := range pushes {
+		if !seen[push.SHA] {
+			changes = append(changes, push)
+			seen[push.SHA] = true
+		}
+	}
+	// github's own record of a change is better than when we noticed it
+	t := obj.config.headTracker()
+	t.Lock()
+	observed, ok := t.heads[*obj.Issue.Number]
+	t.Unlock()
+	if ok && observed.changed && !seen[observed.SHA] {
+		changes = append(changes, observed.headChange)
+	}
+	// Pushes come in the order of the PR, keep it for those pushed together
+	sort.Stable(byTime(changes))
+	return changes
+}
+
+// lastCommitTime returns the latest committer date of the commits in the PR.
+// Authors control these dates, so they are only a last resort.
+func (obj *MungeObject) lastCommitTime() *time.Time {
+	var lastModified *time.Time
+	commits, err := obj.GetCommits()
+	if err != nil {
+		return lastModified
+	}
+	for _, commit := range commits {
+		if commit.Commit == nil || commit.Commit.Committer == nil || commit.Commit.Committer.Date == nil {
+			glog.Errorf("PR %d: Found invalid RepositoryCommit: %v", *obj.Issue.Number, commit)
+			continue
+		}
+		if lastModified == nil || commit.Commit.Committer.Date.After(*lastModified) {
+			lastModified = commit.Commit.Committer.Date
+		}
+	}
+	return lastModified
+}
+
+// LastModifiedTime returns the last time the head of the PR changed. If that
+// is unknown, because it was never force pushed and has not changed since we
+// started watching, it returns the time of the last commit.
+func (obj *MungeObject) LastModifiedTime() *time.Time {
+	var pushes []headChange
+	if obj.prefetched != nil {
+		pushes = obj.prefetched.pushes
+	}
+	changes := obj.headChanges(pushes)
+	if len(changes) == 0 {
+		return obj.lastCommitTime()
+	}
+	last := changes[len(changes)-1].Time
+	return &last
+}
+
+// LabelSHA returns the head SHA of the PR when `label` was last added. It
+// returns "" if the label was never added or if the head has changed since
+// and we can't tell what it was.
+func (obj *MungeObject) LabelSHA(label string) string {
+	labelTime := obj.LabelTime(label)
+	if labelTime == nil || labelTime.IsZero() {
+		return ""
+	}
+	key := fmt.Sprintf("%d/%s", *obj.Issue.Number, label)
+	t := obj.config.headTracker()
+	t.Lock()
+	known, ok := t.labelSHAs[key]
+	t.Unlock()
+	if ok && known.Time.Unix() == labelTime.Unix() {
+		return known.SHA
+	}
+
+	head, err := obj.HeadSHA()
+	if err != nil {
+		return ""
+	}
+	sha := ""
+	changes := obj.headChanges(obj.pushes())
+	changedAfter := false
+	for _, c := range changes {
+		if c.Time.After(*labelTime) {
+			changedAfter = true
+		} else {
+			sha = c.SHA
+		}
+	}
+	switch {
+	case len(changes) == 0:
+		if last := obj.lastCommitTime(); last != nil && !last.After(*labelTime) {
+			sha = head
+		}
+	case !changedAfter:
+		sha = head
+	}
+	if sha == "" {
+		return ""
+	}
+	glog.V(4).Infof("PR %d: %q was added at %s", *obj.Issue.Number, label, sha)
+	t.Lock()
+	t.setLabelSHA(key, labelSHA{Time: *labelTime, SHA: sha})
+	t.Unlock()
+	return sha
+}
+
+// ChangedSinceLabel returns true if the head of the PR is not the SHA it was
+// when `label` was last added.
+func (obj *MungeObject) ChangedSinceLabel(label string) (bool, error) {
+	head, err := obj.HeadSHA()
+	if err != nil {
+		return false, err
+	}
+	if obj.LabelTime(label) == nil {
+		return false, fmt.Errorf("PR %d never had %q added", *obj.Issue.Number, label)
+	}
+	return obj.LabelSHA(label) != head, nil
+}
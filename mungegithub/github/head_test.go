@@ -0,0 +1,205 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	github_test "k8s.io/contrib/mungegithub/github/testing"
+
+	"github.com/google/go-github/github"
+)
+
+func forcePush(sha string, t int64) github.IssueEvent {
+	return github.IssueEvent{
+		Event:     stringPtr("head_ref_force_pushed"),
+		CommitID:  stringPtr(sha),
+		CreatedAt: timePtr(time.Unix(t, 0)),
+		Actor:     &github.User{Login: stringPtr("bob")},
+	}
+}
+
+func TestChangedSinceLabel(t *testing.T) {
+	lgtm := github_test.Events([]github_test.LabelTime{{User: "alice", Label: "lgtm", Time: 10}})
+	tests := []struct {
+		name         string
+		commitTime   int64
+		pushes       []github.IssueEvent
+		expectSHA    string
+		expectChange bool
+		expectTime   time.Time
+	}{
+		{
+			name:       "commits before lgtm",
+			commitTime: 5,
+			expectSHA:  "mysha",
+			expectTime: time.Unix(5, 0),
+		},
+		{
+			name:         "commits after lgtm",
+			commitTime:   15,
+			expectChange: true,
+			expectTime:   time.Unix(15, 0),
+		},
+		{
+			name:         "force pushed after lgtm",
+			commitTime:   5,
+			pushes:       []github.IssueEvent{forcePush("mysha", 20)},
+			expectChange: true,
+			expectTime:   time.Unix(20, 0),
+		},
+		{
+			name:         "force pushed before and after lgtm",
+			commitTime:   5,
+			pushes:       []github.IssueEvent{forcePush("oldsha", 7), forcePush("mysha", 20)},
+			expectSHA:    "oldsha",
+			expectChange: true,
+			expectTime:   time.Unix(20, 0),
+		},
+		{
+			name:       "rebased commits dated after lgtm",
+			commitTime: 100,
+			pushes:     []github.IssueEvent{forcePush("mysha", 8)},
+			expectSHA:  "mysha",
+			expectTime: time.Unix(8, 0),
+		},
+	}
+	for _, test := range tests {
+		events := append(append([]github.IssueEvent{}, lgtm...), test.pushes...)
+		pr := github_test.PullRequest("bob", false, true, true)
+		client, server, _ := github_test.InitServer(t, nil, pr, events, github_test.Commits(1, test.commitTime), nil)
+		config := &Config{Org: "o", Project: "r"}
+		config.SetClient(client)
+		obj := &MungeObject{
+			config: config,
+			Issue:  github_test.Issue("bob", 1, []string{"lgtm"}, true),
+		}
+
+		if ts := obj.LastModifiedTime(); ts == nil || !ts.Equal(test.expectTime) {
+			t.Errorf("%s: expected last modified %v, got %v", test.name, test.expectTime, ts)
+		}
+		if sha := obj.LabelSHA("lgtm"); sha != test.expectSHA {
+			t.Errorf("%s: expected lgtm SHA %q, got %q", test.name, test.expectSHA, sha)
+		}
+		changed, err := obj.ChangedSinceLabel("lgtm")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if changed != test.expectChange {
+			t.Errorf("%s: expected changed %v, got %v", test.name, test.expectChange, changed)
+		}
+		server.Close()
+	}
+}
+
+// A plain push leaves no event, so we must notice the head change ourselves
+func TestChangedSinceLabelObserved(t *testing.T) {
+	events := github_test.Events([]github_test.LabelTime{{User: "alice", Label: "lgtm", Time: 10}})
+	pr := github_test.PullRequest("bob", false, true, true)
+	pr.Head.SHA = stringPtr("oldsha")
+	client, server, _ := github_test.InitServer(t, nil, pr, events, github_test.Commits(1, 5), nil)
+	defer server.Close()
+	config := &Config{Org: "o", Project: "r"}
+	config.SetClient(client)
+
+	obj := &MungeObject{
+		config: config,
+		Issue:  github_test.Issue("bob", 1, []string{"lgtm"}, true),
+	}
+	if changed, err := obj.ChangedSinceLabel("lgtm"); err != nil || changed {
+		t.Fatalf("expected unchanged PR, got %v, %v", changed, err)
+	}
+
+	// The next loop sees a new head, with an old commit date
+	pr.Head.SHA = stringPtr("mysha")
+	obj = &MungeObject{
+		config: config,
+		Issue:  github_test.Issue("bob", 1, []string{"lgtm"}, true),
+	}
+	if sha := obj.LabelSHA("lgtm"); sha != "oldsha" {
+		t.Errorf("expected lgtm SHA %q, got %q", "oldsha", sha)
+	}
+	if changed, err := obj.ChangedSinceLabel("lgtm"); err != nil || !changed {
+		t.Errorf("expected changed PR, got %v, %v", changed, err)
+	}
+	if ts := obj.LastModifiedTime(); ts == nil || !ts.After(time.Unix(10, 0)) {
+		t.Errorf("expected last modified after the lgtm, got %v", ts)
+	}
+}
+
+// github only records plain pushes on the commits, a push just before the
+// lgtm must not count as a change after it
+func TestChangedSinceLabelPushed(t *testing.T) {
+	events := github_test.Events([]github_test.LabelTime{{User: "alice", Label: "lgtm", Time: 10}})
+	pr := github_test.PullRequest("bob", false, true, true)
+	pr.Head.SHA = stringPtr("oldsha")
+	commits := github_test.Commits(1, 5)
+	client, server, mux := github_test.InitServer(t, nil, pr, events, commits, nil)
+	defer server.Close()
+	pushed := map[string]time.Time{}
+	github_test.ServeGraphQL(t, mux, map[int]github_test.GraphQLPR{
+		1: {PR: pr, Events: events, Commits: commits, Pushed: pushed},
+	})
+	config := &Config{Org: "o", Project: "r"}
+	config.SetClient(client)
+
+	// We see oldsha, then mysha is pushed and lgtm'd before the next loop
+	obj := &MungeObject{config: config, Issue: github_test.Issue("bob", 1, []string{"lgtm"}, true)}
+	obj.GetPR()
+	pr.Head.SHA = stringPtr("mysha")
+	commits[0].SHA = stringPtr("mysha")
+	pushed["mysha"] = time.Unix(8, 0)
+	obj = &MungeObject{config: config, Issue: github_test.Issue("bob", 1, []string{"lgtm"}, true)}
+	if changed, err := obj.ChangedSinceLabel("lgtm"); err != nil || changed {
+		t.Errorf("expected unchanged PR, got %v, %v", changed, err)
+	}
+	if sha := obj.LabelSHA("lgtm"); sha != "mysha" {
+		t.Errorf("expected lgtm SHA %q, got %q", "mysha", sha)
+	}
+}
+
+func TestLabelSHAsSaved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "head")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "heads")
+
+	tracker := (&Config{HeadStateFile: file}).headTracker()
+	tracker.setLabelSHA("1/lgtm", labelSHA{Time: time.Unix(10, 0), SHA: "mysha"})
+	restarted := (&Config{HeadStateFile: file}).headTracker()
+	if l := restarted.labelSHAs["1/lgtm"]; l.SHA != "mysha" || !l.Time.Equal(time.Unix(10, 0)) {
+		t.Errorf("expected the lgtm SHA to survive a restart, got %+v", restarted.labelSHAs)
+	}
+
+	restarted.maxLabelSHAs = 3
+	for i := 0; i < 3; i++ {
+		restarted.setLabelSHA(fmt.Sprintf("%d/lgtm", i+2), labelSHA{Time: time.Unix(int64(i+11), 0), SHA: "sha"})
+	}
+	if len(restarted.labelSHAs) != 3 {
+		t.Errorf("expected 3 label SHAs, got %d", len(restarted.labelSHAs))
+	}
+	if _, ok := restarted.labelSHAs["1/lgtm"]; ok {
+		t.Errorf("expected the oldest label SHA to be forgotten")
+	}
+}
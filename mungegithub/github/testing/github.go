@@ -19,6 +19,7 @@ package testing
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -231,6 +232,8 @@ func setMux(t *testing.T, mux *http.ServeMux, path string, thing interface{}) {
 			data, err = json.Marshal(thing)
 		case github.RepositoryCommit:
 			data, err = json.Marshal(thing)
+		case []github.CommitFile:
+			data, err = json.Marshal(thing)
 		case *github.CombinedStatus:
 			data, err = json.Marshal(thing)
 		case []github.User:
@@ -292,6 +295,8 @@ func InitServer(t *testing.T, issue *github.Issue, pr *github.PullRequest, event
 			path := fmt.Sprintf("/repos/o/r/commits/%s", *c.SHA)
 			setMux(t, mux, path, c)
 		}
+		path = fmt.Sprintf("/repos/o/r/pulls/%d/files", issueNum)
+		setMux(t, mux, path, Files(commits))
 	}
 	if status != nil {
 		path := fmt.Sprintf("/repos/o/r/commits/%s/status", sha)
@@ -301,3 +306,269 @@ func InitServer(t *testing.T, issue *github.Issue, pr *github.PullRequest, event
 	setMux(t, mux, path, []github.User{})
 	return client, server, mux
 }
+
+// Files returns the files changed by `commits`, as the files of a PR
+func Files(commits []github.RepositoryCommit) []github.CommitFile {
+	files := []github.CommitFile{}
+	for _, c := range commits {
+		files = append(files, c.Files...)
+	}
+	return files
+}
+
+// GraphQLPR is what ServeGraphQL knows about one PR
+type GraphQLPR struct {
+	PR      *github.PullRequest
+	Events  []github.IssueEvent
+	Status  *github.CombinedStatus
+	Commits []github.RepositoryCommit
+	// Pushed maps commit SHAs to when github saw them pushed
+	Pushed map[string]time.Time
+}
+
+func num(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+func gqlGitActor(c *github.CommitAuthor) interface{} {
+	if c == nil {
+		return nil
+	}
+	return map[string]interface{}{"name": str(c.Name), "email": str(c.Email), "date": gqlTime(c.Date)}
+}
+
+func str(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func gqlTime(t *time.Time) string {
+	if t == nil {
+		return time.Time{}.Format(time.RFC3339)
+	}
+	return t.Format(time.RFC3339)
+}
+
+func gqlActor(u *github.User) interface{} {
+	if u == nil {
+		return nil
+	}
+	return map[string]interface{}{"login": str(u.Login), "avatarUrl": str(u.AvatarURL)}
+}
+
+var gqlEventTypes = map[string]string{
+	"labeled":               "LabeledEvent",
+	"unlabeled":             "UnlabeledEvent",
+	"closed":                "ClosedEvent",
+	"reopened":              "ReopenedEvent",
+	"merged":                "MergedEvent",
+	"head_ref_force_pushed": "HeadRefForcePushedEvent",
+}
+
+func gqlPullRequest(p *GraphQLPR) map[string]interface{} {
+	pr := p.PR
+	state := "OPEN"
+	if str(pr.State) == "closed" {
+		state = "CLOSED"
+	}
+	mergeable := "UNKNOWN"
+	if pr.Mergeable != nil {
+		mergeable = "CONFLICTING"
+		if *pr.Mergeable {
+			mergeable = "MERGEABLE"
+		}
+	}
+	sha := ""
+	head := ""
+	if pr.Head != nil {
+		sha = str(pr.Head.SHA)
+		head = str(pr.Head.Ref)
+	}
+	base := ""
+	baseSHA := ""
+	if pr.Base != nil {
+		base = str(pr.Base.Ref)
+		baseSHA = str(pr.Base.SHA)
+	}
+	var mergedAt interface{}
+	if pr.MergedAt != nil {
+		mergedAt = gqlTime(pr.MergedAt)
+	}
+
+	commits := []interface{}{}
+	for _, c := range p.Commits {
+		commit := map[string]interface{}{"oid": str(c.SHA)}
+		if pushed, ok := p.Pushed[str(c.SHA)]; ok {
+			commit["pushedDate"] = gqlTime(&pushed)
+		}
+		if c.Commit != nil {
+			commit["message"] = str(c.Commit.Message)
+			commit["committer"] = gqlGitActor(c.Commit.Committer)
+			if author := gqlGitActor(c.Commit.Author); author != nil {
+				author := author.(map[string]interface{})
+				author["user"] = gqlActor(c.Author)
+				commit["author"] = author
+			}
+		}
+		commits = append(commits, map[string]interface{}{"commit": commit})
+	}
+	files := []interface{}{}
+	for _, f := range Files(p.Commits) {
+		files = append(files, map[string]interface{}{
+			"path":      str(f.Filename),
+			"additions": num(f.Additions),
+			"deletions": num(f.Deletions),
+		})
+	}
+
+	nodes := []interface{}{}
+	for _, e := range p.Events {
+		typename, ok := gqlEventTypes[str(e.Event)]
+		if !ok {
+			continue
+		}
+		node := map[string]interface{}{
+			"__typename": typename,
+			"createdAt":  gqlTime(e.CreatedAt),
+			"actor":      gqlActor(e.Actor),
+		}
+		if e.Label != nil {
+			node["label"] = map[string]interface{}{"name": str(e.Label.Name)}
+		}
+		if e.CommitID != nil {
+			commit := map[string]interface{}{"oid": *e.CommitID}
+			if typename == "HeadRefForcePushedEvent" {
+				node["afterCommit"] = commit
+			} else {
+				node["commit"] = commit
+			}
+		}
+		nodes = append(nodes, node)
+	}
+
+	var status interface{}
+	if p.Status != nil && len(p.Status.Statuses) > 0 {
+		contexts := []interface{}{}
+		for _, s := range p.Status.Statuses {
+			contexts = append(contexts, map[string]interface{}{
+				"context":     str(s.Context),
+				"state":       strings.ToUpper(str(s.State)),
+				"description": str(s.Description),
+				"targetUrl":   str(s.TargetURL),
+				"createdAt":   gqlTime(s.CreatedAt),
+			})
+		}
+		status = map[string]interface{}{
+			"state":    strings.ToUpper(str(p.Status.State)),
+			"contexts": contexts,
+		}
+	}
+
+	var merged bool
+	if pr.Merged != nil {
+		merged = *pr.Merged
+	}
+	var number int
+	if pr.Number != nil {
+		number = *pr.Number
+	}
+	var author interface{}
+	if pr.User != nil {
+		author = gqlActor(pr.User)
+	}
+	return map[string]interface{}{
+		"number":      number,
+		"title":       str(pr.Title),
+		"body":        str(pr.Body),
+		"state":       state,
+		"merged":      merged,
+		"mergeable":   mergeable,
+		"url":         str(pr.HTMLURL),
+		"createdAt":   gqlTime(pr.CreatedAt),
+		"updatedAt":   gqlTime(pr.UpdatedAt),
+		"mergedAt":    mergedAt,
+		"additions":   num(pr.Additions),
+		"deletions":   num(pr.Deletions),
+		"author":      author,
+		"headRefName": head,
+		"headRefOid":  sha,
+		"baseRefName": base,
+		"baseRefOid":  baseSHA,
+		"timelineItems": map[string]interface{}{
+			"pageInfo": map[string]interface{}{"hasNextPage": false},
+			"nodes":    nodes,
+		},
+		"headCommit": map[string]interface{}{
+			"nodes": []interface{}{
+				map[string]interface{}{
+					"commit": map[string]interface{}{"oid": sha, "status": status},
+				},
+			},
+		},
+		"commits": map[string]interface{}{
+			"pageInfo": map[string]interface{}{"hasNextPage": false},
+			"nodes":    commits,
+		},
+		"files": map[string]interface{}{
+			"pageInfo": map[string]interface{}{"hasNextPage": false},
+			"nodes":    files,
+		},
+	}
+}
+
+// ServeGraphQL is a stand-in for github's GraphQL API. It answers the bulk PR
+// queries of mungegithub with the PRs in `prs`, keyed by number. PRs which are
+// not in `prs` come back null, with an error, just like github.
+func ServeGraphQL(t *testing.T, mux *http.ServeMux, prs map[int]GraphQLPR) {
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Unexpected method: expected: POST got: %s", r.Method)
+		}
+		req := struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Unable to decode GraphQL request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		repo := map[string]interface{}{}
+		errors := []interface{}{}
+		for i := 0; ; i++ {
+			v, ok := req.Variables[fmt.Sprintf("n%d", i)]
+			if !ok {
+				break
+			}
+			num := int(v.(float64))
+			alias := fmt.Sprintf("pr%d", i)
+			p, ok := prs[num]
+			if !ok {
+				repo[alias] = nil
+				errors = append(errors, map[string]interface{}{
+					"message": fmt.Sprintf("Could not resolve to a PullRequest with the number of %d.", num),
+				})
+				continue
+			}
+			repo[alias] = gqlPullRequest(&p)
+		}
+		out := map[string]interface{}{
+			"data": map[string]interface{}{"repository": repo},
+		}
+		if len(errors) > 0 {
+			out["errors"] = errors
+		}
+		data, err := json.Marshal(out)
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		w.Header().Set("X-RateLimit-Resource", "graphql")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	})
+}
@@ -58,6 +58,7 @@ func doMungers(config *mungeConfig) error {
 		config.NextExpectedUpdate(nextRunStartTime)
 
 		mungers.EachLoop()
+		reports.RunScheduledReports(&config.Config)
 
 		if err := config.ForEachIssueDo(mungers.MungeIssue); err != nil {
 			glog.Errorf("Error munging PRs: %v", err)
@@ -96,6 +97,9 @@ func main() {
 			if err != nil {
 				glog.Fatalf("unable to initialize requested mungers: %v", err)
 			}
+			if err := reports.InitializeScheduler(&config.Config); err != nil {
+				glog.Fatalf("unable to initialize scheduled reports: %v", err)
+			}
 			return doMungers(config)
 		},
 	}
@@ -112,6 +116,7 @@ func main() {
 	for _, r := range allReports {
 		r.AddFlags(root, &config.Config)
 	}
+	reports.AddFlags(root, &config.Config)
 
 	if err := root.Execute(); err != nil {
 		glog.Fatalf("%v\n", err)
@@ -0,0 +1,334 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/contrib/mungegithub/github"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+const (
+	queuePaused = "The submit queue has been paused by an admin."
+	prEjected   = "An admin ejected this PR from the queue. Push a new commit or ask an admin to retest to re-enter."
+)
+
+type adminState struct {
+	Paused      bool
+	PausedBy    string `json:",omitempty"`
+	PauseReason string `json:",omitempty"`
+	// Bumped PRs are tested before all others, in this order
+	Bumped []int
+	// Ejected maps PR number to when it was ejected
+	Ejected map[string]time.Time
+	// NonBlockingJobs maps e2e job to when it blocks the queue again
+	NonBlockingJobs map[string]time.Time
+}
+
+func (sq *SubmitQueue) addAdminFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&sq.AdminTokensFile, "admin-tokens-file", "", "CSV file of 'token,user' lines. Requests to the /admin/ endpoints must send 'Authorization: Bearer <token>'. If empty the admin endpoints are disabled")
+}
+
+// loadAdminTokens reads a file of 'token,user' lines, like the apiserver's
+// --token-auth-file.
+func loadAdminTokens(file string) (map[string]string, error) {
+	fp, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	tokens := map[string]string{}
+	scanner := bufio.NewScanner(fp)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 2 || len(fields[0]) == 0 || len(fields[1]) == 0 {
+			return nil, fmt.Errorf("%s:%d: expected 'token,user'", file, lineNum)
+		}
+		tokens[fields[0]] = fields[1]
+	}
+	return tokens, scanner.Err()
+}
+
+// authenticate returns the admin who sent the request, or "" if the request
+// did not carry a valid token.
+func (sq *SubmitQueue) authenticate(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for t, user := range sq.adminTokens {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			return user
+		}
+	}
+	return ""
+}
+
+// adminAction is an admin operation. It returns an error which is shown to
+// the admin if the request can not be carried out.
+type adminAction func(admin string, req *http.Request) error
+
+// adminHandler checks that the request is an authenticated POST and runs
+// `action`. The response is the admin state after the action.
+func (sq *SubmitQueue) adminHandler(action adminAction) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			res.Header().Set("Allow", "POST")
+			http.Error(res, "only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin := sq.authenticate(req)
+		if admin == "" {
+			http.Error(res, "a valid admin token is required", http.StatusUnauthorized)
+			return
+		}
+		if err := action(admin, req); err != nil {
+			glog.Infof("Admin %s request %s failed: %v", admin, req.URL.Path, err)
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sq.serve(sq.getAdminState(), res, req)
+	}
+}
+
+func (sq *SubmitQueue) registerAdminHandlers() {
+	http.HandleFunc("/admin/state", func(w http.ResponseWriter, r *http.Request) {
+		sq.serveAdminState(w, r)
+	})
+	if len(sq.adminTokens) == 0 {
+		return
+	}
+	http.HandleFunc("/admin/pause", sq.adminHandler(sq.adminPause))
+	http.HandleFunc("/admin/resume", sq.adminHandler(sq.adminResume))
+	http.HandleFunc("/admin/retest", sq.adminHandler(sq.adminRetest))
+	http.HandleFunc("/admin/eject", sq.adminHandler(sq.adminEject))
+	http.HandleFunc("/admin/bump", sq.adminHandler(sq.adminBump))
+	http.HandleFunc("/admin/non-blocking", sq.adminHandler(sq.adminNonBlocking))
+}
+
+// audit records an admin action in the history. `obj` may be nil for actions
+// on the whole queue.
+func (sq *SubmitQueue) audit(admin string, obj *github.MungeObject, action string) {
+	glog.Infof("Admin %s: %s", admin, action)
+	status := submitStatus{
+		Time:   time.Now(),
+		Reason: action,
+		Admin:  admin,
+	}
+	if obj != nil {
+		status.statusPullRequest = *objToStatusPullRequest(obj)
+	} else {
+		status.statusPullRequest = statusPullRequest{Login: admin}
+	}
+	sq.Lock()
+	defer sq.Unlock()
+	sq.recordHistory(status)
+}
+
+// adminPR looks up the open PR named by the 'pr' parameter of the request
+func (sq *SubmitQueue) adminPR(req *http.Request) (*github.MungeObject, error) {
+	num, err := strconv.Atoi(req.FormValue("pr"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pr %q", req.FormValue("pr"))
+	}
+	obj, err := sq.githubConfig.GetObject(num)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get #%d: %v", num, err)
+	}
+	if !obj.IsPR() {
+		return nil, fmt.Errorf("#%d is not a PR", num)
+	}
+	if obj.Issue.State != nil && *obj.Issue.State != "open" {
+		return nil, fmt.Errorf("#%d is not open", num)
+	}
+	return obj, nil
+}
+
+func (sq *SubmitQueue) adminPause(admin string, req *http.Request) error {
+	reason := req.FormValue("reason")
+	sq.Lock()
+	sq.paused = true
+	sq.pausedBy = admin
+	sq.pauseReason = reason
+	sq.Unlock()
+
+	action := "Paused the submit queue"
+	if len(reason) > 0 {
+		action += ": " + reason
+	}
+	sq.audit(admin, nil, action)
+	// Anything waiting to be tested will be re-queued after resume
+	sq.flushGithubE2EQueue(queuePaused)
+	return nil
+}
+
+func (sq *SubmitQueue) adminResume(admin string, req *http.Request) error {
+	sq.Lock()
+	sq.paused = false
+	sq.pausedBy = ""
+	sq.pauseReason = ""
+	sq.Unlock()
+	sq.audit(admin, nil, "Resumed the submit queue")
+	return nil
+}
+
+// adminRetest clears any ejection and asks the PR builders to test the PR
+// again. The PR re-enters the queue through the normal munge loop.
+func (sq *SubmitQueue) adminRetest(admin string, req *http.Request) error {
+	obj, err := sq.adminPR(req)
+	if err != nil {
+		return err
+	}
+	sq.Lock()
+	delete(sq.ejected, *obj.Issue.Number)
+	sq.Unlock()
+	body := fmt.Sprintf("@k8s-bot test this [retest requested by submit-queue admin %s]", admin)
+	if err := obj.WriteComment(body); err != nil {
+		return fmt.Errorf("unable to request retest of #%d: %v", *obj.Issue.Number, err)
+	}
+	sq.audit(admin, obj, "Requested a retest")
+	return nil
+}
+
+// adminEject removes the PR from the queue until it is changed or an admin
+// asks for a retest.
+func (sq *SubmitQueue) adminEject(admin string, req *http.Request) error {
+	obj, err := sq.adminPR(req)
+	if err != nil {
+		return err
+	}
+	sq.Lock()
+	sq.ejected[*obj.Issue.Number] = time.Now()
+	sq.removeBump(*obj.Issue.Number)
+	sq.Unlock()
+	sq.audit(admin, obj, "Ejected from the submit queue")
+	sq.SetMergeStatus(obj, prEjected, true)
+	return nil
+}
+
+// adminBump moves the PR to the head of the e2e queue
+func (sq *SubmitQueue) adminBump(admin string, req *http.Request) error {
+	obj, err := sq.adminPR(req)
+	if err != nil {
+		return err
+	}
+	num := *obj.Issue.Number
+	sq.Lock()
+	sq.removeBump(num)
+	sq.bumped = append(sq.bumped, num)
+	sq.Unlock()
+	sq.audit(admin, obj, "Bumped to the head of the submit queue")
+	return nil
+}
+
+// adminNonBlocking stops the e2e 'job' from blocking the queue for
+// 'duration'. A duration of 0 makes the job blocking again.
+func (sq *SubmitQueue) adminNonBlocking(admin string, req *http.Request) error {
+	job := req.FormValue("job")
+	found := false
+	for _, j := range sq.JenkinsJobs {
+		if j == job {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("%q is not one of --jenkins-jobs", job)
+	}
+	d, err := time.ParseDuration(req.FormValue("duration"))
+	if err != nil || d < 0 {
+		return fmt.Errorf("invalid duration %q", req.FormValue("duration"))
+	}
+	if d == 0 {
+		sq.e2e.SetNonBlocking(job, time.Time{})
+		sq.audit(admin, nil, fmt.Sprintf("Made e2e job %s blocking", job))
+		return nil
+	}
+	sq.e2e.SetNonBlocking(job, time.Now().Add(d))
+	sq.audit(admin, nil, fmt.Sprintf("Made e2e job %s non-blocking for %v", job, d))
+	return nil
+}
+
+// sq.Lock() MUST be held
+func (sq *SubmitQueue) removeBump(num int) {
+	for i, n := range sq.bumped {
+		if n == num {
+			sq.bumped = append(sq.bumped[:i], sq.bumped[i+1:]...)
+			return
+		}
+	}
+}
+
+func (sq *SubmitQueue) isPaused() bool {
+	sq.Lock()
+	defer sq.Unlock()
+	return sq.paused
+}
+
+// isEjected returns true if an admin ejected the PR and it has not changed
+// since. `lastModified` may be nil if it is unknown.
+func (sq *SubmitQueue) isEjected(obj *github.MungeObject, lastModified *time.Time) bool {
+	sq.Lock()
+	defer sq.Unlock()
+	when, ok := sq.ejected[*obj.Issue.Number]
+	if !ok {
+		return false
+	}
+	if lastModified != nil && lastModified.After(when) {
+		delete(sq.ejected, *obj.Issue.Number)
+		return false
+	}
+	return true
+}
+
+func (sq *SubmitQueue) getAdminState() []byte {
+	sq.Lock()
+	defer sq.Unlock()
+	state := adminState{
+		Paused:          sq.paused,
+		PausedBy:        sq.pausedBy,
+		PauseReason:     sq.pauseReason,
+		Bumped:          append([]int{}, sq.bumped...),
+		Ejected:         map[string]time.Time{},
+		NonBlockingJobs: sq.e2e.GetNonBlocking(),
+	}
+	for num, when := range sq.ejected {
+		state.Ejected[strconv.Itoa(num)] = when
+	}
+	return sq.marshal(state)
+}
+
+func (sq *SubmitQueue) serveAdminState(res http.ResponseWriter, req *http.Request) {
+	data := sq.getAdminState()
+	sq.serve(data, res, req)
+}
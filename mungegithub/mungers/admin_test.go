@@ -0,0 +1,143 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	github_util "k8s.io/contrib/mungegithub/github"
+	"k8s.io/contrib/mungegithub/mungers/e2e"
+)
+
+func newAdminTestQueue() *SubmitQueue {
+	return &SubmitQueue{
+		JenkinsJobs:    []string{"foo"},
+		adminTokens:    map[string]string{"secret": "alice"},
+		e2e:            &e2e.E2ETester{BuildStatus: map[string]string{}},
+		ejected:        map[int]time.Time{},
+		prStatus:       map[string]submitStatus{},
+		githubE2EQueue: map[int]*github_util.MungeObject{},
+	}
+}
+
+func TestLoadAdminTokens(t *testing.T) {
+	fp, err := ioutil.TempFile("", "tokens")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.Remove(fp.Name())
+	fp.WriteString("# comment\nsecret,alice\n\nother,bob,1000\n")
+	fp.Close()
+
+	tokens, err := loadAdminTokens(fp.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{"secret": "alice", "other": "bob"}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected %v, got %v", expected, tokens)
+	}
+}
+
+func TestAdminHandlerAuth(t *testing.T) {
+	sq := newAdminTestQueue()
+	handler := sq.adminHandler(sq.adminPause)
+
+	tests := []struct {
+		method string
+		auth   string
+		code   int
+		paused bool
+	}{
+		{method: "GET", auth: "Bearer secret", code: http.StatusMethodNotAllowed},
+		{method: "POST", code: http.StatusUnauthorized},
+		{method: "POST", auth: "Bearer wrong", code: http.StatusUnauthorized},
+		{method: "POST", auth: "secret", code: http.StatusUnauthorized},
+		{method: "POST", auth: "Bearer secret", code: http.StatusOK, paused: true},
+	}
+	for i, test := range tests {
+		req, _ := http.NewRequest(test.method, "/admin/pause?reason=flaky", nil)
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != test.code {
+			t.Errorf("%d: expected code %d, got %d", i, test.code, w.Code)
+		}
+		if sq.isPaused() != test.paused {
+			t.Errorf("%d: expected paused=%v", i, test.paused)
+		}
+	}
+
+	if len(sq.statusHistory) != 1 {
+		t.Fatalf("expected one audit entry, got %v", sq.statusHistory)
+	}
+	entry := sq.statusHistory[0]
+	if entry.Admin != "alice" || entry.Reason != "Paused the submit queue: flaky" {
+		t.Errorf("unexpected audit entry: %#v", entry)
+	}
+}
+
+func TestAdminNonBlocking(t *testing.T) {
+	sq := newAdminTestQueue()
+	tests := []struct {
+		query       string
+		expectErr   bool
+		nonBlocking bool
+	}{
+		{query: "job=bar&duration=1h", expectErr: true},
+		{query: "job=foo&duration=soon", expectErr: true},
+		{query: "job=foo&duration=1h", nonBlocking: true},
+		{query: "job=foo&duration=0"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/non-blocking?"+test.query, nil)
+		err := sq.adminNonBlocking("alice", req)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: unexpected error: %v", test.query, err)
+		}
+		_, nonBlocking := sq.e2e.GetNonBlocking()["foo"]
+		if nonBlocking != test.nonBlocking {
+			t.Errorf("%s: expected non-blocking=%v", test.query, test.nonBlocking)
+		}
+	}
+}
+
+func TestOrderedE2EQueueBumped(t *testing.T) {
+	sq := newAdminTestQueue()
+	for _, num := range []int{5, 3, 9, 1} {
+		sq.githubE2EQueue[num] = nil
+	}
+	// 7 is not queued so is skipped
+	sq.bumped = []int{9, 7, 5}
+	expected := []int{9, 5, 1, 3}
+	if keys := sq.orderedE2EQueue(); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+	sq.removeBump(9)
+	expected = []int{5, 1, 3, 9}
+	if keys := sq.orderedE2EQueue(); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+}
@@ -117,34 +117,32 @@ func (b *BlunderbussMunger) Munge(obj *github.MungeObject) {
 		return
 	}
 
-	commits, err := obj.GetCommits()
+	files, err := obj.ListFiles()
 	if err != nil {
 		return
 	}
 
 	potentialOwners := weightMap{}
 	weightSum := int64(0)
-	for _, commit := range commits {
-		for _, file := range commit.Files {
-			fileWeight := int64(1)
-			if file.Changes != nil && *file.Changes != 0 {
-				fileWeight = int64(*file.Changes)
-			}
-			// Judge file size on a log scale-- effectively this
-			// makes three buckets, we shouldn't have many 10k+
-			// line changes.
-			fileWeight = int64(math.Log10(float64(fileWeight))) + 1
-			fileOwners := b.config.findOwners(*file.Filename)
-			if len(fileOwners) == 0 {
-				glog.Warningf("Couldn't find an owner for: %s", *file.Filename)
-			}
-			for owner, ownerWeight := range fileOwners {
-				if owner == *issue.User.Login {
-					continue
-				}
-				potentialOwners[owner] = potentialOwners[owner] + fileWeight*ownerWeight
-				weightSum += fileWeight * ownerWeight
+	for _, file := range files {
+		fileWeight := int64(1)
+		if file.Changes != nil && *file.Changes != 0 {
+			fileWeight = int64(*file.Changes)
+		}
+		// Judge file size on a log scale-- effectively this
+		// makes three buckets, we shouldn't have many 10k+
+		// line changes.
+		fileWeight = int64(math.Log10(float64(fileWeight))) + 1
+		fileOwners := b.config.findOwners(*file.Filename)
+		if len(fileOwners) == 0 {
+			glog.Warningf("Couldn't find an owner for: %s", *file.Filename)
+		}
+		for owner, ownerWeight := range fileOwners {
+			if owner == *issue.User.Login {
+				continue
 			}
+			potentialOwners[owner] = potentialOwners[owner] + fileWeight*ownerWeight
+			weightSum += fileWeight * ownerWeight
 		}
 	}
 	if len(potentialOwners) == 0 {
@@ -0,0 +1,179 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"k8s.io/contrib/mungegithub/github"
+	"k8s.io/kubernetes/pkg/util/sets"
+	"k8s.io/kubernetes/pkg/util/yaml"
+
+	"github.com/golang/glog"
+)
+
+// CodeFreeze describes a window of time during which merges to a branch are
+// restricted.  While the freeze is active only PRs which are in one of the
+// AllowedMilestones (if any are listed) and which have all of the
+// RequiredLabels (if any are listed) may be merged. A freeze with neither
+// list blocks every PR.
+type CodeFreeze struct {
+	// Branch is the base branch which is frozen. Empty means every branch.
+	Branch            string    `json:"branch,omitempty" yaml:"branch,omitempty"`
+	Start             time.Time `json:"start" yaml:"start"`
+	End               time.Time `json:"end" yaml:"end"`
+	AllowedMilestones []string  `json:"allowedMilestones,omitempty" yaml:"allowedMilestones,omitempty"`
+	RequiredLabels    []string  `json:"requiredLabels,omitempty" yaml:"requiredLabels,omitempty"`
+	// Message is shown in the web UI while the freeze is active.
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+// CodeFreezeConfig is the format of the file passed in --code-freeze-config
+type CodeFreezeConfig struct {
+	Freezes []CodeFreeze `json:"freezes,omitempty" yaml:"freezes,omitempty"`
+}
+
+type codeFreezeStatus struct {
+	Active  bool
+	Freezes []CodeFreeze
+}
+
+func loadCodeFreezeConfig(file string) (*CodeFreezeConfig, error) {
+	config := &CodeFreezeConfig{}
+	if len(file) == 0 {
+		return config, nil
+	}
+	fp, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	if err := yaml.NewYAMLToJSONDecoder(fp).Decode(config); err != nil {
+		return nil, err
+	}
+	for _, freeze := range config.Freezes {
+		if !freeze.End.After(freeze.Start) {
+			return nil, fmt.Errorf("code freeze for branch %q ends before it starts", freeze.Branch)
+		}
+	}
+	return config, nil
+}
+
+func (f *CodeFreeze) activeAt(t time.Time) bool {
+	return !t.Before(f.Start) && t.Before(f.End)
+}
+
+func (f *CodeFreeze) appliesTo(branch string) bool {
+	return len(f.Branch) == 0 || f.Branch == branch
+}
+
+// allows returns true if a PR in the given milestone and with the given labels
+// may merge during this freeze.
+func (f *CodeFreeze) allows(milestone string, labels sets.String) bool {
+	if len(f.AllowedMilestones) == 0 && len(f.RequiredLabels) == 0 {
+		return false
+	}
+	if len(f.AllowedMilestones) > 0 && !sets.NewString(f.AllowedMilestones...).Has(milestone) {
+		return false
+	}
+	return labels.HasAll(f.RequiredLabels...)
+}
+
+// activeFreezes returns all of the freezes which are in effect at time `t`
+func (c *CodeFreezeConfig) activeFreezes(t time.Time) []CodeFreeze {
+	out := []CodeFreeze{}
+	if c == nil {
+		return out
+	}
+	for _, freeze := range c.Freezes {
+		if freeze.activeAt(t) {
+			out = append(out, freeze)
+		}
+	}
+	return out
+}
+
+// blocks returns true if the PR is not allowed to merge into `branch` at time
+// `t` because of an active code freeze.
+func (c *CodeFreezeConfig) blocks(t time.Time, branch, milestone string, labels sets.String) bool {
+	for _, freeze := range c.activeFreezes(t) {
+		if freeze.appliesTo(branch) && !freeze.allows(milestone, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// blocksAnyBranch returns true if an active code freeze on any branch does not
+// allow the PR, for PRs whose branch is unknown.
+func (c *CodeFreezeConfig) blocksAnyBranch(t time.Time, milestone string, labels sets.String) bool {
+	for _, freeze := range c.activeFreezes(t) {
+		if !freeze.allows(milestone, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshCodeFreeze re-reads the code freeze config so that changes take
+// effect without restarting. If the file can not be read we keep using the
+// last good config.  sq.Lock() MUST be held!
+func (sq *SubmitQueue) refreshCodeFreeze() {
+	config, err := loadCodeFreezeConfig(sq.CodeFreezeConfigFile)
+	if err != nil {
+		glog.Errorf("Unable to load code freeze config %q, using previous config: %v", sq.CodeFreezeConfigFile, err)
+		return
+	}
+	sq.codeFreeze = config
+}
+
+// isFrozen returns true if the PR may not be merged because of a code freeze.
+// If the branch of the PR can't be found every active freeze applies to it.
+func (sq *SubmitQueue) isFrozen(obj *github.MungeObject) bool {
+	branch := ""
+	branchKnown := false
+	pr, err := obj.GetPR()
+	if err != nil {
+		glog.Errorf("PR %d: unable to get the base branch, treating every code freeze as applying: %v", *obj.Issue.Number, err)
+	} else if pr.Base != nil && pr.Base.Ref != nil {
+		branch = *pr.Base.Ref
+		branchKnown = true
+	}
+	milestone := ""
+	if obj.Issue.Milestone != nil && obj.Issue.Milestone.Title != nil {
+		milestone = *obj.Issue.Milestone.Title
+	}
+	sq.Lock()
+	defer sq.Unlock()
+	if !branchKnown {
+		return sq.codeFreeze.blocksAnyBranch(time.Now(), milestone, obj.LabelSet())
+	}
+	return sq.codeFreeze.blocks(time.Now(), branch, milestone, obj.LabelSet())
+}
+
+func (sq *SubmitQueue) getCodeFreezeStatus() []byte {
+	sq.Lock()
+	defer sq.Unlock()
+	freezes := sq.codeFreeze.activeFreezes(time.Now())
+	status := codeFreezeStatus{
+		Active:  len(freezes) > 0,
+		Freezes: freezes,
+	}
+	return sq.marshal(status)
+}
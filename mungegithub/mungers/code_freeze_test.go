@@ -0,0 +1,122 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/util/sets"
+)
+
+func TestCodeFreezeBlocks(t *testing.T) {
+	config := &CodeFreezeConfig{
+		Freezes: []CodeFreeze{
+			{
+				Branch:            "release-1.1",
+				Start:             time.Unix(100, 0),
+				End:               time.Unix(200, 0),
+				AllowedMilestones: []string{"v1.1.2"},
+				RequiredLabels:    []string{"cherrypick-approved"},
+			},
+			{
+				Branch: "master",
+				Start:  time.Unix(300, 0),
+				End:    time.Unix(400, 0),
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		time      int64
+		branch    string
+		milestone string
+		labels    []string
+		blocked   bool
+	}{
+		{
+			name:   "before freeze",
+			time:   50,
+			branch: "release-1.1",
+		},
+		{
+			name:    "during freeze, no milestone",
+			time:    150,
+			branch:  "release-1.1",
+			labels:  []string{"cherrypick-approved"},
+			blocked: true,
+		},
+		{
+			name:      "during freeze, milestone but no label",
+			time:      150,
+			branch:    "release-1.1",
+			milestone: "v1.1.2",
+			blocked:   true,
+		},
+		{
+			name:      "during freeze, milestone and label",
+			time:      150,
+			branch:    "release-1.1",
+			milestone: "v1.1.2",
+			labels:    []string{"lgtm", "cherrypick-approved"},
+		},
+		{
+			name:   "during freeze, other branch",
+			time:   150,
+			branch: "master",
+		},
+		{
+			name:   "after freeze",
+			time:   200,
+			branch: "release-1.1",
+		},
+		{
+			name:      "full freeze",
+			time:      350,
+			branch:    "master",
+			milestone: "v1.1.2",
+			labels:    []string{"cherrypick-approved"},
+			blocked:   true,
+		},
+	}
+	for _, test := range tests {
+		blocked := config.blocks(time.Unix(test.time, 0), test.branch, test.milestone, sets.NewString(test.labels...))
+		if blocked != test.blocked {
+			t.Errorf("%s: expected blocked=%v, got %v", test.name, test.blocked, blocked)
+		}
+	}
+
+	var empty *CodeFreezeConfig
+	if empty.blocks(time.Unix(150, 0), "release-1.1", "", sets.NewString()) {
+		t.Errorf("nil config should never block")
+	}
+
+	// A PR whose branch is unknown is blocked by a freeze on any branch
+	if !config.blocksAnyBranch(time.Unix(150, 0), "", sets.NewString()) {
+		t.Errorf("unknown branch during a freeze should be blocked")
+	}
+	if config.blocksAnyBranch(time.Unix(150, 0), "v1.1.2", sets.NewString("cherrypick-approved")) {
+		t.Errorf("unknown branch allowed by the freeze should not be blocked")
+	}
+	if config.blocksAnyBranch(time.Unix(250, 0), "", sets.NewString()) {
+		t.Errorf("unknown branch without an active freeze should not be blocked")
+	}
+	if empty.blocksAnyBranch(time.Unix(150, 0), "", sets.NewString()) {
+		t.Errorf("nil config should never block")
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"k8s.io/contrib/mungegithub/github"
+
+	"github.com/golang/glog"
+	github_api "github.com/google/go-github/github"
+	"github.com/spf13/cobra"
+)
+
+const (
+	commitMessageContext = "Commit Message"
+
+	// github will reject status descriptions longer than this
+	maxStatusDescriptionLen = 140
+)
+
+var signedOffByRE = regexp.MustCompile(`(?m)^Signed-off-by:\s*(.*?)\s*<([^>]+)>\s*$`)
+
+// CommitMessageMunger will check every commit in a PR for a Signed-off-by
+// line which matches the commit author and for a subject line which follows
+// the configured rules. The result is reported in the "Commit Message" github
+// status context.
+type CommitMessageMunger struct {
+	RequireSignoff    bool
+	MaxSubjectLength  int
+	SubjectPrefixes   []string
+	ForbiddenPrefixes []string
+}
+
+func init() {
+	RegisterMungerOrDie(&CommitMessageMunger{})
+}
+
+// Name is the name usable in --pr-mungers
+func (c *CommitMessageMunger) Name() string { return "commit-message" }
+
+// Initialize will initialize the munger
+func (c *CommitMessageMunger) Initialize(config *github.Config) error { return nil }
+
+// EachLoop is called at the start of every munge loop
+func (c *CommitMessageMunger) EachLoop() error { return nil }
+
+// AddFlags will add any request flags to the cobra `cmd`
+func (c *CommitMessageMunger) AddFlags(cmd *cobra.Command, config *github.Config) {
+	cmd.Flags().BoolVar(&c.RequireSignoff, "commit-require-signoff", true, "If true, every commit must have a Signed-off-by line matching the commit author")
+	cmd.Flags().IntVar(&c.MaxSubjectLength, "commit-subject-max-length", 72, "Maximum length of a commit subject line. 0 means no limit")
+	cmd.Flags().StringSliceVar(&c.SubjectPrefixes, "commit-subject-prefixes", []string{}, "If set, every commit subject must start with one of these prefixes")
+	cmd.Flags().StringSliceVar(&c.ForbiddenPrefixes, "commit-subject-forbidden-prefixes", []string{"fixup!", "squash!", "WIP"}, "Commit subjects starting with any of these prefixes are rejected")
+}
+
+// hasSignoff returns true if the commit message has a Signed-off-by line
+// whose email (or name) matches the author of the commit.
+func hasSignoff(commit *github_api.Commit) bool {
+	if commit.Message == nil || commit.Author == nil {
+		return false
+	}
+	for _, match := range signedOffByRE.FindAllStringSubmatch(*commit.Message, -1) {
+		name, email := match[1], match[2]
+		if commit.Author.Email != nil && strings.EqualFold(email, *commit.Author.Email) {
+			return true
+		}
+		if commit.Author.Name != nil && name == *commit.Author.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCommit returns a list of the problems found with the given commit. An
+// empty list means the commit is fine.
+func (c *CommitMessageMunger) checkCommit(commit *github_api.Commit) []string {
+	problems := []string{}
+	message := ""
+	if commit.Message != nil {
+		message = *commit.Message
+	}
+	subject := strings.TrimSpace(strings.SplitN(message, "\n", 2)[0])
+
+	if len(subject) == 0 {
+		problems = append(problems, "empty subject")
+	}
+	if c.MaxSubjectLength > 0 && utf8.RuneCountInString(subject) > c.MaxSubjectLength {
+		problems = append(problems, fmt.Sprintf("subject longer than %d", c.MaxSubjectLength))
+	}
+	for _, prefix := range c.ForbiddenPrefixes {
+		if strings.HasPrefix(subject, prefix) {
+			problems = append(problems, fmt.Sprintf("subject starts with %q", prefix))
+		}
+	}
+	if len(c.SubjectPrefixes) > 0 {
+		found := false
+		for _, prefix := range c.SubjectPrefixes {
+			if strings.HasPrefix(subject, prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, "subject missing required prefix")
+		}
+	}
+	if c.RequireSignoff && !hasSignoff(commit) {
+		problems = append(problems, "no Signed-off-by matching author")
+	}
+	return problems
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// Munge is the workhorse the will actually make updates to the PR
+func (c *CommitMessageMunger) Munge(obj *github.MungeObject) {
+	if !obj.IsPR() {
+		return
+	}
+
+	commits, err := obj.GetCommits()
+	if err != nil {
+		return
+	}
+
+	explanations := []string{}
+	for _, commit := range commits {
+		if commit.SHA == nil || commit.Commit == nil {
+			glog.Errorf("PR %d: Found invalid RepositoryCommit: %v", *obj.Issue.Number, commit)
+			continue
+		}
+		problems := c.checkCommit(commit.Commit)
+		if len(problems) == 0 {
+			continue
+		}
+		explanation := fmt.Sprintf("%s: %s", shortSHA(*commit.SHA), strings.Join(problems, ", "))
+		glog.V(2).Infof("PR %d: commit %s", *obj.Issue.Number, explanation)
+		explanations = append(explanations, explanation)
+	}
+
+	state := "success"
+	description := "All commit messages look good."
+	if len(explanations) > 0 {
+		state = "failure"
+		description = strings.Join(explanations, "; ")
+	}
+	description = truncateDescription(description)
+
+	status := obj.GetStatus(commitMessageContext)
+	if status != nil && status.State != nil && *status.State == state && status.Description != nil && *status.Description == description {
+		return
+	}
+	obj.SetStatus(state, "", description, commitMessageContext)
+}
+
+// truncateDescription shortens a status description to what github accepts,
+// cutting on a character boundary.
+func truncateDescription(description string) string {
+	if utf8.RuneCountInString(description) <= maxStatusDescriptionLen {
+		return description
+	}
+	runes := []rune(description)
+	return string(runes[:maxStatusDescriptionLen-3]) + "..."
+}
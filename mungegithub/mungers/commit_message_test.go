@@ -0,0 +1,122 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func messageCommit(name, email, message string) *github.Commit {
+	return &github.Commit{
+		Author: &github.CommitAuthor{
+			Name:  stringPtr(name),
+			Email: stringPtr(email),
+		},
+		Message: stringPtr(message),
+	}
+}
+
+func TestCheckCommit(t *testing.T) {
+	c := &CommitMessageMunger{
+		RequireSignoff:    true,
+		MaxSubjectLength:  20,
+		ForbiddenPrefixes: []string{"fixup!", "WIP"},
+	}
+
+	tests := []struct {
+		name     string
+		commit   *github.Commit
+		problems []string
+	}{
+		{
+			name:   "valid",
+			commit: messageCommit("Bob", "bob@example.com", "Fix a thing\n\nSigned-off-by: Bob <bob@example.com>"),
+		},
+		{
+			name:   "signoff email is case insensitive",
+			commit: messageCommit("Bob", "Bob@Example.com", "Fix a thing\n\nSigned-off-by: Robert <bob@example.com>"),
+		},
+		{
+			name:     "no signoff",
+			commit:   messageCommit("Bob", "bob@example.com", "Fix a thing"),
+			problems: []string{"no Signed-off-by"},
+		},
+		{
+			name:     "signoff from someone else",
+			commit:   messageCommit("Bob", "bob@example.com", "Fix a thing\n\nSigned-off-by: Alice <alice@example.com>"),
+			problems: []string{"no Signed-off-by"},
+		},
+		{
+			name:     "subject too long",
+			commit:   messageCommit("Bob", "bob@example.com", "Fix a thing that is too long\n\nSigned-off-by: Bob <bob@example.com>"),
+			problems: []string{"subject longer than 20"},
+		},
+		{
+			name:   "subject length counts characters",
+			commit: messageCommit("Bob", "bob@example.com", "Übersetze äöü Grüße\n\nSigned-off-by: Bob <bob@example.com>"),
+		},
+		{
+			name:     "fixup commit",
+			commit:   messageCommit("Bob", "bob@example.com", "fixup! Fix\n\nSigned-off-by: Bob <bob@example.com>"),
+			problems: []string{`subject starts with "fixup!"`},
+		},
+		{
+			name:     "WIP and no signoff",
+			commit:   messageCommit("Bob", "bob@example.com", "WIP"),
+			problems: []string{`subject starts with "WIP"`, "no Signed-off-by"},
+		},
+	}
+	for _, test := range tests {
+		problems := c.checkCommit(test.commit)
+		if len(problems) != len(test.problems) {
+			t.Errorf("%s: expected problems %v, got %v", test.name, test.problems, problems)
+			continue
+		}
+		for i := range problems {
+			if !strings.HasPrefix(problems[i], test.problems[i]) {
+				t.Errorf("%s: expected problem %q, got %q", test.name, test.problems[i], problems[i])
+			}
+		}
+	}
+
+	c.SubjectPrefixes = []string{"mungegithub:"}
+	problems := c.checkCommit(messageCommit("Bob", "bob@example.com", "Fix\n\nSigned-off-by: Bob <bob@example.com>"))
+	if len(problems) != 1 || problems[0] != "subject missing required prefix" {
+		t.Errorf("expected missing prefix problem, got %v", problems)
+	}
+}
+
+func TestTruncateDescription(t *testing.T) {
+	tests := []struct {
+		description string
+		expected    string
+	}{
+		{"short", "short"},
+		{strings.Repeat("a", maxStatusDescriptionLen), strings.Repeat("a", maxStatusDescriptionLen)},
+		{strings.Repeat("a", maxStatusDescriptionLen+1), strings.Repeat("a", maxStatusDescriptionLen-3) + "..."},
+		{strings.Repeat("é", maxStatusDescriptionLen), strings.Repeat("é", maxStatusDescriptionLen)},
+		{strings.Repeat("é", maxStatusDescriptionLen+1), strings.Repeat("é", maxStatusDescriptionLen-3) + "..."},
+	}
+	for _, test := range tests {
+		if got := truncateDescription(test.description); got != test.expected {
+			t.Errorf("truncateDescription(%q) = %q, expected %q", test.description, got, test.expected)
+		}
+	}
+}
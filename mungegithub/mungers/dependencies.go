@@ -0,0 +1,149 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+
+	"k8s.io/contrib/mungegithub/github"
+
+	"github.com/golang/glog"
+)
+
+// Matches "depends on #123" and "blocked by #123" anywhere in a PR body.
+var dependencyRE = regexp.MustCompile(`(?i)\b(?:depends\s+on|blocked\s+by)\s+#(\d+)`)
+
+type dependency struct {
+	Number int
+	Merged bool
+	// Closed is true if the PR was closed without being merged, so it
+	// never will be.
+	Closed bool
+}
+
+type dependencyStatus struct {
+	// Dependencies maps a PR number to the PRs it depends on
+	Dependencies map[string][]dependency
+}
+
+// parseDependencies returns the sorted list of PR numbers which the PR with
+// number `self` and description `body` says it depends on.
+func parseDependencies(self int, body string) []int {
+	found := map[int]bool{}
+	for _, match := range dependencyRE.FindAllStringSubmatch(body, -1) {
+		num, err := strconv.Atoi(match[1])
+		if err != nil || num == self {
+			continue
+		}
+		found[num] = true
+	}
+	out := []int{}
+	for num := range found {
+		out = append(out, num)
+	}
+	sort.Ints(out)
+	return out
+}
+
+// dependencyState returns whether the PR `num` has been merged, or closed
+// without being merged. If `num` is an issue and not a PR it counts as merged
+// once it is closed. Merged is terminal so positive answers are cached.
+func (sq *SubmitQueue) dependencyState(num int) (merged, closed bool) {
+	sq.Lock()
+	merged = sq.mergedDependencies[num]
+	sq.Unlock()
+	if merged {
+		return true, false
+	}
+
+	obj, err := sq.githubConfig.GetObject(num)
+	if err != nil {
+		return false, false
+	}
+	closed = obj.Issue.State != nil && *obj.Issue.State == "closed"
+	if obj.IsPR() {
+		merged, err = obj.IsMerged()
+		if err != nil {
+			glog.Errorf("Unable to determine if dependency %d is merged: %v", num, err)
+			return false, false
+		}
+	} else {
+		merged = closed
+	}
+	if merged {
+		sq.Lock()
+		sq.mergedDependencies[num] = true
+		sq.Unlock()
+		return true, false
+	}
+	return false, closed
+}
+
+// recordDependencies records the dependencies of the PR in the dependency
+// graph and returns them.
+func (sq *SubmitQueue) recordDependencies(obj *github.MungeObject) []dependency {
+	body := ""
+	if obj.Issue.Body != nil {
+		body = *obj.Issue.Body
+	}
+	deps := parseDependencies(*obj.Issue.Number, body)
+	if len(deps) == 0 {
+		return nil
+	}
+
+	graph := []dependency{}
+	for _, num := range deps {
+		merged, closed := sq.dependencyState(num)
+		graph = append(graph, dependency{Number: num, Merged: merged, Closed: closed})
+	}
+
+	sq.Lock()
+	sq.dependencies[strconv.Itoa(*obj.Issue.Number)] = graph
+	sq.Unlock()
+	return graph
+}
+
+// blockingDependencies returns the dependencies which are still open, and
+// those which were closed without being merged.
+func blockingDependencies(deps []dependency) (unmerged, closed []int) {
+	for _, dep := range deps {
+		switch {
+		case dep.Closed:
+			closed = append(closed, dep.Number)
+		case !dep.Merged:
+			unmerged = append(unmerged, dep.Number)
+		}
+	}
+	return unmerged, closed
+}
+
+func (sq *SubmitQueue) getDependencyStatus() []byte {
+	sq.Lock()
+	defer sq.Unlock()
+	status := dependencyStatus{
+		Dependencies: map[string][]dependency{},
+	}
+	for key, value := range sq.lastDependencies {
+		status.Dependencies[key] = value
+	}
+	for key, value := range sq.dependencies {
+		status.Dependencies[key] = value
+	}
+	return sq.marshal(status)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"reflect"
+	"testing"
+
+	github_util "k8s.io/contrib/mungegithub/github"
+	github_test "k8s.io/contrib/mungegithub/github/testing"
+)
+
+func TestParseDependencies(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected []int
+	}{
+		{
+			body:     "",
+			expected: []int{},
+		},
+		{
+			body:     "Fixes #12",
+			expected: []int{},
+		},
+		{
+			body:     "Depends on #12",
+			expected: []int{12},
+		},
+		{
+			body:     "This is blocked by #30\nand depends on #12, also BLOCKED  BY #30",
+			expected: []int{12, 30},
+		},
+		{
+			body:     "depends on #1 (which is me)",
+			expected: []int{},
+		},
+	}
+	for _, test := range tests {
+		deps := parseDependencies(1, test.body)
+		if !reflect.DeepEqual(deps, test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.body, test.expected, deps)
+		}
+	}
+}
+
+func TestRecordDependencies(t *testing.T) {
+	tests := []struct {
+		merged   bool
+		closed   bool
+		unmerged []int
+		closedPR []int
+	}{
+		{
+			merged: true,
+			closed: true,
+		},
+		{
+			unmerged: []int{2},
+		},
+		{
+			closed:   true,
+			closedPR: []int{2},
+		},
+	}
+	for testNum, test := range tests {
+		dep := github_test.Issue(whitelistUser, 2, nil, true)
+		if test.closed {
+			dep.State = stringPtr("closed")
+		}
+		depPR := github_test.PullRequest(whitelistUser, test.merged, true, true)
+		depPR.Number = intPtr(2)
+		client, server, _ := github_test.InitServer(t, dep, depPR, nil, nil, nil)
+
+		config := &github_util.Config{}
+		config.Org = "o"
+		config.Project = "r"
+		config.SetClient(client)
+
+		sq := SubmitQueue{
+			githubConfig:       config,
+			dependencies:       map[string][]dependency{},
+			mergedDependencies: map[int]bool{},
+		}
+		issue := github_test.Issue(whitelistUser, 1, nil, true)
+		issue.Body = stringPtr("depends on #2")
+		obj := github_util.TestObject(config, issue, nil, nil, nil)
+
+		unmerged, closed := blockingDependencies(sq.recordDependencies(obj))
+		if !reflect.DeepEqual(unmerged, test.unmerged) || !reflect.DeepEqual(closed, test.closedPR) {
+			t.Errorf("%d: expected unmerged %v closed %v, got %v %v", testNum, test.unmerged, test.closedPR, unmerged, closed)
+		}
+		graph := sq.dependencies["1"]
+		if len(graph) != 1 || graph[0].Number != 2 || graph[0].Merged != test.merged || graph[0].Closed != (test.closed && !test.merged) {
+			t.Errorf("%d: unexpected dependency graph %v", testNum, sq.dependencies)
+		}
+		server.Close()
+	}
+}
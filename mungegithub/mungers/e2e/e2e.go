@@ -18,6 +18,7 @@ package e2e
 
 import (
 	"sync"
+	"time"
 
 	"k8s.io/contrib/mungegithub/mungers/jenkins"
 
@@ -32,6 +33,10 @@ type E2ETester struct {
 
 	sync.Mutex
 	BuildStatus map[string]string // protect by mutex
+
+	// nonBlocking jobs are checked, but do not block the queue until the
+	// given time. protect by mutex
+	nonBlocking map[string]time.Time
 }
 
 func (e *E2ETester) locked(f func()) {
@@ -58,27 +63,61 @@ func (e *E2ETester) setBuildStatus(build, status string) {
 	e.BuildStatus[build] = status
 }
 
+// SetNonBlocking keeps `job` from blocking the queue until `until`. A zero
+// `until` makes the job blocking again.
+func (e *E2ETester) SetNonBlocking(job string, until time.Time) {
+	e.Lock()
+	defer e.Unlock()
+	if e.nonBlocking == nil {
+		e.nonBlocking = map[string]time.Time{}
+	}
+	if until.IsZero() {
+		delete(e.nonBlocking, job)
+		return
+	}
+	e.nonBlocking[job] = until
+}
+
+// GetNonBlocking returns the jobs which currently do not block the queue and
+// when they will start blocking again. This map is a copy.
+func (e *E2ETester) GetNonBlocking() map[string]time.Time {
+	e.Lock()
+	defer e.Unlock()
+	now := time.Now()
+	out := map[string]time.Time{}
+	for job, until := range e.nonBlocking {
+		if until.After(now) {
+			out[job] = until
+		}
+	}
+	return out
+}
+
 // Stable is called to make sure all of the jenkins jobs are stable
 func (e *E2ETester) Stable() bool {
 	// Test if the build is stable in Jenkins
 	jenkinsClient := &jenkins.JenkinsClient{Host: e.JenkinsHost}
 
+	nonBlocking := e.GetNonBlocking()
 	allStable := true
 	for _, build := range e.JenkinsJobs {
 		glog.V(2).Infof("Checking build stability for %s", build)
+		status := "Stable"
 		stable, err := jenkinsClient.IsBuildStable(build)
 		if err != nil {
 			glog.Errorf("Error checking build %v : %v", build, err)
-			e.setBuildStatus(build, "Error checking: "+err.Error())
-			allStable = false
-			continue
+			status = "Error checking: " + err.Error()
+		} else if !stable {
+			status = "Not Stable"
 		}
-		if stable {
-			e.setBuildStatus(build, "Stable")
-		} else {
-			e.setBuildStatus(build, "Not Stable")
-			allStable = false
+		if err != nil || !stable {
+			if until, ok := nonBlocking[build]; ok {
+				status += " (non-blocking until " + until.Format(time.RFC3339) + ")"
+			} else {
+				allStable = false
+			}
 		}
+		e.setBuildStatus(build, status)
 	}
 	return allStable
 }
@@ -0,0 +1,165 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gitmirror keeps a local clone of a github repository which can be
+// used to answer questions the github API can not, like which files in a PR
+// conflict with the base branch.
+package gitmirror
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// Mirror is a local clone of the repository at URL, stored in Dir
+type Mirror struct {
+	Dir string
+	URL string
+
+	// All git operations share a single work tree
+	sync.Mutex
+}
+
+// Conflict describes why a PR can not be merged into its base branch
+type Conflict struct {
+	// Files which could not be automatically merged
+	Files []string
+	// Commit is the most recent commit on the base branch, since the PR
+	// branched off, which touched one of the conflicting files.
+	Commit string
+}
+
+func (m *Mirror) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = m.Dir
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	glog.V(6).Infof("Running git %v in %s", args, m.Dir)
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("git %s failed: %v: %s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// Init will clone the repository into Dir if it has not been cloned already
+func (m *Mirror) Init() error {
+	m.Lock()
+	defer m.Unlock()
+	if _, err := os.Stat(filepath.Join(m.Dir, ".git")); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	if _, err := m.git("init", "-q"); err != nil {
+		return err
+	}
+	_, err := m.git("remote", "add", "origin", m.URL)
+	return err
+}
+
+const prRefPrefix = "refs/remotes/origin/pr/"
+
+func prRef(pr int) string {
+	return fmt.Sprintf("%s%d", prRefPrefix, pr)
+}
+
+func baseRef(branch string) string {
+	return "refs/remotes/origin/" + branch
+}
+
+// Conflicts fetches the base branch and the head of PR number `pr` and
+// attempts to merge them. If the merge succeeds nil is returned.
+func (m *Mirror) Conflicts(pr int, base string) (*Conflict, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	head := prRef(pr)
+	baseHead := baseRef(base)
+	fetchBase := fmt.Sprintf("+refs/heads/%s:%s", base, baseHead)
+	fetchPR := fmt.Sprintf("+refs/pull/%d/head:%s", pr, head)
+	if _, err := m.git("fetch", "-q", "origin", fetchBase, fetchPR); err != nil {
+		return nil, err
+	}
+	if _, err := m.git("checkout", "-q", "-f", "--detach", baseHead); err != nil {
+		return nil, err
+	}
+	// Always leave the work tree clean for the next caller
+	defer m.git("reset", "-q", "--hard")
+
+	_, mergeErr := m.git("-c", "user.name=mungegithub", "-c", "user.email=mungegithub@localhost", "merge", "-q", "--no-commit", "--no-ff", head)
+	out, err := m.git("diff", "--name-only", "--diff-filter=U")
+	if err != nil {
+		return nil, err
+	}
+	files := strings.Fields(out)
+	if len(files) == 0 {
+		if mergeErr != nil {
+			// The merge failed but not because of a conflict.
+			return nil, mergeErr
+		}
+		return nil, nil
+	}
+	sort.Strings(files)
+	conflict := &Conflict{
+		Files: files,
+	}
+
+	mergeBase, err := m.git("merge-base", baseHead, head)
+	if err != nil {
+		return conflict, err
+	}
+	args := append([]string{"log", "-1", "--format=%H", baseHead, "^" + strings.TrimSpace(mergeBase), "--"}, files...)
+	commit, err := m.git(args...)
+	if err != nil {
+		return conflict, err
+	}
+	conflict.Commit = strings.TrimSpace(commit)
+	return conflict, nil
+}
+
+// PrunePRs deletes the fetched heads of all PRs which are not in `open`, so
+// the mirror doesn't keep the commits of closed PRs forever.
+func (m *Mirror) PrunePRs(open map[int]bool) error {
+	m.Lock()
+	defer m.Unlock()
+
+	out, err := m.git("for-each-ref", "--format=%(refname)", prRefPrefix)
+	if err != nil {
+		return err
+	}
+	for _, ref := range strings.Fields(out) {
+		pr, err := strconv.Atoi(strings.TrimPrefix(ref, prRefPrefix))
+		if err != nil || open[pr] {
+			continue
+		}
+		if _, err := m.git("update-ref", "-d", ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
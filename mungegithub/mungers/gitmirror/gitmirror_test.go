@@ -0,0 +1,120 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitmirror
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// origin is a fake upstream repository
+type origin struct {
+	t *testing.T
+	m *Mirror
+}
+
+func (o *origin) run(args ...string) string {
+	out, err := o.m.git(append([]string{"-c", "user.name=test", "-c", "user.email=test@localhost"}, args...)...)
+	if err != nil {
+		o.t.Fatalf("%v", err)
+	}
+	return strings.TrimSpace(out)
+}
+
+func (o *origin) commit(file, contents, msg string) string {
+	if err := ioutil.WriteFile(filepath.Join(o.m.Dir, file), []byte(contents), 0644); err != nil {
+		o.t.Fatalf("%v", err)
+	}
+	o.run("add", file)
+	o.run("commit", "-q", "-m", msg)
+	return o.run("rev-parse", "HEAD")
+}
+
+func TestConflicts(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "gitmirror")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	o := &origin{t: t, m: &Mirror{Dir: filepath.Join(dir, "origin")}}
+	if err := os.MkdirAll(o.m.Dir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	o.run("init", "-q")
+	o.run("checkout", "-q", "-b", "master")
+	o.commit("a", "a\n", "add a")
+	o.commit("b", "b\n", "add b")
+
+	// PR 1 conflicts on a, PR 2 touches only c
+	o.run("checkout", "-q", "-b", "pr1")
+	o.commit("a", "pr1\n", "change a")
+	o.run("update-ref", "refs/pull/1/head", "HEAD")
+	o.run("checkout", "-q", "master")
+	o.run("checkout", "-q", "-b", "pr2")
+	o.commit("c", "c\n", "add c")
+	o.run("update-ref", "refs/pull/2/head", "HEAD")
+	o.run("checkout", "-q", "master")
+	culprit := o.commit("a", "master\n", "change a on master")
+	o.commit("b", "b2\n", "change b on master")
+
+	m := &Mirror{Dir: filepath.Join(dir, "mirror"), URL: o.m.Dir}
+	if err := m.Init(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	conflict, err := m.Conflicts(1, "master")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conflict == nil {
+		t.Fatalf("expected a conflict for PR 1")
+	}
+	if !reflect.DeepEqual(conflict.Files, []string{"a"}) {
+		t.Errorf("expected conflicting files [a], got %v", conflict.Files)
+	}
+	if conflict.Commit != culprit {
+		t.Errorf("expected conflicting commit %s, got %s", culprit, conflict.Commit)
+	}
+
+	conflict, err = m.Conflicts(2, "master")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conflict != nil {
+		t.Errorf("expected no conflict for PR 2, got %v", conflict)
+	}
+
+	if err := m.PrunePRs(map[int]bool{2: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	refs, err := m.git("for-each-ref", "--format=%(refname)", prRefPrefix)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Fields(refs); !reflect.DeepEqual(got, []string{prRef(2)}) {
+		t.Errorf("expected only the head of PR 2 to be kept, got %v", got)
+	}
+}
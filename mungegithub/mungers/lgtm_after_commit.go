@@ -53,16 +53,15 @@ func (LGTMAfterCommitMunger) Munge(obj *github.MungeObject) {
 		return
 	}
 
-	lastModified := obj.LastModifiedTime()
-	lgtmTime := obj.LabelTime("lgtm")
-
-	if lastModified == nil || lgtmTime == nil {
-		glog.Errorf("PR %d unable to determine lastModified or lgtmTime", *obj.Issue.Number)
+	changed, err := obj.ChangedSinceLabel("lgtm")
+	if err != nil {
+		glog.Errorf("PR %d unable to determine if it changed after lgtm: %v", *obj.Issue.Number, err)
 		return
 	}
 
-	if lastModified.After(*lgtmTime) {
-		glog.Infof("PR: %d lgtm:%s  lastModified:%s", *obj.Issue.Number, lgtmTime.String(), lastModified.String())
+	if changed {
+		head, _ := obj.HeadSHA()
+		glog.Infof("PR: %d lgtm:%q head:%q", *obj.Issue.Number, obj.LabelSHA("lgtm"), head)
 		lgtmRemovedBody := "PR changed after LGTM, removing LGTM."
 		if err := obj.WriteComment(lgtmRemovedBody); err != nil {
 			return
@@ -0,0 +1,111 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/contrib/mungegithub/github"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+const mergeStrategyLabelPrefix = "merge-strategy/"
+
+func validMergeStrategy(strategy string) bool {
+	switch strategy {
+	case github.MergeStrategyMerge, github.MergeStrategySquash, github.MergeStrategyRebase:
+		return true
+	}
+	return false
+}
+
+func (sq *SubmitQueue) addMergeStrategyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&sq.MergeStrategy, "merge-strategy", github.MergeStrategyMerge, "How the submit queue merges PRs: merge, squash, or rebase. Can be overridden per PR with a '"+mergeStrategyLabelPrefix+"<strategy>' label")
+	cmd.Flags().StringVar(&sq.SquashTrailerKey, "squash-trailer-key", "Reviewed-by", "Trailer added to squash commit messages naming the user who added --squash-trailer-label. Empty means no trailer")
+	cmd.Flags().StringVar(&sq.SquashTrailerLabel, "squash-trailer-label", "lgtm", "The label whose creator is named in the squash commit trailer")
+}
+
+// mergeStrategy returns the strategy to use for the given PR. A valid
+// 'merge-strategy/<strategy>' label wins over the --merge-strategy flag.
+func (sq *SubmitQueue) mergeStrategy(obj *github.MungeObject) string {
+	for _, label := range github.GetLabelsWithPrefix(obj.Issue.Labels, mergeStrategyLabelPrefix) {
+		strategy := strings.TrimPrefix(label, mergeStrategyLabelPrefix)
+		if validMergeStrategy(strategy) {
+			return strategy
+		}
+		glog.Errorf("PR %d has invalid merge strategy label %q", *obj.Issue.Number, label)
+	}
+	return sq.MergeStrategy
+}
+
+// squashCommitMessage composes the title and message of a squash commit from
+// the PR title and body followed by an optional trailer line.
+func squashCommitMessage(number int, title, body, trailer string) (string, string) {
+	commitTitle := fmt.Sprintf("%s (#%d)", title, number)
+	parts := []string{}
+	if body = strings.TrimSpace(body); len(body) > 0 {
+		parts = append(parts, body)
+	}
+	if len(trailer) > 0 {
+		parts = append(parts, trailer)
+	}
+	return commitTitle, strings.Join(parts, "\n\n")
+}
+
+func (sq *SubmitQueue) mergeOptions(obj *github.MungeObject) *github.MergeOptions {
+	opts := &github.MergeOptions{
+		Strategy: sq.mergeStrategy(obj),
+	}
+	switch opts.Strategy {
+	case github.MergeStrategySquash:
+		title, body := "", ""
+		if obj.Issue.Title != nil {
+			title = *obj.Issue.Title
+		}
+		if obj.Issue.Body != nil {
+			body = *obj.Issue.Body
+		}
+		trailer := ""
+		if len(sq.SquashTrailerKey) > 0 {
+			if who := obj.LabelCreator(sq.SquashTrailerLabel); len(who) > 0 {
+				trailer = fmt.Sprintf("%s: %s", sq.SquashTrailerKey, who)
+			}
+		}
+		opts.CommitTitle, opts.CommitMessage = squashCommitMessage(*obj.Issue.Number, title, body, trailer)
+	case github.MergeStrategyMerge:
+		opts.CommitMessage = github.MergeCommitMessage
+	}
+	return opts
+}
+
+// mergePR merges the PR with the configured strategy and records the merge
+// (and the strategy used) in the submit queue history.
+func (sq *SubmitQueue) mergePR(obj *github.MungeObject) {
+	opts := sq.mergeOptions(obj)
+	if err := obj.MergePRWithOptions("submit-queue", opts); err != nil {
+		sq.SetMergeStatus(obj, mergeFailed, true)
+		return
+	}
+	// Save a lookup when PRs which depend on this one are munged
+	sq.Lock()
+	sq.mergedDependencies[*obj.Issue.Number] = true
+	sq.Unlock()
+	sq.setMergeStatus(obj, merged, opts.Strategy, true)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"testing"
+
+	github_util "k8s.io/contrib/mungegithub/github"
+	github_test "k8s.io/contrib/mungegithub/github/testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestMergeOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   []string
+		events   []github.IssueEvent
+		body     string
+		strategy string
+		title    string
+		message  string
+	}{
+		{
+			name:     "default strategy",
+			labels:   []string{"lgtm"},
+			strategy: github_util.MergeStrategyMerge,
+			message:  github_util.MergeCommitMessage,
+		},
+		{
+			name:     "rebase label",
+			labels:   []string{"lgtm", "merge-strategy/rebase"},
+			strategy: github_util.MergeStrategyRebase,
+		},
+		{
+			name:     "invalid label falls back to flag",
+			labels:   []string{"lgtm", "merge-strategy/octopus"},
+			strategy: github_util.MergeStrategyMerge,
+			message:  github_util.MergeCommitMessage,
+		},
+		{
+			name:     "squash with trailer",
+			labels:   []string{"lgtm", "merge-strategy/squash"},
+			events:   NewLGTMEvents(),
+			body:     "Fixes the thing.\n",
+			strategy: github_util.MergeStrategySquash,
+			title:    "My issue title (#1)",
+			message:  "Fixes the thing.\n\nReviewed-by: bob",
+		},
+		{
+			name:     "squash without lgtm event",
+			labels:   []string{"merge-strategy/squash"},
+			events:   []github.IssueEvent{},
+			strategy: github_util.MergeStrategySquash,
+			title:    "My issue title (#1)",
+			message:  "",
+		},
+	}
+	for _, test := range tests {
+		issue := github_test.Issue(whitelistUser, 1, test.labels, true)
+		issue.Body = stringPtr(test.body)
+		config := &github_util.Config{}
+		obj := github_util.TestObject(config, issue, nil, nil, test.events)
+
+		sq := SubmitQueue{
+			MergeStrategy:      github_util.MergeStrategyMerge,
+			SquashTrailerKey:   "Reviewed-by",
+			SquashTrailerLabel: "lgtm",
+		}
+		if test.events != nil {
+			client, server, _ := github_test.InitServer(t, issue, nil, test.events, nil, nil)
+			config.Org = "o"
+			config.Project = "r"
+			config.SetClient(client)
+			defer server.Close()
+		}
+		opts := sq.mergeOptions(obj)
+		if opts.Strategy != test.strategy {
+			t.Errorf("%s: expected strategy %q, got %q", test.name, test.strategy, opts.Strategy)
+		}
+		if opts.CommitTitle != test.title {
+			t.Errorf("%s: expected title %q, got %q", test.name, test.title, opts.CommitTitle)
+		}
+		if opts.CommitMessage != test.message {
+			t.Errorf("%s: expected message %q, got %q", test.name, test.message, opts.CommitMessage)
+		}
+	}
+}
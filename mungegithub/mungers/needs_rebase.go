@@ -17,49 +17,174 @@ limitations under the License.
 package mungers
 
 import (
+	"fmt"
+	"strings"
+
 	"k8s.io/contrib/mungegithub/github"
+	"k8s.io/contrib/mungegithub/mungers/gitmirror"
 
 	"github.com/golang/glog"
 	"github.com/spf13/cobra"
 )
 
 // NeedsRebaseMunger will add the "needs-rebase" label to any issue which is
-// unable to be automatically merged
-type NeedsRebaseMunger struct{}
+// unable to be automatically merged. If --needs-rebase-git-dir is set it will
+// also use a local clone of the repo to tell the author which files conflict
+// and which commit introduced the conflict.
+type NeedsRebaseMunger struct {
+	GitDir string
+	GitURL string
+
+	mirror conflictFinder
+	// lastConflicts is the set of conflicting files we last told the author
+	// about, keyed by PR number. It is only used by Munge() and EachLoop()
+	// which are never called concurrently.
+	lastConflicts map[int]string
+	// munged is the set of PRs munged since the last EachLoop(). Only open
+	// PRs are munged, so the rest have been closed.
+	munged map[int]bool
+}
+
+// conflictFinder is implemented by gitmirror.Mirror
+type conflictFinder interface {
+	Conflicts(pr int, base string) (*gitmirror.Conflict, error)
+	PrunePRs(open map[int]bool) error
+}
 
 const needsRebase = "needs-rebase"
 
 func init() {
-	RegisterMungerOrDie(NeedsRebaseMunger{})
+	RegisterMungerOrDie(&NeedsRebaseMunger{})
 }
 
 // Name is the name usable in --pr-mungers
-func (NeedsRebaseMunger) Name() string { return "needs-rebase" }
+func (n *NeedsRebaseMunger) Name() string { return "needs-rebase" }
 
 // Initialize will initialize the munger
-func (NeedsRebaseMunger) Initialize(config *github.Config) error { return nil }
+func (n *NeedsRebaseMunger) Initialize(config *github.Config) error {
+	n.lastConflicts = map[int]string{}
+	if len(n.GitDir) == 0 {
+		return nil
+	}
+	url := n.GitURL
+	if len(url) == 0 {
+		url = fmt.Sprintf("https://github.com/%s/%s.git", config.Org, config.Project)
+	}
+	mirror := &gitmirror.Mirror{
+		Dir: n.GitDir,
+		URL: url,
+	}
+	n.mirror = mirror
+	return mirror.Init()
+}
 
-// EachLoop is called at the start of every munge loop
-func (NeedsRebaseMunger) EachLoop() error { return nil }
+// EachLoop is called at the start of every munge loop. It forgets about the
+// PRs which were closed during the last loop.
+func (n *NeedsRebaseMunger) EachLoop() error {
+	open := n.munged
+	n.munged = map[int]bool{}
+	if open == nil || n.mirror == nil {
+		return nil
+	}
+	for prNum := range n.lastConflicts {
+		if !open[prNum] {
+			delete(n.lastConflicts, prNum)
+		}
+	}
+	if err := n.mirror.PrunePRs(open); err != nil {
+		glog.Errorf("Unable to prune the refs of closed PRs: %v", err)
+	}
+	return nil
+}
 
 // AddFlags will add any request flags to the cobra `cmd`
-func (NeedsRebaseMunger) AddFlags(cmd *cobra.Command, config *github.Config) {}
+func (n *NeedsRebaseMunger) AddFlags(cmd *cobra.Command, config *github.Config) {
+	cmd.Flags().StringVar(&n.GitDir, "needs-rebase-git-dir", "", "Directory for a local clone of the repo used to find conflicting files. If empty only the label is managed")
+	cmd.Flags().StringVar(&n.GitURL, "needs-rebase-git-url", "", "URL to clone into --needs-rebase-git-dir. Defaults to the github repo for --organization and --project")
+}
+
+func conflictComment(login, base string, conflict *gitmirror.Conflict) string {
+	files := []string{}
+	for _, f := range conflict.Files {
+		files = append(files, fmt.Sprintf("* `%s`", f))
+	}
+	pr := "This PR"
+	if len(login) > 0 {
+		pr = fmt.Sprintf("@%s this PR", login)
+	}
+	msg := fmt.Sprintf("%s can not be automatically merged into `%s` and needs a rebase.\n\nConflicting files:\n%s\n", pr, base, strings.Join(files, "\n"))
+	if len(conflict.Commit) > 0 {
+		msg += fmt.Sprintf("\nThe conflict was introduced by %s.\n", conflict.Commit)
+	}
+	return msg
+}
+
+// notifyConflicts will comment on the PR with the conflicting files, but only
+// if they are different from what we told the author last time.
+func (n *NeedsRebaseMunger) notifyConflicts(obj *github.MungeObject, hadLabel bool) {
+	prNum := *obj.Issue.Number
+	pr, err := obj.GetPR()
+	if err != nil {
+		return
+	}
+	if pr.Base == nil || pr.Base.Ref == nil {
+		glog.Errorf("PR %d has no base branch", prNum)
+		return
+	}
+	base := *pr.Base.Ref
+	conflict, err := n.mirror.Conflicts(prNum, base)
+	if err != nil {
+		glog.Errorf("PR %d: unable to determine conflicting files: %v", prNum, err)
+		return
+	}
+	if conflict == nil {
+		glog.V(2).Infof("PR %d: github says unmergeable but local merge into %s succeeded", prNum, base)
+		return
+	}
+	key := strings.Join(conflict.Files, ",")
+	last, found := n.lastConflicts[prNum]
+	n.lastConflicts[prNum] = key
+	if !found && hadLabel {
+		// We've restarted since we labeled this PR. Don't nag the
+		// author again for what is probably the same conflict.
+		return
+	}
+	if last == key {
+		return
+	}
+	login := ""
+	if obj.Issue.User != nil && obj.Issue.User.Login != nil {
+		login = *obj.Issue.User.Login
+	}
+	obj.WriteComment(conflictComment(login, base, conflict))
+}
 
 // Munge is the workhorse the will actually make updates to the PR
-func (NeedsRebaseMunger) Munge(obj *github.MungeObject) {
+func (n *NeedsRebaseMunger) Munge(obj *github.MungeObject) {
 	if !obj.IsPR() {
 		return
 	}
+	if n.munged != nil {
+		n.munged[*obj.Issue.Number] = true
+	}
 
 	mergeable, err := obj.IsMergeable()
 	if err != nil {
 		glog.V(2).Infof("Skipping %d - problem determining mergeable", *obj.Issue.Number)
 		return
 	}
-	if mergeable && obj.HasLabel(needsRebase) {
-		obj.RemoveLabel(needsRebase)
+	hadLabel := obj.HasLabel(needsRebase)
+	if mergeable {
+		delete(n.lastConflicts, *obj.Issue.Number)
+		if hadLabel {
+			obj.RemoveLabel(needsRebase)
+		}
+		return
 	}
-	if !mergeable && !obj.HasLabel(needsRebase) {
+	if !hadLabel {
 		obj.AddLabels([]string{needsRebase})
 	}
+	if n.mirror != nil {
+		n.notifyConflicts(obj, hadLabel)
+	}
 }
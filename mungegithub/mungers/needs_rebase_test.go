@@ -0,0 +1,194 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	github_util "k8s.io/contrib/mungegithub/github"
+	github_test "k8s.io/contrib/mungegithub/github/testing"
+	"k8s.io/contrib/mungegithub/mungers/gitmirror"
+
+	"github.com/google/go-github/github"
+)
+
+// fakeConflictFinder reports `files` as conflicting for every PR
+type fakeConflictFinder struct {
+	files []string
+	open  map[int]bool
+}
+
+func (f *fakeConflictFinder) Conflicts(pr int, base string) (*gitmirror.Conflict, error) {
+	if len(f.files) == 0 {
+		return nil, nil
+	}
+	return &gitmirror.Conflict{Files: f.files}, nil
+}
+
+func (f *fakeConflictFinder) PrunePRs(open map[int]bool) error {
+	f.open = open
+	return nil
+}
+
+func TestNeedsRebaseComments(t *testing.T) {
+	tests := []struct {
+		name      string
+		mergeable bool
+		labeled   bool
+		files     []string
+		noAuthor  bool
+		comment   bool
+	}{
+		{
+			name:    "first conflict",
+			files:   []string{"a"},
+			comment: true,
+		},
+		{
+			name:    "same conflict",
+			labeled: true,
+			files:   []string{"a"},
+		},
+		{
+			name:    "more conflicting files",
+			labeled: true,
+			files:   []string{"a", "b"},
+			comment: true,
+		},
+		{
+			name:      "rebased",
+			labeled:   true,
+			mergeable: true,
+		},
+		{
+			name:    "conflicts again",
+			files:   []string{"a", "b"},
+			comment: true,
+		},
+		{
+			name:     "no author",
+			labeled:  true,
+			files:    []string{"c"},
+			noAuthor: true,
+			comment:  true,
+		},
+	}
+
+	issue := github_test.Issue(whitelistUser, 1, nil, true)
+	client, server, mux := github_test.InitServer(t, issue, nil, nil, nil, nil)
+	defer server.Close()
+	comments := []string{}
+	mux.HandleFunc("/repos/o/r/issues/1/comments", func(w http.ResponseWriter, r *http.Request) {
+		c := github.IssueComment{}
+		json.NewDecoder(r.Body).Decode(&c)
+		comments = append(comments, *c.Body)
+		data, _ := json.Marshal(c)
+		w.Write(data)
+	})
+	mux.HandleFunc("/repos/o/r/issues/1/labels", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	})
+	mux.HandleFunc("/repos/o/r/issues/1/labels/"+needsRebase, func(w http.ResponseWriter, r *http.Request) {})
+
+	config := &github_util.Config{}
+	config.Org = "o"
+	config.Project = "r"
+	config.SetClient(client)
+
+	finder := &fakeConflictFinder{}
+	n := NeedsRebaseMunger{}
+	if err := n.Initialize(config); err != nil {
+		t.Fatalf("%v", err)
+	}
+	n.mirror = finder
+	for _, test := range tests {
+		labels := []string{}
+		if test.labeled {
+			labels = append(labels, needsRebase)
+		}
+		issue := github_test.Issue(whitelistUser, 1, labels, true)
+		if test.noAuthor {
+			issue.User = nil
+		}
+		pr := github_test.PullRequest(whitelistUser, false, true, test.mergeable)
+		pr.Base = &github.PullRequestBranch{Ref: stringPtr("master")}
+		finder.files = test.files
+
+		before := len(comments)
+		n.Munge(github_util.TestObject(config, issue, pr, nil, nil))
+		commented := len(comments) > before
+		if commented != test.comment {
+			t.Errorf("%s: commented=%t but should be %t", test.name, commented, test.comment)
+		}
+		if !commented {
+			continue
+		}
+		last := comments[len(comments)-1]
+		for _, f := range test.files {
+			if !strings.Contains(last, fmt.Sprintf("`%s`", f)) {
+				t.Errorf("%s: expected %q to list %s", test.name, last, f)
+			}
+		}
+		if mention := strings.HasPrefix(last, "@"+whitelistUser+" "); mention != (issue.User != nil) {
+			t.Errorf("%s: unexpected mention in %q", test.name, last)
+		}
+	}
+}
+
+func TestNeedsRebaseRestart(t *testing.T) {
+	issue := github_test.Issue(whitelistUser, 1, []string{needsRebase}, true)
+	client, server, mux := github_test.InitServer(t, issue, nil, nil, nil, nil)
+	defer server.Close()
+	commented := false
+	mux.HandleFunc("/repos/o/r/issues/1/comments", func(w http.ResponseWriter, r *http.Request) {
+		commented = true
+		w.Write([]byte("{}"))
+	})
+
+	config := &github_util.Config{}
+	config.Org = "o"
+	config.Project = "r"
+	config.SetClient(client)
+
+	finder := &fakeConflictFinder{files: []string{"a"}}
+	n := NeedsRebaseMunger{}
+	n.Initialize(config)
+	n.mirror = finder
+	pr := github_test.PullRequest(whitelistUser, false, true, false)
+	pr.Base = &github.PullRequestBranch{Ref: stringPtr("master")}
+
+	// The PR was labeled before we restarted, the author was already told
+	n.EachLoop()
+	n.Munge(github_util.TestObject(config, issue, pr, nil, nil))
+	if commented {
+		t.Errorf("commented about a conflict the author was told about before the restart")
+	}
+
+	// Closed PRs are forgotten and their refs pruned
+	n.EachLoop()
+	if !finder.open[1] {
+		t.Errorf("expected PR 1 to be kept, got %v", finder.open)
+	}
+	n.EachLoop()
+	if len(finder.open) != 0 || len(n.lastConflicts) != 0 {
+		t.Errorf("expected closed PR 1 to be pruned, got %v %v", finder.open, n.lastConflicts)
+	}
+}
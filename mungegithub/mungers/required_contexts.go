@@ -0,0 +1,245 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+
+	"k8s.io/contrib/mungegithub/github"
+	"k8s.io/kubernetes/pkg/util/sets"
+	"k8s.io/kubernetes/pkg/util/yaml"
+
+	"github.com/golang/glog"
+)
+
+// github lists no more than this many files of a PR
+const maxPRFiles = 3000
+
+// RequiredContextRule changes the status contexts a PR needs to merge based
+// on its base branch and the files it changes. A rule with no Branches and no
+// Paths applies to every PR.
+type RequiredContextRule struct {
+	// Branches are globs, like "release-*", matched against the base branch.
+	// Empty means every branch.
+	Branches []string `json:"branches,omitempty" yaml:"branches,omitempty"`
+	// Paths are regexps matched against the files the PR changes. The rule
+	// applies if any file matches, or if OnlyPaths is set, if every file
+	// matches. Empty means every PR.
+	Paths     []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	OnlyPaths bool     `json:"onlyPaths,omitempty" yaml:"onlyPaths,omitempty"`
+	// Require lists contexts which must also be green
+	Require []string `json:"require,omitempty" yaml:"require,omitempty"`
+	// Skip lists contexts which are not needed, even if they are in
+	// --required-contexts or required by an earlier rule.
+	Skip []string `json:"skip,omitempty" yaml:"skip,omitempty"`
+
+	paths []*regexp.Regexp
+}
+
+// RequiredContextsConfig is the format of the file passed in
+// --required-contexts-config. Rules are applied in order.
+type RequiredContextsConfig struct {
+	Rules []RequiredContextRule `json:"rules,omitempty" yaml:"rules,omitempty"`
+}
+
+func loadRequiredContextsConfig(file string) (*RequiredContextsConfig, error) {
+	config := &RequiredContextsConfig{}
+	if len(file) == 0 {
+		return config, nil
+	}
+	fp, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	if err := yaml.NewYAMLToJSONDecoder(fp).Decode(config); err != nil {
+		return nil, err
+	}
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		for _, b := range rule.Branches {
+			if _, err := path.Match(b, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid branch %q: %v", i, b, err)
+			}
+		}
+		for _, p := range rule.Paths {
+			r, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid path %q: %v", i, p, err)
+			}
+			rule.paths = append(rule.paths, r)
+		}
+	}
+	return config, nil
+}
+
+func (r *RequiredContextRule) matchesBranch(branch string) bool {
+	if len(r.Branches) == 0 {
+		return true
+	}
+	for _, b := range r.Branches {
+		if ok, _ := path.Match(b, branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RequiredContextRule) matchesFile(file string) bool {
+	for _, p := range r.paths {
+		if p.MatchString(file) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesFiles returns true if the rule applies to a PR changing `files`
+func (r *RequiredContextRule) matchesFiles(files []string) bool {
+	if len(r.paths) == 0 {
+		return true
+	}
+	if len(files) == 0 {
+		return false
+	}
+	for _, f := range files {
+		matched := r.matchesFile(f)
+		if matched && !r.OnlyPaths {
+			return true
+		}
+		if !matched && r.OnlyPaths {
+			return false
+		}
+	}
+	return r.OnlyPaths
+}
+
+// needsFiles returns true if any rule for `branch` looks at the files changed
+func (c *RequiredContextsConfig) needsFiles(branch string) bool {
+	for i := range c.Rules {
+		if len(c.Rules[i].paths) > 0 && c.Rules[i].matchesBranch(branch) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns `contexts` changed by every rule which applies to a PR into
+// `branch` which changes `files`. If `files` is nil they are unknown, so path
+// rules may require contexts but never skip them.
+func (c *RequiredContextsConfig) apply(contexts []string, branch string, files []string) []string {
+	out := append([]string{}, contexts...)
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if !rule.matchesBranch(branch) {
+			continue
+		}
+		unknown := files == nil && len(rule.paths) > 0
+		if !unknown && !rule.matchesFiles(files) {
+			continue
+		}
+		skip := sets.NewString()
+		if !unknown {
+			skip.Insert(rule.Skip...)
+		}
+		kept := []string{}
+		for _, ctx := range out {
+			if !skip.Has(ctx) {
+				kept = append(kept, ctx)
+			}
+		}
+		have := sets.NewString(kept...)
+		for _, ctx := range rule.Require {
+			if !have.Has(ctx) {
+				kept = append(kept, ctx)
+				have.Insert(ctx)
+			}
+		}
+		out = kept
+	}
+	return out
+}
+
+// refreshRequiredContexts re-reads the required contexts config so that
+// changes take effect without restarting. If the file can not be read we keep
+// using the last good config.  sq.Lock() MUST be held!
+func (sq *SubmitQueue) refreshRequiredContexts() {
+	config, err := loadRequiredContextsConfig(sq.RequiredContextsConfigFile)
+	if err != nil {
+		glog.Errorf("Unable to load required contexts config %q, using previous config: %v", sq.RequiredContextsConfigFile, err)
+		return
+	}
+	sq.requiredContexts = config
+}
+
+// filesChanged returns every file changed by the PR. It is an error if the
+// PR changes so many files that github may not have listed them all.
+func filesChanged(obj *github.MungeObject) ([]string, error) {
+	prFiles, err := obj.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(prFiles) >= maxPRFiles {
+		return nil, fmt.Errorf("github lists at most %d files and the PR changes %d", maxPRFiles, len(prFiles))
+	}
+	files := sets.NewString()
+	for _, f := range prFiles {
+		if f.Filename != nil {
+			files.Insert(*f.Filename)
+		}
+	}
+	return files.List(), nil
+}
+
+// changedFiles are the files changed by a PR when its head was sha
+type changedFiles struct {
+	sha   string
+	files []string
+}
+
+// cachedFilesChanged returns the files changed by the PR, listing them only
+// once per head of the PR.
+func (sq *SubmitQueue) cachedFilesChanged(obj *github.MungeObject) ([]string, error) {
+	sha, err := obj.HeadSHA()
+	if err != nil {
+		return nil, err
+	}
+	num := *obj.Issue.Number
+	sq.Lock()
+	cached, ok := sq.changedFiles[num]
+	if !ok {
+		cached, ok = sq.lastChangedFiles[num]
+	}
+	sq.Unlock()
+	if ok && cached.sha == sha {
+		sq.Lock()
+		sq.changedFiles[num] = cached
+		sq.Unlock()
+		return cached.files, nil
+	}
+	files, err := filesChanged(obj)
+	if err != nil {
+		return nil, err
+	}
+	sq.Lock()
+	sq.changedFiles[num] = changedFiles{sha: sha, files: files}
+	sq.Unlock()
+	return files, nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const testRequiredContextsConfig = `
+rules:
+- paths: ["^docs/", "\\.md$"]
+  onlyPaths: true
+  skip: ["e2e"]
+- branches: ["release-*"]
+  require: ["upgrade"]
+- paths: ["^pkg/api/"]
+  require: ["api-compat"]
+`
+
+func TestRequiredContextsApply(t *testing.T) {
+	fp, err := ioutil.TempFile("", "required-contexts")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(fp.Name())
+	fp.WriteString(testRequiredContextsConfig)
+	fp.Close()
+	config, err := loadRequiredContextsConfig(fp.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	base := []string{"travis", "e2e", "unit"}
+	tests := []struct {
+		name     string
+		branch   string
+		files    []string
+		expected []string
+	}{
+		{
+			name:     "code on master",
+			branch:   "master",
+			files:    []string{"pkg/kubelet/kubelet.go"},
+			expected: base,
+		},
+		{
+			name:     "docs only",
+			branch:   "master",
+			files:    []string{"docs/README.md", "CHANGELOG.md"},
+			expected: []string{"travis", "unit"},
+		},
+		{
+			name:     "docs and code",
+			branch:   "master",
+			files:    []string{"docs/README.md", "pkg/kubelet/kubelet.go"},
+			expected: base,
+		},
+		{
+			name:     "release branch",
+			branch:   "release-1.2",
+			files:    []string{"pkg/kubelet/kubelet.go"},
+			expected: []string{"travis", "e2e", "unit", "upgrade"},
+		},
+		{
+			name:     "api change on release branch",
+			branch:   "release-1.2",
+			files:    []string{"pkg/api/types.go"},
+			expected: []string{"travis", "e2e", "unit", "upgrade", "api-compat"},
+		},
+		{
+			name:     "no files",
+			branch:   "master",
+			files:    []string{},
+			expected: base,
+		},
+		{
+			name:     "files unknown",
+			branch:   "master",
+			expected: []string{"travis", "e2e", "unit", "api-compat"},
+		},
+	}
+	for _, test := range tests {
+		got := config.apply(base, test.branch, test.files)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+	if len(base) != 3 {
+		t.Errorf("apply changed its input: %v", base)
+	}
+}
+
+func TestLoadRequiredContextsConfigInvalid(t *testing.T) {
+	tests := []string{
+		"rules:\n- branches: [\"release-[\"]\n",
+		"rules:\n- paths: [\"(\"]\n",
+	}
+	for _, test := range tests {
+		fp, err := ioutil.TempFile("", "required-contexts")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		fp.WriteString(test)
+		fp.Close()
+		if _, err := loadRequiredContextsConfig(fp.Name()); err == nil {
+			t.Errorf("expected an error loading %q", test)
+		}
+		os.Remove(fp.Name())
+	}
+}
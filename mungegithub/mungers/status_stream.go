@@ -0,0 +1,161 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// statusEventPR is sent when the current status of a PR changes
+	statusEventPR = "status"
+	// statusEventHistory is sent when an entry is added to the history
+	statusEventHistory = "history"
+	// statusEventSnapshot is the first event on every stream and holds
+	// the same data as /prs
+	statusEventSnapshot = "snapshot"
+
+	// How many events a slow client may fall behind before it is dropped.
+	// It will reconnect and start again from a snapshot.
+	statusStreamBuffer    = 256
+	statusStreamKeepAlive = 30 * time.Second
+)
+
+type statusEvent struct {
+	Type   string
+	Status submitStatus
+}
+
+// statusBroadcaster fans status changes out to everyone streaming /prs/stream
+type statusBroadcaster struct {
+	sync.Mutex
+	subscribers map[chan statusEvent]bool
+}
+
+func newStatusBroadcaster() *statusBroadcaster {
+	return &statusBroadcaster{
+		subscribers: map[chan statusEvent]bool{},
+	}
+}
+
+func (b *statusBroadcaster) subscribe() chan statusEvent {
+	b.Lock()
+	defer b.Unlock()
+	ch := make(chan statusEvent, statusStreamBuffer)
+	b.subscribers[ch] = true
+	return ch
+}
+
+func (b *statusBroadcaster) unsubscribe(ch chan statusEvent) {
+	b.Lock()
+	defer b.Unlock()
+	if b.subscribers[ch] {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish never blocks. Subscribers which are not keeping up are closed. A nil
+// broadcaster drops everything.
+func (b *statusBroadcaster) publish(eventType string, status submitStatus) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	event := statusEvent{Type: eventType, Status: status}
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			glog.Infof("Dropping slow /prs/stream client")
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func writeServerSentEvent(res http.ResponseWriter, eventType string, data []byte) error {
+	_, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", eventType, data)
+	return err
+}
+
+// serveStatusStream sends a snapshot of /prs followed by every change to a
+// PR's status and every new history entry as server-sent events.
+func (sq *SubmitQueue) serveStatusStream(res http.ResponseWriter, req *http.Request) {
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		http.Error(res, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before taking the snapshot so nothing is missed in between
+	events := sq.statusStream.subscribe()
+	defer sq.statusStream.unsubscribe(events)
+
+	snapshot := sq.getQueueStatus()
+	if snapshot == nil {
+		http.Error(res, "unable to get queue status", http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+	if err := writeServerSentEvent(res, statusEventSnapshot, snapshot); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	// Without a close notification a closed connection is only noticed when
+	// the next event or keep-alive fails to write.
+	var closed <-chan bool
+	if notifier, ok := res.(http.CloseNotifier); ok {
+		closed = notifier.CloseNotify()
+	}
+
+	keepAlive := time.NewTicker(statusStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event.Status)
+			if err != nil {
+				glog.Errorf("Unable to Marshal status event: %v", err)
+				continue
+			}
+			if err := writeServerSentEvent(res, event.Type, data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprintf(res, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatusBroadcasterDropsSlowClients(t *testing.T) {
+	b := newStatusBroadcaster()
+	fast := b.subscribe()
+	slow := b.subscribe()
+
+	for i := 0; i < statusStreamBuffer+1; i++ {
+		b.publish(statusEventPR, submitStatus{Reason: "r"})
+		<-fast
+	}
+	for i := 0; i < statusStreamBuffer; i++ {
+		<-slow
+	}
+	if _, ok := <-slow; ok {
+		t.Errorf("expected slow subscriber to be closed")
+	}
+	b.publish(statusEventPR, submitStatus{Reason: "after"})
+	if event := <-fast; event.Status.Reason != "after" {
+		t.Errorf("fast subscriber missed an event: %#v", event)
+	}
+	// Unsubscribing after being dropped must not panic
+	b.unsubscribe(slow)
+	b.unsubscribe(fast)
+
+	var none *statusBroadcaster
+	none.publish(statusEventPR, submitStatus{})
+}
+
+// readEvent reads one server-sent event, skipping comments
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	eventType, data := "", ""
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unable to read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if eventType != "" {
+				return eventType, data
+			}
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestServeStatusStream(t *testing.T) {
+	sq := &SubmitQueue{
+		lastPRStatus: map[string]submitStatus{"1": {Reason: noLGTM}},
+		prStatus:     map[string]submitStatus{"2": {Reason: ciFailure}},
+		statusStream: newStatusBroadcaster(),
+	}
+	server := httptest.NewServer(http.HandlerFunc(sq.serveStatusStream))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-type"); ct != "text/event-stream" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	eventType, data := readEvent(t, r)
+	if eventType != statusEventSnapshot {
+		t.Fatalf("expected snapshot first, got %q", eventType)
+	}
+	snapshot := submitQueueStatus{}
+	if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+		t.Fatalf("unable to decode snapshot: %v", err)
+	}
+	if len(snapshot.PRStatus) != 2 {
+		t.Errorf("expected both old and new status in snapshot: %v", snapshot.PRStatus)
+	}
+	if len(sq.lastPRStatus) != 1 {
+		t.Errorf("snapshot modified lastPRStatus: %v", sq.lastPRStatus)
+	}
+
+	sq.Lock()
+	sq.recordHistory(submitStatus{Reason: merged})
+	sq.Unlock()
+	eventType, data = readEvent(t, r)
+	status := submitStatus{}
+	if err := json.Unmarshal([]byte(data), &status); err != nil {
+		t.Fatalf("unable to decode event: %v", err)
+	}
+	if eventType != statusEventHistory || status.Reason != merged {
+		t.Errorf("unexpected event %q: %#v", eventType, status)
+	}
+}
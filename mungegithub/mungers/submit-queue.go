@@ -54,6 +54,12 @@ type submitStatus struct {
 	Time time.Time
 	statusPullRequest
 	Reason string
+	// MergeStrategy is only set when Reason is 'merged'
+	MergeStrategy string `json:",omitempty"`
+	// Admin is set when the entry records an admin action
+	Admin string `json:",omitempty"`
+	// RequiredContexts are the status contexts this PR needs to merge
+	RequiredContexts []string `json:",omitempty"`
 }
 
 type statusPullRequest struct {
@@ -96,6 +102,17 @@ type SubmitQueue struct {
 	UnitStatusContext      string
 	RequiredStatusContexts []string
 	WWWRoot                string
+	CodeFreezeConfigFile   string
+	MergeStrategy          string
+	SquashTrailerKey       string
+	SquashTrailerLabel     string
+	AdminTokensFile        string
+	// RequiredContextsConfigFile changes RequiredStatusContexts by base
+	// branch and changed files
+	RequiredContextsConfigFile string
+	// WhitelistTeams are org teams whose members are whitelisted
+	WhitelistTeams           []string
+	WhitelistReconcilePeriod time.Duration
 
 	// additionalUserWhitelist are non-committer users believed safe
 	additionalUserWhitelist *sets.String
@@ -106,6 +123,30 @@ type SubmitQueue struct {
 	// userWhitelist is the combination of committers and additional which
 	// we actully use
 	userWhitelist *sets.String
+	// trustReasons maps each user in userWhitelist to why they are in it
+	trustReasons map[string][]string
+	// teamMembers are the members of each of the WhitelistTeams as of the
+	// last time they could be listed
+	teamMembers map[string][]userInfo
+	// reconciliation is the last check of the whitelist files against the
+	// members of the org
+	reconciliation *whitelistReconciliation
+	lastReconcile  time.Time
+
+	// codeFreeze is reloaded from CodeFreezeConfigFile every loop.
+	// protected by sync.Mutex
+	codeFreeze *CodeFreezeConfig
+	// requiredContexts is reloaded from RequiredContextsConfigFile every
+	// loop. protected by sync.Mutex
+	requiredContexts *RequiredContextsConfig
+	// prContexts maps PR number to the contexts it needs to merge, as of
+	// the last time it was munged. protected by sync.Mutex
+	prContexts map[string][]string
+	// changedFiles maps PR number to the files it changes, so they are
+	// listed once per head. Those of PRs not munged for a loop are
+	// forgotten. protected by sync.Mutex
+	changedFiles     map[int]changedFiles
+	lastChangedFiles map[int]changedFiles
 
 	sync.Mutex
 	lastPRStatus  map[string]submitStatus
@@ -113,6 +154,25 @@ type SubmitQueue struct {
 	userInfo      map[string]userInfo     //proteted by sync.Mutex
 	statusHistory []submitStatus          // protected by sync.Mutex
 
+	// The dependency graph from 'depends on #N' in PR descriptions. Like
+	// prStatus the graph is rebuilt every loop.
+	lastDependencies   map[string][]dependency
+	dependencies       map[string][]dependency // protected by sync.Mutex
+	mergedDependencies map[int]bool            // protected by sync.Mutex
+
+	// adminTokens maps a token to the admin it belongs to
+	adminTokens map[string]string
+	// State changed through the /admin/ endpoints. protected by sync.Mutex
+	paused      bool
+	pausedBy    string
+	pauseReason string
+	bumped      []int
+	ejected     map[int]time.Time
+
+	// statusStream sends changes to prStatus and statusHistory to
+	// everyone watching /prs/stream
+	statusStream *statusBroadcaster
+
 	// Every time a PR is added to githubE2EQueue also notify the channel
 	githubE2EWakeup  chan bool
 	githubE2ERunning *github.MungeObject         // protect by sync.Mutex!
@@ -137,6 +197,12 @@ func (sq *SubmitQueue) Initialize(config *github.Config) error {
 	if len(sq.JenkinsHost) == 0 {
 		glog.Fatalf("--jenkins-host is required.")
 	}
+	if len(sq.MergeStrategy) == 0 {
+		sq.MergeStrategy = github.MergeStrategyMerge
+	}
+	if !validMergeStrategy(sq.MergeStrategy) {
+		glog.Fatalf("--merge-strategy must be one of merge, squash or rebase, not %q", sq.MergeStrategy)
+	}
 
 	e2e := &e2e.E2ETester{
 		JenkinsJobs: sq.JenkinsJobs,
@@ -144,6 +210,27 @@ func (sq *SubmitQueue) Initialize(config *github.Config) error {
 		BuildStatus: map[string]string{},
 	}
 	sq.e2e = e2e
+
+	freezeConfig, err := loadCodeFreezeConfig(sq.CodeFreezeConfigFile)
+	if err != nil {
+		glog.Fatalf("Failed to load code freeze config: %v", err)
+	}
+	sq.codeFreeze = freezeConfig
+
+	contextsConfig, err := loadRequiredContextsConfig(sq.RequiredContextsConfigFile)
+	if err != nil {
+		glog.Fatalf("Failed to load required contexts config: %v", err)
+	}
+	sq.requiredContexts = contextsConfig
+
+	if len(sq.AdminTokensFile) > 0 {
+		tokens, err := loadAdminTokens(sq.AdminTokensFile)
+		if err != nil {
+			glog.Fatalf("Failed to load admin tokens: %v", err)
+		}
+		sq.adminTokens = tokens
+	}
+
 	if len(sq.Address) > 0 {
 		if len(sq.WWWRoot) > 0 {
 			http.Handle("/", http.FileServer(http.Dir(sq.WWWRoot)))
@@ -151,12 +238,18 @@ func (sq *SubmitQueue) Initialize(config *github.Config) error {
 		http.HandleFunc("/prs", func(w http.ResponseWriter, r *http.Request) {
 			sq.servePRs(w, r)
 		})
+		http.HandleFunc("/prs/stream", func(w http.ResponseWriter, r *http.Request) {
+			sq.serveStatusStream(w, r)
+		})
 		http.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
 			sq.serveHistory(w, r)
 		})
 		http.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
 			sq.serveUsers(w, r)
 		})
+		http.HandleFunc("/users/trust", func(w http.ResponseWriter, r *http.Request) {
+			sq.serveTrust(w, r)
+		})
 		http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
 			sq.serveBotStats(w, r)
 		})
@@ -166,10 +259,24 @@ func (sq *SubmitQueue) Initialize(config *github.Config) error {
 		http.HandleFunc("/google-internal-ci", func(w http.ResponseWriter, r *http.Request) {
 			sq.serveGoogleInternalStatus(w, r)
 		})
+		http.HandleFunc("/code-freeze", func(w http.ResponseWriter, r *http.Request) {
+			sq.serveCodeFreeze(w, r)
+		})
+		http.HandleFunc("/dependencies", func(w http.ResponseWriter, r *http.Request) {
+			sq.serveDependencies(w, r)
+		})
+		sq.registerAdminHandlers()
 		go http.ListenAndServe(sq.Address, nil)
 	}
 	sq.prStatus = map[string]submitStatus{}
 	sq.lastPRStatus = map[string]submitStatus{}
+	sq.dependencies = map[string][]dependency{}
+	sq.lastDependencies = map[string][]dependency{}
+	sq.mergedDependencies = map[int]bool{}
+	sq.ejected = map[int]time.Time{}
+	sq.prContexts = map[string][]string{}
+	sq.changedFiles = map[int]changedFiles{}
+	sq.statusStream = newStatusBroadcaster()
 
 	sq.githubE2EWakeup = make(chan bool, 1000)
 	sq.githubE2EQueue = map[int]*github.MungeObject{}
@@ -181,11 +288,17 @@ func (sq *SubmitQueue) Initialize(config *github.Config) error {
 
 // EachLoop is called at the start of every munge loop
 func (sq *SubmitQueue) EachLoop() error {
+	sq.RefreshWhitelist()
 	sq.Lock()
 	defer sq.Unlock()
-	sq.RefreshWhitelist()
+	sq.refreshCodeFreeze()
+	sq.refreshRequiredContexts()
 	sq.lastPRStatus = sq.prStatus
 	sq.prStatus = map[string]submitStatus{}
+	sq.lastDependencies = sq.dependencies
+	sq.dependencies = map[string][]dependency{}
+	sq.lastChangedFiles = sq.changedFiles
+	sq.changedFiles = map[int]changedFiles{}
 	return nil
 }
 
@@ -203,10 +316,14 @@ func (sq *SubmitQueue) AddFlags(cmd *cobra.Command, config *github.Config) {
 	}, "Comma separated list of jobs in Jenkins to use for stability testing")
 	cmd.Flags().StringVar(&sq.JenkinsHost, "jenkins-host", "http://jenkins-master:8080", "The URL for the jenkins job to watch")
 	cmd.Flags().StringSliceVar(&sq.RequiredStatusContexts, "required-contexts", []string{travisContext}, "Comma separate list of status contexts required for a PR to be considered ok to merge")
+	cmd.Flags().StringVar(&sq.RequiredContextsConfigFile, "required-contexts-config", "", "Path to a yaml file of rules which change --required-contexts by base branch and changed files. Re-read every loop")
 	cmd.Flags().StringVar(&sq.Address, "address", ":8080", "The address to listen on for HTTP Status")
 	cmd.Flags().StringVar(&sq.E2EStatusContext, "e2e-status-context", jenkinsE2EContext, "The name of the github status context for the e2e PR Builder")
 	cmd.Flags().StringVar(&sq.UnitStatusContext, "unit-status-context", jenkinsUnitContext, "The name of the github status context for the unit PR Builder")
 	cmd.Flags().StringVar(&sq.WWWRoot, "www", "www", "Path to static web files to serve from the webserver")
+	cmd.Flags().StringVar(&sq.CodeFreezeConfigFile, "code-freeze-config", "", "Path to a yaml file describing code freeze windows. Re-read every loop")
+	sq.addMergeStrategyFlags(cmd)
+	sq.addAdminFlags(cmd)
 	sq.addWhitelistCommand(cmd, config)
 }
 
@@ -264,11 +381,18 @@ func reasonToState(reason string) string {
 //    're-run github e2e' state as these are more obvious, change less, and don't
 //    seem to ever confuse people.
 func (sq *SubmitQueue) SetMergeStatus(obj *github.MungeObject, reason string, record bool) {
+	sq.setMergeStatus(obj, reason, "", record)
+}
+
+// setMergeStatus is SetMergeStatus but also records which merge strategy was
+// used when the reason is `merged`.
+func (sq *SubmitQueue) setMergeStatus(obj *github.MungeObject, reason, strategy string, record bool) {
 	glog.V(4).Infof("SubmitQueue not merging %d because %q", *obj.Issue.Number, reason)
 	submitStatus := submitStatus{
 		Time:              time.Now(),
 		statusPullRequest: *objToStatusPullRequest(obj),
 		Reason:            reason,
+		MergeStrategy:     strategy,
 	}
 
 	status := obj.GetStatus(sqContext)
@@ -281,6 +405,8 @@ func (sq *SubmitQueue) SetMergeStatus(obj *github.MungeObject, reason string, re
 	sq.Lock()
 	defer sq.Unlock()
 
+	submitStatus.RequiredContexts = sq.prContexts[strconv.Itoa(*obj.Issue.Number)]
+
 	// If we are currently retesting E2E the normal munge loop might find
 	// that the ci tests are not green. That's normal and expected and we
 	// should just ignore that status update entirely.
@@ -289,15 +415,29 @@ func (sq *SubmitQueue) SetMergeStatus(obj *github.MungeObject, reason string, re
 	}
 
 	if record {
-		sq.statusHistory = append(sq.statusHistory, submitStatus)
-		if len(sq.statusHistory) > 128 {
-			sq.statusHistory = sq.statusHistory[1:]
-		}
+		sq.recordHistory(submitStatus)
+	}
+	key := strconv.Itoa(*obj.Issue.Number)
+	last, ok := sq.prStatus[key]
+	if !ok {
+		last, ok = sq.lastPRStatus[key]
+	}
+	if !ok || last.Reason != submitStatus.Reason {
+		sq.statusStream.publish(statusEventPR, submitStatus)
 	}
-	sq.prStatus[strconv.Itoa(*obj.Issue.Number)] = submitStatus
+	sq.prStatus[key] = submitStatus
 	sq.cleanupOldE2E(obj, reason)
 }
 
+// sq.Lock() MUST be held!
+func (sq *SubmitQueue) recordHistory(status submitStatus) {
+	sq.statusHistory = append(sq.statusHistory, status)
+	if len(sq.statusHistory) > 128 {
+		sq.statusHistory = sq.statusHistory[1:]
+	}
+	sq.statusStream.publish(statusEventHistory, status)
+}
+
 // sq.Lock() MUST be held!
 func (sq *SubmitQueue) getE2EQueueStatus() []*statusPullRequest {
 	queue := []*statusPullRequest{}
@@ -337,7 +477,10 @@ func (sq *SubmitQueue) getQueueStatus() []byte {
 	status := submitQueueStatus{}
 	sq.Lock()
 	defer sq.Unlock()
-	outputStatus := sq.lastPRStatus
+	outputStatus := map[string]submitStatus{}
+	for key, value := range sq.lastPRStatus {
+		outputStatus[key] = value
+	}
 	for key, value := range sq.prStatus {
 		outputStatus[key] = value
 	}
@@ -380,23 +523,68 @@ const (
 	ciFailure               = "Github CI tests are not green."
 	e2eFailure              = "The e2e tests are failing. The entire submit queue is blocked."
 	merged                  = "MERGED!"
+	mergeFailed             = "Github refused to merge the PR. Will try again later."
 	ghE2EQueued             = "Queued to run github e2e tests a second time."
 	ghE2EWaitingStart       = "Requested and waiting for github e2e test to start running a second time."
 	ghE2ERunning            = "Running github e2e tests a second time."
 	ghE2EFailed             = "Second github e2e run failed."
+	codeFreeze              = "The target branch is in a code freeze and this PR is not approved to merge."
+	unmergedDependency      = "PR depends on another PR which has not been merged."
+	closedDependency        = "PR depends on another PR which was closed without being merged. Reopen it or remove it from the description."
 )
 
+// requiredStatusContexts returns the contexts which must be green for the PR
+// to merge: --required-contexts, e2e and unit, as changed by the rules in
+// --required-contexts-config. It is remembered to be shown in /prs.
 func (sq *SubmitQueue) requiredStatusContexts(obj *github.MungeObject) []string {
-	contexts := sq.RequiredStatusContexts
+	contexts := append([]string{}, sq.RequiredStatusContexts...)
 	if len(sq.E2EStatusContext) > 0 && !obj.HasLabel(e2eNotRequiredLabel) {
 		contexts = append(contexts, sq.E2EStatusContext)
 	}
 	if len(sq.UnitStatusContext) > 0 {
 		contexts = append(contexts, sq.UnitStatusContext)
 	}
+
+	branch := ""
+	if pr, err := obj.GetPR(); err == nil && pr.Base != nil && pr.Base.Ref != nil {
+		branch = *pr.Base.Ref
+	}
+	sq.Lock()
+	config := sq.requiredContexts
+	sq.Unlock()
+	if config != nil {
+		files := []string{}
+		if config.needsFiles(branch) {
+			var err error
+			if files, err = sq.cachedFilesChanged(obj); err != nil {
+				glog.Errorf("PR %d: unable to get changed files, path rules will not skip contexts: %v", *obj.Issue.Number, err)
+			}
+		}
+		contexts = config.apply(contexts, branch, files)
+	}
+
+	sq.Lock()
+	sq.prContexts[strconv.Itoa(*obj.Issue.Number)] = contexts
+	sq.Unlock()
 	return contexts
 }
 
+// e2eSkipped returns true if a --required-contexts-config rule removed the
+// e2e context for the PR, so it need not be retested before it merges.
+func (sq *SubmitQueue) e2eSkipped(obj *github.MungeObject) bool {
+	if len(sq.E2EStatusContext) == 0 || obj.HasLabel(e2eNotRequiredLabel) {
+		return false
+	}
+	sq.Lock()
+	defer sq.Unlock()
+	for _, ctx := range sq.prContexts[strconv.Itoa(*obj.Issue.Number)] {
+		if ctx == sq.E2EStatusContext {
+			return false
+		}
+	}
+	return true
+}
+
 // Munge is the workhorse the will actually make updates to the PR
 func (sq *SubmitQueue) Munge(obj *github.MungeObject) {
 	if !obj.IsPR() {
@@ -406,6 +594,11 @@ func (sq *SubmitQueue) Munge(obj *github.MungeObject) {
 	e2e := sq.e2e
 	userSet := sq.userWhitelist
 
+	// Figured out first so every PR in /prs shows what it needs, and
+	// every PR with dependencies is in /dependencies
+	contexts := sq.requiredStatusContexts(obj)
+	deps := sq.recordDependencies(obj)
+
 	if !obj.HasLabels([]string{claYes}) && !obj.HasLabels([]string{claHuman}) {
 		sq.SetMergeStatus(obj, noCLA, false)
 		return
@@ -420,7 +613,6 @@ func (sq *SubmitQueue) Munge(obj *github.MungeObject) {
 	}
 
 	// Validate the status information for this PR
-	contexts := sq.requiredStatusContexts(obj)
 	if ok := obj.IsStatusSuccess(contexts); !ok {
 		sq.SetMergeStatus(obj, ciFailure, false)
 		return
@@ -447,29 +639,55 @@ func (sq *SubmitQueue) Munge(obj *github.MungeObject) {
 	}
 
 	lastModifiedTime := obj.LastModifiedTime()
-	lgtmTime := obj.LabelTime("lgtm")
-
-	if lastModifiedTime == nil || lgtmTime == nil {
-		glog.Errorf("PR %d was unable to determine when LGTM was added or when last modified", *obj.Issue.Number)
+	changed, err := obj.ChangedSinceLabel("lgtm")
+	if err != nil {
+		glog.Errorf("PR %d was unable to determine if it changed after LGTM: %v", *obj.Issue.Number, err)
 		sq.SetMergeStatus(obj, unknown, false)
 		return
 	}
 
-	if lastModifiedTime.After(*lgtmTime) {
+	if changed {
 		sq.SetMergeStatus(obj, lgtmEarly, false)
 		return
 	}
 
+	if sq.isEjected(obj, lastModifiedTime) {
+		sq.SetMergeStatus(obj, prEjected, false)
+		return
+	}
+
+	unmerged, closed := blockingDependencies(deps)
+	if len(closed) > 0 {
+		glog.V(4).Infof("PR %d depends on closed PRs %v", *obj.Issue.Number, closed)
+		sq.SetMergeStatus(obj, closedDependency, false)
+		return
+	}
+	if len(unmerged) > 0 {
+		glog.V(4).Infof("PR %d depends on unmerged PRs %v", *obj.Issue.Number, unmerged)
+		sq.SetMergeStatus(obj, unmergedDependency, false)
+		return
+	}
+
+	if sq.isFrozen(obj) {
+		sq.SetMergeStatus(obj, codeFreeze, false)
+		return
+	}
+
+	if sq.isPaused() {
+		sq.SetMergeStatus(obj, queuePaused, false)
+		return
+	}
+
 	if !e2e.Stable() {
 		sq.flushGithubE2EQueue(e2eFailure)
 		sq.SetMergeStatus(obj, e2eFailure, false)
 		return
 	}
 
-	// if there is a 'e2e-not-required' label, just merge it.
-	if obj.HasLabel(e2eNotRequiredLabel) {
-		obj.MergePR("submit-queue")
-		sq.SetMergeStatus(obj, merged, true)
+	// if there is a 'e2e-not-required' label, or e2e is not required for
+	// this branch and these files, just merge it.
+	if obj.HasLabel(e2eNotRequiredLabel) || sq.e2eSkipped(obj) {
+		sq.mergePR(obj)
 		return
 	}
 
@@ -521,15 +739,37 @@ func (sq *SubmitQueue) flushGithubE2EQueue(reason string) {
 
 // sq.Lock() better held!!!
 func (sq *SubmitQueue) orderedE2EQueue() []int {
-	// Find and do the lowest PR number first
+	// PRs bumped by an admin go first, in the order they were bumped
 	var keys []int
+	for _, k := range sq.bumped {
+		if _, ok := sq.githubE2EQueue[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	// Then find and do the lowest PR number first
+	var rest []int
 	for k := range sq.githubE2EQueue {
-		keys = append(keys, k)
+		rest = append(rest, k)
+	}
+	sort.Ints(rest)
+	for _, k := range rest {
+		if !sq.isBumped(k) {
+			keys = append(keys, k)
+		}
 	}
-	sort.Ints(keys)
 	return keys
 }
 
+// sq.Lock() better held!!!
+func (sq *SubmitQueue) isBumped(num int) bool {
+	for _, n := range sq.bumped {
+		if n == num {
+			return true
+		}
+	}
+	return false
+}
+
 // handleGithubE2EAndMerge waits for PRs that are ready to re-run the github
 // e2e tests, runs the test, and then merges if everything was successful.
 func (sq *SubmitQueue) handleGithubE2EAndMerge() {
@@ -551,18 +791,25 @@ func (sq *SubmitQueue) handleGithubE2EAndMerge() {
 		sq.githubE2ERunning = obj
 		sq.Unlock()
 
-		// re-test and maybe merge
-		sq.doGithubE2EAndMerge(obj)
+		// re-test and maybe merge. Everything from here to the merge
+		// must happen even when we are short on API tokens.
+		sq.doGithubE2EAndMerge(obj.WithPriority(github.PriorityCritical))
 
 		// remove it from the map after we finish testing
 		sq.Lock()
 		sq.githubE2ERunning = nil
 		delete(sq.githubE2EQueue, keys[0])
+		sq.removeBump(keys[0])
 		sq.Unlock()
 	}
 }
 
 func (sq *SubmitQueue) doGithubE2EAndMerge(obj *github.MungeObject) {
+	if sq.isPaused() {
+		sq.SetMergeStatus(obj, queuePaused, true)
+		return
+	}
+
 	_, err := obj.RefreshPR()
 	if err != nil {
 		glog.Errorf("%d: unknown err: %v", *obj.Issue.Number, err)
@@ -579,6 +826,16 @@ func (sq *SubmitQueue) doGithubE2EAndMerge(obj *github.MungeObject) {
 		return
 	}
 
+	// Something may have been pushed while the PR waited in the queue
+	if changed, err := obj.ChangedSinceLabel("lgtm"); err != nil {
+		glog.Errorf("%d: unknown err: %v", *obj.Issue.Number, err)
+		sq.SetMergeStatus(obj, unknown, true)
+		return
+	} else if changed {
+		sq.SetMergeStatus(obj, lgtmEarly, true)
+		return
+	}
+
 	if mergeable, err := obj.IsMergeable(); err != nil {
 		sq.SetMergeStatus(obj, undeterminedMergability, true)
 		return
@@ -624,8 +881,23 @@ func (sq *SubmitQueue) doGithubE2EAndMerge(obj *github.MungeObject) {
 		return
 	}
 
-	obj.MergePR("submit-queue")
-	sq.SetMergeStatus(obj, merged, true)
+	// The freeze may have started while we were testing
+	if sq.isFrozen(obj) {
+		sq.SetMergeStatus(obj, codeFreeze, true)
+		return
+	}
+
+	// An admin may also have paused the queue or ejected the PR
+	if sq.isPaused() {
+		sq.SetMergeStatus(obj, queuePaused, true)
+		return
+	}
+	if sq.isEjected(obj, nil) {
+		sq.SetMergeStatus(obj, prEjected, true)
+		return
+	}
+
+	sq.mergePR(obj)
 	return
 }
 
@@ -669,3 +941,13 @@ func (sq *SubmitQueue) serveGoogleInternalStatus(res http.ResponseWriter, req *h
 	data := sq.getGoogleInternalStatus()
 	sq.serve(data, res, req)
 }
+
+func (sq *SubmitQueue) serveCodeFreeze(res http.ResponseWriter, req *http.Request) {
+	data := sq.getCodeFreezeStatus()
+	sq.serve(data, res, req)
+}
+
+func (sq *SubmitQueue) serveDependencies(res http.ResponseWriter, req *http.Request) {
+	data := sq.getDependencyStatus()
+	sq.serve(data, res, req)
+}
@@ -258,6 +258,7 @@ func TestMunge(t *testing.T) {
 		e2ePass          bool
 		unitPass         bool
 		mergeAfterQueued bool
+		mergeFails       bool
 		reason           string
 		state            string // what the github status context should be for the PR HEAD
 	}{
@@ -463,6 +464,20 @@ func TestMunge(t *testing.T) {
 			reason:     ghE2EFailed,
 			state:      "pending",
 		},
+		{
+			name:       "Fail because github refuses the merge",
+			pr:         ValidPR(),
+			issue:      NoOKToMergeIssue(),
+			events:     NewLGTMEvents(),
+			commits:    Commits(), // Modified at time.Unix(7), 8, and 9
+			ciStatus:   SuccessStatus(),
+			jenkinsJob: SuccessJenkins(),
+			e2ePass:    true,
+			unitPass:   true,
+			mergeFails: true,
+			reason:     mergeFailed,
+			state:      "pending",
+		},
 	}
 	for testNum, test := range tests {
 		issueNum := testNum + 1
@@ -532,6 +547,11 @@ func TestMunge(t *testing.T) {
 			if r.Method != "PUT" {
 				t.Errorf("Unexpected method: %s", r.Method)
 			}
+			if test.mergeFails {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				w.Write([]byte(`{"message": "Pull Request is not mergeable"}`))
+				return
+			}
 			w.WriteHeader(http.StatusOK)
 			data, err := json.Marshal(github.PullRequestMergeResult{})
 			if err != nil {
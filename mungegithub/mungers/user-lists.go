@@ -24,8 +24,10 @@ import (
 	"bufio"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	github_util "k8s.io/contrib/mungegithub/github"
 	"k8s.io/kubernetes/pkg/util/sets"
@@ -38,11 +40,37 @@ var (
 	_ = fmt.Print
 )
 
+const (
+	trustWhitelistFile  = "whitelist file"
+	trustCommittersFile = "committers file"
+	trustPushAccess     = "push access"
+	trustPullAccess     = "pull access"
+	trustTeamPrefix     = "member of team "
+)
+
+// whitelistReconciliation is the result of checking the whitelist files
+// against the members of the org.
+type whitelistReconciliation struct {
+	Time time.Time
+	// NotInOrg are users in the whitelist files who are not members of
+	// the org. They are still trusted until removed from the files.
+	NotInOrg []string
+	Error    string `json:",omitempty"`
+}
+
+type trustStatus struct {
+	// Users maps each trusted user to why they are trusted
+	Users          map[string][]string
+	Reconciliation *whitelistReconciliation `json:",omitempty"`
+}
+
 // RefreshWhitelist updates the whitelist, re-getting the list of committers.
-// called with sq.Lock() held!
+// Github is queried without sq.Lock() held, the lock is only taken to read
+// the configuration and to swap in the results.
 func (sq *SubmitQueue) RefreshWhitelist() {
-	config := sq.githubConfig
-	info := map[string]userInfo{}
+	config := sq.githubConfig.WithPriority(github_util.PriorityBackground)
+
+	sq.Lock()
 	if sq.additionalUserWhitelist == nil {
 		users, err := loadWhitelist(sq.Whitelist)
 		if err != nil {
@@ -50,7 +78,6 @@ func (sq *SubmitQueue) RefreshWhitelist() {
 		}
 		sq.additionalUserWhitelist = &users
 	}
-
 	if sq.committerList == nil {
 		committerList, err := loadWhitelist(sq.Committers)
 		if err != nil {
@@ -58,27 +85,92 @@ func (sq *SubmitQueue) RefreshWhitelist() {
 		}
 		sq.committerList = &committerList
 	}
+	additionalUsers := sets.NewString(sq.additionalUserWhitelist.List()...)
+	committers := sets.NewString(sq.committerList.List()...)
+	lastTeamMembers := sq.teamMembers
+	reconcile := sq.WhitelistReconcilePeriod > 0 && time.Since(sq.lastReconcile) >= sq.WhitelistReconcilePeriod
+	if reconcile {
+		sq.lastReconcile = time.Now()
+	}
+	sq.Unlock()
 
 	// We must use the values on disk in case it has users which don't have
 	// explicit "pull" permission in the API
-	allUsers := sq.additionalUserWhitelist.Union(*sq.committerList)
+	allUsers := additionalUsers.Union(committers)
+	info := map[string]userInfo{}
+	reasons := map[string][]string{}
+	trust := func(login, reason string) {
+		allUsers.Insert(login)
+		reasons[login] = append(reasons[login], reason)
+	}
+	for _, login := range additionalUsers.List() {
+		trust(login, trustWhitelistFile)
+	}
+	for _, login := range committers.List() {
+		trust(login, trustCommittersFile)
+	}
+
+	teamMembers := map[string][]userInfo{}
+	if len(sq.WhitelistTeams) > 0 {
+		teams, err := config.TeamMembers(sq.WhitelistTeams)
+		if err != nil {
+			glog.Errorf("Unable to get members of %v: %v", sq.WhitelistTeams, err)
+		}
+		for _, team := range sq.WhitelistTeams {
+			users, ok := teams[team]
+			if !ok {
+				// Keep the last good membership rather than
+				// dropping everyone in the team.
+				if last, ok := lastTeamMembers[team]; ok {
+					glog.Warningf("Using the last known members of team %q", team)
+					teamMembers[team] = last
+				} else {
+					glog.Errorf("Members of team %q are not whitelisted", team)
+				}
+				continue
+			}
+			members := []userInfo{}
+			for _, user := range users {
+				if user.Login == nil {
+					continue
+				}
+				member := userInfo{
+					Login:  *user.Login,
+					Access: trustTeamPrefix + team,
+				}
+				if user.AvatarURL != nil {
+					member.AvatarURL = *user.AvatarURL
+				}
+				members = append(members, member)
+			}
+			teamMembers[team] = members
+		}
+	}
+	for _, team := range sq.WhitelistTeams {
+		for _, member := range teamMembers[team] {
+			trust(member.Login, member.Access)
+			if len(member.AvatarURL) > 0 {
+				info[member.Login] = member
+			}
+		}
+	}
 
 	if pushUsers, pullUsers, err := config.UsersWithAccess(); err != nil {
 		glog.Info("Falling back to static committers list.")
 	} else {
 		for _, user := range pullUsers {
-			allUsers.Insert(*user.Login)
+			trust(*user.Login, trustPullAccess)
 			info[*user.Login] = userInfo{
 				Login:     *user.Login,
-				Access:    "pull access",
+				Access:    trustPullAccess,
 				AvatarURL: *user.AvatarURL,
 			}
 		}
 		for _, user := range pushUsers {
-			allUsers.Insert(*user.Login)
+			trust(*user.Login, trustPushAccess)
 			info[*user.Login] = userInfo{
 				Login:     *user.Login,
-				Access:    "push access",
+				Access:    trustPushAccess,
 				AvatarURL: *user.AvatarURL,
 			}
 		}
@@ -100,9 +192,62 @@ func (sq *SubmitQueue) RefreshWhitelist() {
 			AvatarURL: *user.AvatarURL,
 		}
 	}
+
+	var reconciliation *whitelistReconciliation
+	if reconcile {
+		reconciliation = reconcileWhitelist(config, additionalUsers.Union(committers))
+	}
+
+	sq.Lock()
+	defer sq.Unlock()
 	sq.userWhitelist = &allUsers
 	sq.userInfo = info
-	return
+	sq.trustReasons = reasons
+	sq.teamMembers = teamMembers
+	if reconciliation != nil {
+		sq.reconciliation = reconciliation
+	}
+}
+
+// reconcileWhitelist finds the users in the whitelist files who are no longer
+// members of the org, so they can be removed by hand.
+func reconcileWhitelist(config *github_util.Config, fileUsers sets.String) *whitelistReconciliation {
+	out := &whitelistReconciliation{
+		Time:     time.Now(),
+		NotInOrg: []string{},
+	}
+	members, err := config.OrgMembers()
+	if err != nil {
+		glog.Errorf("Unable to reconcile the whitelist with the members of the org: %v", err)
+		out.Error = err.Error()
+		return out
+	}
+	orgUsers := sets.NewString()
+	for _, user := range members {
+		if user.Login != nil {
+			orgUsers.Insert(*user.Login)
+		}
+	}
+	out.NotInOrg = fileUsers.Difference(orgUsers).List()
+	if len(out.NotInOrg) > 0 {
+		glog.Warningf("Whitelisted users who are not members of the org: %v", out.NotInOrg)
+	}
+	return out
+}
+
+func (sq *SubmitQueue) getTrustStatus() []byte {
+	sq.Lock()
+	defer sq.Unlock()
+	status := trustStatus{
+		Users:          sq.trustReasons,
+		Reconciliation: sq.reconciliation,
+	}
+	return sq.marshal(status)
+}
+
+func (sq *SubmitQueue) serveTrust(res http.ResponseWriter, req *http.Request) {
+	data := sq.getTrustStatus()
+	sq.serve(data, res, req)
 }
 
 func loadWhitelist(file string) (sets.String, error) {
@@ -178,6 +323,8 @@ func (sq *SubmitQueue) addWhitelistCommand(root *cobra.Command, config *github_u
 	}
 	root.PersistentFlags().StringVar(&sq.Whitelist, "user-whitelist", "./whitelist.txt", "Path to a whitelist file that contains users to auto-merge.  Required.")
 	root.PersistentFlags().StringVar(&sq.Committers, "committers", "./committers.txt", "File in which the list of authorized committers is stored; only used if this list cannot be gotten at run time.  (Merged with whitelist; separate so that it can be auto-generated)")
+	root.Flags().StringSliceVar(&sq.WhitelistTeams, "whitelist-teams", []string{}, "Comma separated list of teams, by name or slug, in the --organization whose members are whitelisted")
+	root.Flags().DurationVar(&sq.WhitelistReconcilePeriod, "whitelist-reconcile-period", 24*time.Hour, "How often to check the whitelist files for users who are no longer members of the --organization. 0 disables the check")
 	root.Flags().StringVar(&sq.WhitelistOverride, "whitelist-override-label", "ok-to-merge", "Github label, if present on a PR it will be merged even if the author isn't in the whitelist")
 
 	root.AddCommand(genCommitters)
@@ -0,0 +1,99 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mungers
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	github_util "k8s.io/contrib/mungegithub/github"
+	github_test "k8s.io/contrib/mungegithub/github/testing"
+	"k8s.io/kubernetes/pkg/util/sets"
+)
+
+func TestRefreshWhitelistTeams(t *testing.T) {
+	client, server, mux := github_test.InitServer(t, nil, nil, nil, nil, nil)
+	defer server.Close()
+	mux.HandleFunc("/orgs/o/teams", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 1, "name": "Kubernetes Maintainers", "slug": "kubernetes-maintainers"}, {"id": 2, "name": "other", "slug": "other"}]`))
+	})
+	failMembers := false
+	mux.HandleFunc("/teams/1/members", func(w http.ResponseWriter, r *http.Request) {
+		if failMembers {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`[{"login": "alice", "avatar_url": "alice.png"}, {"login": "bob", "avatar_url": "bob.png"}]`))
+	})
+	mux.HandleFunc("/teams/2/members", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("members of a team which is not whitelisted were listed")
+	})
+	mux.HandleFunc("/orgs/o/members", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"login": "alice"}, {"login": "bob"}]`))
+	})
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		login := r.URL.Path[len("/users/"):]
+		fmt.Fprintf(w, `{"login": %q, "avatar_url": "%s.png"}`, login, login)
+	})
+
+	config := &github_util.Config{Org: "o", Project: "r"}
+	config.SetClient(client)
+	sq := &SubmitQueue{
+		githubConfig:             config,
+		WhitelistTeams:           []string{"kubernetes-maintainers"},
+		WhitelistReconcilePeriod: time.Hour,
+		additionalUserWhitelist:  &sets.String{},
+		committerList:            &sets.String{},
+	}
+	sq.additionalUserWhitelist.Insert("alice", "carol")
+	sq.RefreshWhitelist()
+
+	if !sq.userWhitelist.HasAll("alice", "bob", "carol") {
+		t.Errorf("expected alice, bob and carol to be whitelisted, got %v", sq.userWhitelist.List())
+	}
+	expected := map[string][]string{
+		"alice": {trustWhitelistFile, trustTeamPrefix + "kubernetes-maintainers"},
+		"bob":   {trustTeamPrefix + "kubernetes-maintainers"},
+		"carol": {trustWhitelistFile},
+	}
+	if !reflect.DeepEqual(sq.trustReasons, expected) {
+		t.Errorf("expected reasons %v, got %v", expected, sq.trustReasons)
+	}
+	if sq.reconciliation == nil || !reflect.DeepEqual(sq.reconciliation.NotInOrg, []string{"carol"}) {
+		t.Errorf("expected carol to not be in the org, got %#v", sq.reconciliation)
+	}
+
+	// Reconciliation only happens once per period
+	last := sq.reconciliation
+	sq.RefreshWhitelist()
+	if sq.reconciliation != last {
+		t.Errorf("whitelist was reconciled again before the period passed")
+	}
+
+	// The last members are kept if the team can't be listed
+	failMembers = true
+	sq.RefreshWhitelist()
+	if !sq.userWhitelist.HasAll("alice", "bob", "carol") {
+		t.Errorf("expected the last members of the team to stay whitelisted, got %v", sq.userWhitelist.List())
+	}
+	if !reflect.DeepEqual(sq.trustReasons, expected) {
+		t.Errorf("expected reasons %v, got %v", expected, sq.trustReasons)
+	}
+}
@@ -0,0 +1,484 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reports
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	githubhelper "k8s.io/contrib/mungegithub/github"
+	"k8s.io/kubernetes/pkg/util/sets"
+
+	"github.com/golang/glog"
+	"github.com/google/go-github/github"
+	"github.com/spf13/cobra"
+)
+
+// AnalyticsReport computes how long PRs wait for review and merge, and how
+// the review and merge work is spread across people, over a window of time.
+// It writes analytics.csv (one row per PR), analytics.json and
+// analytics.html into OutputDir.
+type AnalyticsReport struct {
+	Window       time.Duration
+	OutputDir    string
+	QueueContext string
+	IgnoreUsers  []string
+}
+
+func init() {
+	RegisterReportOrDie(&AnalyticsReport{})
+}
+
+// Name is the name usable in --issue-reports
+func (a *AnalyticsReport) Name() string { return "analytics" }
+
+// AddFlags will add any request flags to the cobra `cmd`
+func (a *AnalyticsReport) AddFlags(cmd *cobra.Command, config *githubhelper.Config) {
+	cmd.Flags().DurationVar(&a.Window, "analytics-window", 28*24*time.Hour, "How far back the analytics report looks for PRs")
+	cmd.Flags().StringVar(&a.OutputDir, "analytics-dir", ".", "Directory to write analytics.csv, analytics.json and analytics.html")
+	cmd.Flags().StringVar(&a.QueueContext, "analytics-queue-context", "Submit Queue", "Status context set by the submit queue. The first 'success' in this context is when the PR entered the queue")
+	cmd.Flags().StringSliceVar(&a.IgnoreUsers, "analytics-ignore-users", []string{"k8s-merge-robot", "k8s-bot"}, "Users (bots) whose comments and labels do not count as reviews")
+}
+
+// prAnalytics is the information about a single PR. Durations are in hours
+// and are nil when they could not be determined.
+type prAnalytics struct {
+	Number            int
+	Author            string
+	Reviewer          string
+	Created           time.Time
+	Merged            *time.Time
+	FirstReviewHours  *float64
+	LGTMToMergeHours  *float64
+	InQueueHours      *float64
+	MergedWithoutLGTM bool
+}
+
+// durationStats summarizes a set of durations, in hours
+type durationStats struct {
+	Count  int
+	Mean   float64
+	Median float64
+	P90    float64
+}
+
+type personStats struct {
+	Login string
+	// Number of PRs authored and merged
+	Authored int
+	// Number of merged PRs this user gave the LGTM to
+	Reviewed int
+	// Number of open PRs currently assigned to this user
+	Assigned int
+}
+
+type analyticsSummary struct {
+	Org         string
+	Project     string
+	Start       time.Time
+	End         time.Time
+	Opened      int
+	Merged      int
+	FirstReview durationStats
+	LGTMToMerge durationStats
+	InQueue     durationStats
+	People      []personStats
+	PRs         []prAnalytics
+	// Skipped is the number of PRs left out because they could not be
+	// fetched from github.
+	Skipped int
+}
+
+func hoursBetween(start time.Time, end *time.Time) *float64 {
+	if end == nil || end.Before(start) {
+		return nil
+	}
+	h := end.Sub(start).Hours()
+	return &h
+}
+
+// firstReviewTime returns the time of the first comment or label by someone
+// who is not the author or an ignored user.
+func firstReviewTime(author string, ignore sets.String, events []github.IssueEvent, comments []github.IssueComment) *time.Time {
+	var first *time.Time
+	consider := func(login *string, t *time.Time) {
+		if login == nil || t == nil || *login == author || ignore.Has(*login) {
+			return
+		}
+		if first == nil || t.Before(*first) {
+			first = t
+		}
+	}
+	for i := range comments {
+		c := &comments[i]
+		if c.User != nil {
+			consider(c.User.Login, c.CreatedAt)
+		}
+	}
+	for i := range events {
+		e := &events[i]
+		if e.Event == nil || *e.Event != "labeled" || e.Actor == nil {
+			continue
+		}
+		consider(e.Actor.Login, e.CreatedAt)
+	}
+	return first
+}
+
+// lastLabelEvent returns the last event which added `label`
+func lastLabelEvent(label string, events []github.IssueEvent) *github.IssueEvent {
+	var out *github.IssueEvent
+	for i := range events {
+		e := &events[i]
+		if e.Event == nil || *e.Event != "labeled" || e.Label == nil || e.Label.Name == nil || *e.Label.Name != label || e.CreatedAt == nil {
+			continue
+		}
+		if out == nil || e.CreatedAt.After(*out.CreatedAt) {
+			out = e
+		}
+	}
+	return out
+}
+
+// queueEntryTime returns the first time the submit queue reported success,
+// which is when the PR was queued for its final test and merge.
+func queueEntryTime(context string, statuses []github.RepoStatus) *time.Time {
+	var first *time.Time
+	for i := range statuses {
+		s := &statuses[i]
+		if s.Context == nil || *s.Context != context || s.State == nil || *s.State != "success" || s.CreatedAt == nil {
+			continue
+		}
+		if first == nil || s.CreatedAt.Before(*first) {
+			first = s.CreatedAt
+		}
+	}
+	return first
+}
+
+func (a *AnalyticsReport) analyzePR(created time.Time, author string, merged *time.Time, events []github.IssueEvent, comments []github.IssueComment, statuses []github.RepoStatus) prAnalytics {
+	out := prAnalytics{
+		Author:  author,
+		Created: created,
+		Merged:  merged,
+	}
+	out.FirstReviewHours = hoursBetween(created, firstReviewTime(author, sets.NewString(a.IgnoreUsers...), events, comments))
+	lgtm := lastLabelEvent("lgtm", events)
+	if lgtm != nil && lgtm.Actor != nil && lgtm.Actor.Login != nil {
+		out.Reviewer = *lgtm.Actor.Login
+	}
+	if merged != nil {
+		if lgtm != nil {
+			out.LGTMToMergeHours = hoursBetween(*lgtm.CreatedAt, merged)
+		} else {
+			out.MergedWithoutLGTM = true
+		}
+		if queued := queueEntryTime(a.QueueContext, statuses); queued != nil {
+			out.InQueueHours = hoursBetween(*queued, merged)
+		}
+	}
+	return out
+}
+
+func computeDurationStats(values []float64) durationStats {
+	stats := durationStats{Count: len(values)}
+	if len(values) == 0 {
+		return stats
+	}
+	sort.Float64s(values)
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	stats.Mean = sum / float64(len(values))
+	stats.Median = values[len(values)/2]
+	stats.P90 = values[(len(values)*9)/10]
+	return stats
+}
+
+// summarize computes the aggregate statistics over all of the PRs. PRs count
+// as opened if they were created after `start` and as merged if they were
+// merged after `start`. `assigned` is the number of open PRs assigned to each
+// user.
+func summarize(start time.Time, prs []prAnalytics, assigned map[string]int) analyticsSummary {
+	summary := analyticsSummary{
+		PRs: prs,
+	}
+	people := map[string]*personStats{}
+	person := func(login string) *personStats {
+		if _, ok := people[login]; !ok {
+			people[login] = &personStats{Login: login}
+		}
+		return people[login]
+	}
+
+	firstReview := []float64{}
+	lgtmToMerge := []float64{}
+	inQueue := []float64{}
+	for _, pr := range prs {
+		if !pr.Created.Before(start) {
+			summary.Opened++
+			if pr.FirstReviewHours != nil {
+				firstReview = append(firstReview, *pr.FirstReviewHours)
+			}
+		}
+		if pr.Merged == nil || pr.Merged.Before(start) {
+			continue
+		}
+		summary.Merged++
+		person(pr.Author).Authored++
+		if len(pr.Reviewer) > 0 {
+			person(pr.Reviewer).Reviewed++
+		}
+		if pr.LGTMToMergeHours != nil {
+			lgtmToMerge = append(lgtmToMerge, *pr.LGTMToMergeHours)
+		}
+		if pr.InQueueHours != nil {
+			inQueue = append(inQueue, *pr.InQueueHours)
+		}
+	}
+	for login, count := range assigned {
+		person(login).Assigned = count
+	}
+	summary.FirstReview = computeDurationStats(firstReview)
+	summary.LGTMToMerge = computeDurationStats(lgtmToMerge)
+	summary.InQueue = computeDurationStats(inQueue)
+
+	logins := []string{}
+	for login := range people {
+		logins = append(logins, login)
+	}
+	sort.Strings(logins)
+	for _, login := range logins {
+		summary.People = append(summary.People, *people[login])
+	}
+	return summary
+}
+
+// countAssigned returns the number of open PRs assigned to each user.
+func countAssigned(issues []*github.Issue) map[string]int {
+	assigned := map[string]int{}
+	for _, issue := range issues {
+		if issue.PullRequestLinks == nil || issue.Assignee == nil || issue.Assignee.Login == nil {
+			continue
+		}
+		assigned[*issue.Assignee.Login]++
+	}
+	return assigned
+}
+
+// Report is the workhorse that actually makes the report.
+func (a *AnalyticsReport) Report(cfg *githubhelper.Config) error {
+	end := time.Now()
+	start := end.Add(-a.Window)
+	issues, err := cfg.ListAllIssues(&github.IssueListByRepoOptions{
+		State: "all",
+		Sort:  "created",
+		Since: start,
+	})
+	if err != nil {
+		return err
+	}
+
+	// PRs can be assigned long before the window and not updated since, so
+	// the open ones are listed separately.
+	open, err := cfg.ListAllIssues(&github.IssueListByRepoOptions{
+		State: "open",
+	})
+	if err != nil {
+		return err
+	}
+
+	prs := []prAnalytics{}
+	skipped := 0
+	for _, issue := range issues {
+		if issue.PullRequestLinks == nil || issue.CreatedAt == nil {
+			continue
+		}
+		// PRs opened before the window only count if they were merged in
+		// it, and a PR is closed when it is merged.
+		openedBefore := issue.CreatedAt.Before(start)
+		if openedBefore && (issue.ClosedAt == nil || issue.ClosedAt.Before(start)) {
+			continue
+		}
+		if issue.User == nil || issue.User.Login == nil {
+			glog.Errorf("Skipping PR %d without an author", *issue.Number)
+			continue
+		}
+		obj := cfg.ObjectFromIssue(issue)
+		pr, err := obj.GetPR()
+		if err != nil {
+			glog.Errorf("Skipping PR %d: unable to get PR: %v", *issue.Number, err)
+			skipped++
+			continue
+		}
+		if openedBefore && (pr.MergedAt == nil || pr.MergedAt.Before(start)) {
+			continue
+		}
+		events, err := obj.GetEvents()
+		if err != nil {
+			glog.Errorf("Skipping PR %d: unable to get events: %v", *issue.Number, err)
+			skipped++
+			continue
+		}
+		comments, err := obj.GetComments()
+		if err != nil {
+			glog.Errorf("Skipping PR %d: unable to get comments: %v", *issue.Number, err)
+			skipped++
+			continue
+		}
+		var statuses []github.RepoStatus
+		if pr.MergedAt != nil {
+			if statuses, err = obj.GetStatuses(); err != nil {
+				glog.Errorf("Unable to get statuses for PR %d: %v", *issue.Number, err)
+			}
+		}
+		data := a.analyzePR(*issue.CreatedAt, *issue.User.Login, pr.MergedAt, events, comments, statuses)
+		data.Number = *issue.Number
+		prs = append(prs, data)
+	}
+
+	summary := summarize(start, prs, countAssigned(open))
+	summary.Skipped = skipped
+	summary.Org = cfg.Org
+	summary.Project = cfg.Project
+	summary.Start = start
+	summary.End = end
+	if err := a.write(&summary); err != nil {
+		return err
+	}
+	return a.notify(&summary)
+}
+
+const analyticsSummaryTemplate = `{{.Opened}} PRs opened and {{.Merged}} merged in {{.Org}}/{{.Project}} between {{.Start.Format "2006-01-02"}} and {{.End.Format "2006-01-02"}}.{{if .Skipped}}
+{{.Skipped}} PRs were skipped because they could not be fetched.{{end}}
+Median hours to first review: {{printf "%.1f" .FirstReview.Median}}
+Median hours from LGTM to merge: {{printf "%.1f" .LGTMToMerge.Median}}
+Median hours in the submit queue: {{printf "%.1f" .InQueue.Median}}
+`
+
+// notify sends a short summary, but only if --notify-config says where to.
+func (a *AnalyticsReport) notify(summary *analyticsSummary) error {
+	if dispatcher == nil {
+		return nil
+	}
+	body, err := dispatcher.Render("analytics-summary", analyticsSummaryTemplate, summary)
+	if err != nil {
+		return err
+	}
+	return dispatcher.Notify(&Message{
+		Subject: fmt.Sprintf("%s/%s PR analytics", summary.Org, summary.Project),
+		Body:    body,
+	})
+}
+
+func (a *AnalyticsReport) write(summary *analyticsSummary) error {
+	writers := []struct {
+		file  string
+		write func(io.Writer, *analyticsSummary) error
+	}{
+		{"analytics.csv", writeAnalyticsCSV},
+		{"analytics.json", writeAnalyticsJSON},
+		{"analytics.html", writeAnalyticsHTML},
+	}
+	for _, w := range writers {
+		path := filepath.Join(a.OutputDir, w.file)
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = w.write(f, summary)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("unable to write %s: %v", path, err)
+		}
+		glog.Infof("Wrote %s", path)
+	}
+	return nil
+}
+
+func formatHours(h *float64) string {
+	if h == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*h, 'f', 2, 64)
+}
+
+func writeAnalyticsCSV(w io.Writer, summary *analyticsSummary) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"number", "author", "reviewer", "created", "merged", "first_review_hours", "lgtm_to_merge_hours", "in_queue_hours"})
+	for _, pr := range summary.PRs {
+		merged := ""
+		if pr.Merged != nil {
+			merged = pr.Merged.Format(time.RFC3339)
+		}
+		out.Write([]string{
+			strconv.Itoa(pr.Number),
+			pr.Author,
+			pr.Reviewer,
+			pr.Created.Format(time.RFC3339),
+			merged,
+			formatHours(pr.FirstReviewHours),
+			formatHours(pr.LGTMToMergeHours),
+			formatHours(pr.InQueueHours),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func writeAnalyticsJSON(w io.Writer, summary *analyticsSummary) error {
+	b, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+var analyticsHTML = template.Must(template.New("analytics").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Org}}/{{.Project}} PR analytics</title></head>
+<body>
+<h1>{{.Org}}/{{.Project}} PR analytics</h1>
+<p>{{.Start.Format "2006-01-02"}} to {{.End.Format "2006-01-02"}}: {{.Opened}} PRs opened, {{.Merged}} merged.{{if .Skipped}} {{.Skipped}} PRs were skipped because they could not be fetched.{{end}}</p>
+<table border="1">
+<tr><th>Hours</th><th>Count</th><th>Mean</th><th>Median</th><th>90th percentile</th></tr>
+<tr><td>Time to first review</td><td>{{.FirstReview.Count}}</td><td>{{printf "%.1f" .FirstReview.Mean}}</td><td>{{printf "%.1f" .FirstReview.Median}}</td><td>{{printf "%.1f" .FirstReview.P90}}</td></tr>
+<tr><td>LGTM to merge</td><td>{{.LGTMToMerge.Count}}</td><td>{{printf "%.1f" .LGTMToMerge.Mean}}</td><td>{{printf "%.1f" .LGTMToMerge.Median}}</td><td>{{printf "%.1f" .LGTMToMerge.P90}}</td></tr>
+<tr><td>Time in submit queue</td><td>{{.InQueue.Count}}</td><td>{{printf "%.1f" .InQueue.Mean}}</td><td>{{printf "%.1f" .InQueue.Median}}</td><td>{{printf "%.1f" .InQueue.P90}}</td></tr>
+</table>
+<h2>People</h2>
+<table border="1">
+<tr><th>User</th><th>PRs merged</th><th>PRs reviewed</th><th>Open PRs assigned</th></tr>
+{{range .People}}<tr><td>{{.Login}}</td><td>{{.Authored}}</td><td>{{.Reviewed}}</td><td>{{.Assigned}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func writeAnalyticsHTML(w io.Writer, summary *analyticsSummary) error {
+	return analyticsHTML.Execute(w, summary)
+}
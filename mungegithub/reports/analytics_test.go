@@ -0,0 +1,158 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reports
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	github_test "k8s.io/contrib/mungegithub/github/testing"
+
+	"github.com/google/go-github/github"
+)
+
+func stringPtr(val string) *string { return &val }
+
+func hour(h int) time.Time { return time.Unix(int64(h)*3600, 0) }
+
+func timePtr(t time.Time) *time.Time { return &t }
+
+func TestAnalyzePR(t *testing.T) {
+	a := &AnalyticsReport{
+		QueueContext: "Submit Queue",
+		IgnoreUsers:  []string{"bot"},
+	}
+	events := github_test.Events([]github_test.LabelTime{
+		{User: "author", Label: "kind/bug", Time: 3600},
+		{User: "bot", Label: "size/M", Time: 2 * 3600},
+		{User: "reviewer", Label: "lgtm", Time: 10 * 3600},
+	})
+	comments := []github.IssueComment{
+		{User: &github.User{Login: stringPtr("bot")}, CreatedAt: timePtr(hour(1))},
+		{User: &github.User{Login: stringPtr("someone")}, CreatedAt: timePtr(hour(5))},
+	}
+	statuses := []github.RepoStatus{
+		{Context: stringPtr("Submit Queue"), State: stringPtr("pending"), CreatedAt: timePtr(hour(11))},
+		{Context: stringPtr("Submit Queue"), State: stringPtr("success"), CreatedAt: timePtr(hour(14))},
+		{Context: stringPtr("Submit Queue"), State: stringPtr("success"), CreatedAt: timePtr(hour(12))},
+	}
+	merged := hour(16)
+
+	pr := a.analyzePR(hour(0), "author", &merged, events, comments, statuses)
+	if pr.Reviewer != "reviewer" {
+		t.Errorf("expected reviewer 'reviewer', got %q", pr.Reviewer)
+	}
+	check := func(name string, got *float64, expected float64) {
+		if got == nil || *got != expected {
+			t.Errorf("expected %s %v, got %v", name, expected, got)
+		}
+	}
+	check("first review", pr.FirstReviewHours, 5)
+	check("lgtm to merge", pr.LGTMToMergeHours, 6)
+	check("in queue", pr.InQueueHours, 4)
+
+	open := a.analyzePR(hour(0), "author", nil, nil, nil, nil)
+	if open.FirstReviewHours != nil || open.LGTMToMergeHours != nil || open.InQueueHours != nil {
+		t.Errorf("expected no durations for an unreviewed open PR, got %#v", open)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	h := func(v float64) *float64 { return &v }
+	start := hour(5)
+	merged := hour(10)
+	mergedBefore := hour(4)
+	prs := []prAnalytics{
+		{Number: 1, Author: "alice", Reviewer: "bob", Created: hour(6), Merged: &merged, FirstReviewHours: h(1), LGTMToMergeHours: h(2)},
+		{Number: 2, Author: "alice", Reviewer: "carol", Created: hour(6), Merged: &merged, FirstReviewHours: h(3), LGTMToMergeHours: h(4)},
+		{Number: 3, Author: "bob", Created: hour(7), FirstReviewHours: h(5)},
+		// Opened before the window and merged in it
+		{Number: 4, Author: "dave", Created: hour(1), Merged: &merged, FirstReviewHours: h(100), LGTMToMergeHours: h(3)},
+		// Opened and merged before the window
+		{Number: 5, Author: "erin", Created: hour(1), Merged: &mergedBefore, FirstReviewHours: h(1), LGTMToMergeHours: h(1)},
+	}
+	summary := summarize(start, prs, map[string]int{"carol": 2})
+	if summary.Opened != 3 || summary.Merged != 3 {
+		t.Errorf("expected 3 opened and 3 merged, got %d and %d", summary.Opened, summary.Merged)
+	}
+	if summary.FirstReview.Count != 3 || summary.FirstReview.Mean != 3 || summary.FirstReview.Median != 3 {
+		t.Errorf("unexpected first review stats: %#v", summary.FirstReview)
+	}
+	if summary.LGTMToMerge.Count != 3 || summary.LGTMToMerge.Mean != 3 {
+		t.Errorf("unexpected lgtm to merge stats: %#v", summary.LGTMToMerge)
+	}
+	expected := []personStats{
+		{Login: "alice", Authored: 2},
+		{Login: "bob", Reviewed: 1},
+		{Login: "carol", Reviewed: 1, Assigned: 2},
+		{Login: "dave", Authored: 1},
+	}
+	if len(summary.People) != len(expected) {
+		t.Fatalf("expected people %v, got %v", expected, summary.People)
+	}
+	for i := range expected {
+		if summary.People[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], summary.People[i])
+		}
+	}
+
+	for _, write := range []func(*bytes.Buffer, *analyticsSummary) error{
+		func(b *bytes.Buffer, s *analyticsSummary) error { return writeAnalyticsCSV(b, s) },
+		func(b *bytes.Buffer, s *analyticsSummary) error { return writeAnalyticsJSON(b, s) },
+		func(b *bytes.Buffer, s *analyticsSummary) error { return writeAnalyticsHTML(b, s) },
+	} {
+		buf := &bytes.Buffer{}
+		if err := write(buf, &summary); err != nil {
+			t.Errorf("unexpected error writing report: %v", err)
+		}
+		if !strings.Contains(buf.String(), "alice") {
+			t.Errorf("expected report to mention alice: %s", buf.String())
+		}
+	}
+
+	summary.Skipped = 2
+	buf := &bytes.Buffer{}
+	if err := writeAnalyticsHTML(buf, &summary); err != nil {
+		t.Errorf("unexpected error writing report: %v", err)
+	}
+	if !strings.Contains(buf.String(), "2 PRs were skipped") {
+		t.Errorf("expected report to mention the skipped PRs: %s", buf.String())
+	}
+}
+
+func TestCountAssigned(t *testing.T) {
+	pr := func(assignee string) *github.Issue {
+		issue := &github.Issue{PullRequestLinks: &github.PullRequestLinks{}}
+		if len(assignee) > 0 {
+			issue.Assignee = &github.User{Login: stringPtr(assignee)}
+		}
+		return issue
+	}
+	issues := []*github.Issue{
+		pr("alice"),
+		pr("alice"),
+		pr("bob"),
+		pr(""),
+		{Assignee: &github.User{Login: stringPtr("bob")}},
+	}
+	assigned := countAssigned(issues)
+	if len(assigned) != 2 || assigned["alice"] != 2 || assigned["bob"] != 1 {
+		t.Errorf("expected alice 2 and bob 1, got %v", assigned)
+	}
+}
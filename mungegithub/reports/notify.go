@@ -0,0 +1,408 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reports
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"k8s.io/kubernetes/pkg/util/yaml"
+
+	"github.com/golang/glog"
+)
+
+// Message is a notification produced by a report
+type Message struct {
+	From    string
+	To      []string
+	Cc      []string
+	Subject string
+	Body    string
+}
+
+// RFC822 formats the message as a simple email
+func (m *Message) RFC822() []byte {
+	b := &bytes.Buffer{}
+	if m.From != "" {
+		fmt.Fprintf(b, "From: %v\n", m.From)
+	}
+	if len(m.Cc) > 0 {
+		fmt.Fprintf(b, "Cc: %v\n", strings.Join(m.Cc, ","))
+	}
+	fmt.Fprintf(b, "To: %v\n", strings.Join(m.To, ","))
+	fmt.Fprintf(b, "Subject: %v\n", m.Subject)
+	fmt.Fprintf(b, "\n%s", m.Body)
+	return b.Bytes()
+}
+
+// Notifier delivers messages somewhere
+type Notifier interface {
+	Notify(msg *Message) error
+}
+
+// fileNotifier appends every message to a file
+type fileNotifier struct {
+	Path string
+}
+
+func (f *fileNotifier) Notify(msg *Message) error {
+	fp, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	_, err = fp.Write(msg.RFC822())
+	return err
+}
+
+// commandNotifier pipes the message, as an email, into a shell command
+type commandNotifier struct {
+	Command string
+}
+
+func (c *commandNotifier) Notify(msg *Message) error {
+	args := strings.Split(c.Command, " ")
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(msg.RFC822())
+	return cmd.Run()
+}
+
+// smtpNotifier sends the message as an email
+type smtpNotifier struct {
+	Server   string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *smtpNotifier) Notify(msg *Message) error {
+	m := *msg
+	if m.From == "" {
+		m.From = s.From
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := strings.Split(s.Server, ":")[0]
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	to := append(append([]string{}, m.To...), m.Cc...)
+	if len(to) == 0 {
+		return fmt.Errorf("no recipients for %q", m.Subject)
+	}
+	return smtp.SendMail(s.Server, auth, m.From, to, m.RFC822())
+}
+
+// webhookNotifier posts Slack compatible JSON to a URL. Recipients which
+// look like slack channels or users (#channel, @user) each get their own
+// post, any other recipients share a post to the webhook's default channel.
+type webhookNotifier struct {
+	URL string
+}
+
+// webhookClient doesn't let a hung webhook block the report which notifies
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+type webhookPayload struct {
+	Channel string `json:"channel,omitempty"`
+	Text    string `json:"text"`
+}
+
+func (w *webhookNotifier) post(payload webhookPayload) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(w.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("webhook returned %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+func (w *webhookNotifier) Notify(msg *Message) error {
+	text := fmt.Sprintf("*%s*\n%s", msg.Subject, msg.Body)
+	channels := []string{}
+	other := false
+	for _, r := range append(append([]string{}, msg.To...), msg.Cc...) {
+		if strings.HasPrefix(r, "#") || strings.HasPrefix(r, "@") {
+			channels = append(channels, r)
+		} else {
+			other = true
+		}
+	}
+	for _, c := range channels {
+		if err := w.post(webhookPayload{Channel: c, Text: text}); err != nil {
+			return err
+		}
+	}
+	if other || len(channels) == 0 {
+		return w.post(webhookPayload{Text: text})
+	}
+	return nil
+}
+
+// previewNotifier logs what would have been sent instead of sending it
+type previewNotifier struct {
+	name string
+}
+
+func (p *previewNotifier) Notify(msg *Message) error {
+	glog.Infof("[dry-run] would notify via %q:\n%s", p.name, msg.RFC822())
+	return nil
+}
+
+// SinkConfig describes one place notifications can be sent
+type SinkConfig struct {
+	Name string `json:"name"`
+	// Type is one of smtp, webhook, file or command
+	Type string `json:"type"`
+
+	// smtp
+	Server       string `json:"server,omitempty"`
+	Username     string `json:"username,omitempty"`
+	PasswordFile string `json:"passwordFile,omitempty"`
+	From         string `json:"from,omitempty"`
+	// webhook
+	URL string `json:"url,omitempty"`
+	// file
+	Path string `json:"path,omitempty"`
+	// command
+	Command string `json:"command,omitempty"`
+}
+
+// Route sends messages to recipients matching Match (a shell style pattern
+// like "*@google.com" or "#*") to the named Sinks.
+type Route struct {
+	Match string   `json:"match"`
+	Sinks []string `json:"sinks"`
+}
+
+// NotifyConfig is the format of the file passed in --notify-config
+type NotifyConfig struct {
+	Sinks  []SinkConfig `json:"sinks"`
+	Routes []Route      `json:"routes,omitempty"`
+	// DefaultSinks get messages for recipients which match no route, and
+	// messages with no recipients at all.
+	DefaultSinks []string `json:"defaultSinks,omitempty"`
+	// Templates maps a template name, like "shame-group", to a file which
+	// replaces the built in template of that name.
+	Templates map[string]string `json:"templates,omitempty"`
+}
+
+// Dispatcher routes messages from reports to notifiers
+type Dispatcher struct {
+	sinks        map[string]Notifier
+	routes       []Route
+	defaultSinks []string
+	templates    map[string]string
+}
+
+func newNotifier(sink *SinkConfig) (Notifier, error) {
+	switch sink.Type {
+	case "smtp":
+		password := ""
+		if sink.PasswordFile != "" {
+			b, err := ioutil.ReadFile(sink.PasswordFile)
+			if err != nil {
+				return nil, err
+			}
+			password = strings.TrimSpace(string(b))
+		}
+		return &smtpNotifier{Server: sink.Server, Username: sink.Username, Password: password, From: sink.From}, nil
+	case "webhook":
+		return &webhookNotifier{URL: sink.URL}, nil
+	case "file":
+		return &fileNotifier{Path: sink.Path}, nil
+	case "command":
+		return &commandNotifier{Command: sink.Command}, nil
+	}
+	return nil, fmt.Errorf("sink %q has unknown type %q", sink.Name, sink.Type)
+}
+
+// NewDispatcher builds a dispatcher from a config. If dryRun is true every
+// sink only logs what it would send.
+func NewDispatcher(config *NotifyConfig, dryRun bool) (*Dispatcher, error) {
+	d := &Dispatcher{
+		sinks:        map[string]Notifier{},
+		routes:       config.Routes,
+		defaultSinks: config.DefaultSinks,
+		templates:    config.Templates,
+	}
+	for i := range config.Sinks {
+		sink := &config.Sinks[i]
+		if _, ok := d.sinks[sink.Name]; ok {
+			return nil, fmt.Errorf("sink %q is defined twice", sink.Name)
+		}
+		n, err := newNotifier(sink)
+		if err != nil {
+			return nil, err
+		}
+		if dryRun {
+			n = &previewNotifier{name: sink.Name}
+		}
+		d.sinks[sink.Name] = n
+	}
+	names := append([]string{}, config.DefaultSinks...)
+	for _, r := range config.Routes {
+		if _, err := path.Match(r.Match, ""); err != nil {
+			return nil, fmt.Errorf("invalid route %q: %v", r.Match, err)
+		}
+		names = append(names, r.Sinks...)
+	}
+	for _, name := range names {
+		if _, ok := d.sinks[name]; !ok {
+			return nil, fmt.Errorf("unknown sink %q", name)
+		}
+	}
+	return d, nil
+}
+
+// LoadDispatcher reads a NotifyConfig from `file` and builds a dispatcher
+func LoadDispatcher(file string, dryRun bool) (*Dispatcher, error) {
+	fp, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	config := &NotifyConfig{}
+	if err := yaml.NewYAMLToJSONDecoder(fp).Decode(config); err != nil {
+		return nil, err
+	}
+	return NewDispatcher(config, dryRun)
+}
+
+// singleSinkDispatcher sends everything to `n`
+func singleSinkDispatcher(name string, n Notifier, dryRun bool) *Dispatcher {
+	if dryRun {
+		n = &previewNotifier{name: name}
+	}
+	return &Dispatcher{
+		sinks:        map[string]Notifier{name: n},
+		defaultSinks: []string{name},
+	}
+}
+
+func (d *Dispatcher) sinksFor(recipient string) []string {
+	for _, r := range d.routes {
+		if ok, _ := path.Match(r.Match, recipient); ok {
+			return r.Sinks
+		}
+	}
+	return d.defaultSinks
+}
+
+// Notify splits the recipients of the message by route and sends each sink a
+// copy of the message addressed to only the recipients routed to it. It is an
+// error if a recipient matches no route and there are no default sinks.
+func (d *Dispatcher) Notify(msg *Message) error {
+	type routed struct {
+		to []string
+		cc []string
+	}
+	bySink := map[string]*routed{}
+	order := []string{}
+	unrouted := []string{}
+	add := func(recipient string, cc bool) {
+		sinks := d.sinksFor(recipient)
+		if len(sinks) == 0 {
+			unrouted = append(unrouted, recipient)
+		}
+		for _, sink := range sinks {
+			r, ok := bySink[sink]
+			if !ok {
+				r = &routed{}
+				bySink[sink] = r
+				order = append(order, sink)
+			}
+			if cc {
+				r.cc = append(r.cc, recipient)
+			} else {
+				r.to = append(r.to, recipient)
+			}
+		}
+	}
+	for _, to := range msg.To {
+		add(to, false)
+	}
+	for _, cc := range msg.Cc {
+		add(cc, true)
+	}
+	if len(msg.To) == 0 && len(msg.Cc) == 0 {
+		for _, sink := range d.defaultSinks {
+			bySink[sink] = &routed{}
+			order = append(order, sink)
+		}
+	}
+
+	errs := []string{}
+	if len(unrouted) > 0 {
+		glog.Errorf("Unable to notify %v about %q: no route matches and there are no default sinks", unrouted, msg.Subject)
+		errs = append(errs, fmt.Sprintf("no sink for %v", unrouted))
+	}
+	for _, sink := range order {
+		r := bySink[sink]
+		m := *msg
+		m.To = r.to
+		m.Cc = r.cc
+		if err := d.sinks[sink].Notify(&m); err != nil {
+			glog.Errorf("Unable to notify %v via %q: %v", append(m.To, m.Cc...), sink, err)
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("notification failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Render executes the template `name` with `data`. The template comes from
+// the file configured for `name` in the notify config, or `builtin` if there
+// is none.
+func (d *Dispatcher) Render(name, builtin string, data interface{}) (string, error) {
+	text := builtin
+	if file, ok := d.templates[name]; ok {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		text = string(b)
+	}
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
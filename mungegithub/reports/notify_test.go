@@ -0,0 +1,183 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reports
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type recordingNotifier struct {
+	messages []Message
+}
+
+func (r *recordingNotifier) Notify(msg *Message) error {
+	r.messages = append(r.messages, *msg)
+	return nil
+}
+
+func TestDispatcherRouting(t *testing.T) {
+	mail := &recordingNotifier{}
+	chat := &recordingNotifier{}
+	archive := &recordingNotifier{}
+	d := &Dispatcher{
+		sinks: map[string]Notifier{"mail": mail, "chat": chat, "archive": archive},
+		routes: []Route{
+			{Match: "*@google.com", Sinks: []string{"mail", "archive"}},
+			{Match: "#*", Sinks: []string{"chat"}},
+		},
+		defaultSinks: []string{"archive"},
+	}
+
+	err := d.Notify(&Message{
+		To:      []string{"a@google.com", "#flakes", "b@example.com"},
+		Cc:      []string{"c@google.com"},
+		Subject: "subject",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mail.messages) != 1 || !reflect.DeepEqual(mail.messages[0].To, []string{"a@google.com"}) || !reflect.DeepEqual(mail.messages[0].Cc, []string{"c@google.com"}) {
+		t.Errorf("unexpected mail messages: %#v", mail.messages)
+	}
+	if len(chat.messages) != 1 || !reflect.DeepEqual(chat.messages[0].To, []string{"#flakes"}) || chat.messages[0].Cc != nil {
+		t.Errorf("unexpected chat messages: %#v", chat.messages)
+	}
+	if len(archive.messages) != 1 || !reflect.DeepEqual(archive.messages[0].To, []string{"a@google.com", "b@example.com"}) {
+		t.Errorf("unexpected archive messages: %#v", archive.messages)
+	}
+
+	// No recipients goes to the default sinks
+	if err := d.Notify(&Message{Subject: "nobody"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(archive.messages) != 2 || archive.messages[1].Subject != "nobody" {
+		t.Errorf("expected message with no recipients to be archived: %#v", archive.messages)
+	}
+
+	// Without default sinks recipients matching no route are an error, but
+	// the others still get the message
+	d.defaultSinks = nil
+	if err := d.Notify(&Message{To: []string{"#flakes", "b@example.com"}, Subject: "unrouted"}); err == nil || !strings.Contains(err.Error(), "b@example.com") {
+		t.Errorf("expected an error for b@example.com, got %v", err)
+	}
+	if len(chat.messages) != 2 || chat.messages[1].Subject != "unrouted" {
+		t.Errorf("expected #flakes to be notified: %#v", chat.messages)
+	}
+}
+
+func TestNewDispatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out.txt")
+
+	if _, err := NewDispatcher(&NotifyConfig{DefaultSinks: []string{"missing"}}, false); err == nil {
+		t.Errorf("expected error for unknown sink")
+	}
+	if _, err := NewDispatcher(&NotifyConfig{Sinks: []SinkConfig{{Name: "x", Type: "pigeon"}}}, false); err == nil {
+		t.Errorf("expected error for unknown sink type")
+	}
+
+	config := &NotifyConfig{
+		Sinks:        []SinkConfig{{Name: "file", Type: "file", Path: out}},
+		DefaultSinks: []string{"file"},
+	}
+	dry, err := NewDispatcher(config, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := dry.Notify(&Message{To: []string{"a@google.com"}, Subject: "dry"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("dry run should not have written %s", out)
+	}
+
+	d, err := NewDispatcher(config, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.Notify(&Message{From: "bot@google.com", To: []string{"a@google.com"}, Subject: "hello", Body: "body\n"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	expected := "From: bot@google.com\nTo: a@google.com\nSubject: hello\n\nbody\n"
+	if string(b) != expected {
+		t.Errorf("expected file contents %q, got %q", expected, string(b))
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	payloads := []webhookPayload{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("unable to decode payload: %v", err)
+		}
+		payloads = append(payloads, p)
+	}))
+	defer server.Close()
+
+	w := &webhookNotifier{URL: server.URL}
+	if err := w.Notify(&Message{To: []string{"#flakes", "@bob", "a@google.com"}, Subject: "s", Body: "b"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []webhookPayload{
+		{Channel: "#flakes", Text: "*s*\nb"},
+		{Channel: "@bob", Text: "*s*\nb"},
+		{Text: "*s*\nb"},
+	}
+	if !reflect.DeepEqual(payloads, expected) {
+		t.Errorf("expected %v, got %v", expected, payloads)
+	}
+}
+
+func TestRenderTemplateOverride(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "tmpl")
+	if err := ioutil.WriteFile(file, []byte("custom {{.User}}"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	d := &Dispatcher{templates: map[string]string{"override": file}}
+	data := shameTemplateData{User: "bob"}
+	out, err := d.Render("builtin", "builtin {{.User}}", data)
+	if err != nil || out != "builtin bob" {
+		t.Errorf("expected builtin template, got %q %v", out, err)
+	}
+	out, err = d.Render("override", "builtin {{.User}}", data)
+	if err != nil || !strings.HasPrefix(out, "custom bob") {
+		t.Errorf("expected custom template, got %q %v", out, err)
+	}
+}
@@ -18,6 +18,8 @@ package reports
 
 import (
 	"fmt"
+	"net/http"
+	"time"
 
 	"k8s.io/contrib/mungegithub/github"
 
@@ -36,6 +38,36 @@ type Report interface {
 var reportMap = map[string]Report{}
 var reports = []Report{}
 
+var (
+	notifyConfigFile string
+	notifyDryRun     bool
+	// dispatcher is loaded from notifyConfigFile by RunReports. It is nil
+	// if no config was given.
+	dispatcher *Dispatcher
+
+	scheduleConfigFile string
+	reportAddress      string
+	// scheduler is nil unless InitializeScheduler was given a config
+	scheduler *Scheduler
+)
+
+// AddFlags adds the flags shared by all reports to the cobra `cmd`
+func AddFlags(cmd *cobra.Command, config *github.Config) {
+	cmd.Flags().StringVar(&notifyConfigFile, "notify-config", "", "Path to a yaml file describing where reports send notifications. If empty each report uses its own default")
+	cmd.Flags().BoolVar(&notifyDryRun, "notify-dry-run", false, "If true, log the notifications reports would send instead of sending them")
+	cmd.Flags().StringVar(&scheduleConfigFile, "report-schedule-config", "", "Path to a yaml file listing reports to run on cron style schedules while the mungers run")
+	cmd.Flags().StringVar(&reportAddress, "report-address", "", "If set, serve the status of scheduled reports at /reports on this address. Otherwise /reports is only served if another munger (like the submit-queue) runs a web server")
+}
+
+// notifier returns the dispatcher reports should use to send messages. If no
+// --notify-config was given every message goes to `fallback`.
+func notifier(name string, fallback Notifier) *Dispatcher {
+	if dispatcher != nil {
+		return dispatcher
+	}
+	return singleSinkDispatcher(name, fallback, notifyDryRun)
+}
+
 // GetAllReports returns a slice of all registered reports. This list is
 // completely independant of the reports selected at runtime in --pr-reports.
 // This is all possible reports.
@@ -71,8 +103,24 @@ func RegisterReportOrDie(report Report) {
 	}
 }
 
+func loadDispatcher() error {
+	if len(notifyConfigFile) == 0 {
+		return nil
+	}
+	d, err := LoadDispatcher(notifyConfigFile, notifyDryRun)
+	if err != nil {
+		return fmt.Errorf("unable to load %s: %v", notifyConfigFile, err)
+	}
+	dispatcher = d
+	return nil
+}
+
 // RunReports runs the specified reports.
 func RunReports(cfg *github.Config, runReports ...string) error {
+	if err := loadDispatcher(); err != nil {
+		return err
+	}
+	cfg = cfg.WithPriority(github.PriorityReport)
 	for _, name := range runReports {
 		report, ok := reportMap[name]
 		if !ok {
@@ -84,3 +132,37 @@ func RunReports(cfg *github.Config, runReports ...string) error {
 	}
 	return nil
 }
+
+// InitializeScheduler loads the --report-schedule-config, if any, and serves
+// the status of scheduled reports at /reports.
+func InitializeScheduler(cfg *github.Config) error {
+	if len(scheduleConfigFile) == 0 {
+		return nil
+	}
+	if err := loadDispatcher(); err != nil {
+		return err
+	}
+	s, err := loadScheduler(scheduleConfigFile, time.Now())
+	if err != nil {
+		return fmt.Errorf("unable to load %s: %v", scheduleConfigFile, err)
+	}
+	scheduler = s
+
+	http.HandleFunc("/reports", func(w http.ResponseWriter, r *http.Request) {
+		s.serveStatus(w, r)
+	})
+	if len(reportAddress) > 0 {
+		go http.ListenAndServe(reportAddress, nil)
+	}
+	return nil
+}
+
+// RunScheduledReports starts all scheduled reports which are due. It is called
+// between munge loops, so reports start at most --period late, but it doesn't
+// wait for them to finish.
+func RunScheduledReports(cfg *github.Config) {
+	if scheduler == nil {
+		return
+	}
+	scheduler.RunDue(cfg.WithPriority(github.PriorityReport), time.Now())
+}
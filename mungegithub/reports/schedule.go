@@ -0,0 +1,355 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reports
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/contrib/mungegithub/github"
+	"k8s.io/kubernetes/pkg/util/yaml"
+
+	"github.com/golang/glog"
+)
+
+// schedule computes when a scheduled report should next run
+type schedule interface {
+	// Next returns the first time strictly after `t` the report should run
+	Next(t time.Time) time.Time
+}
+
+// everySchedule runs a report at a fixed interval, like "@every 6h"
+type everySchedule struct {
+	period time.Duration
+}
+
+func (e *everySchedule) Next(t time.Time) time.Time {
+	return t.Add(e.period)
+}
+
+// cronSchedule is a standard 5 field cron line: minute hour day-of-month
+// month day-of-week. Each field is a set of the values it allows.
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	// If both day fields are restricted a day matching either one runs,
+	// like cron(8)
+	domStar, dowStar bool
+}
+
+var cronShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseSchedule understands 5 field cron lines, the @hourly, @daily,
+// @weekly and @monthly shortcuts, and "@every <duration>".
+func parseSchedule(spec string) (schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		period, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", spec, err)
+		}
+		if period < time.Minute {
+			return nil, fmt.Errorf("invalid schedule %q: period must be at least a minute", spec)
+		}
+		return &everySchedule{period: period}, nil
+	}
+	if line, ok := cronShortcuts[spec]; ok {
+		spec = line
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, found %d", spec, len(fields))
+	}
+	c := &cronSchedule{
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute in %q: %v", spec, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour in %q: %v", spec, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month in %q: %v", spec, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month in %q: %v", spec, err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week in %q: %v", spec, err)
+	}
+	// Both 0 and 7 are Sunday
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	return c, nil
+}
+
+// parseCronField parses a comma separated list of `*`, `N` or `N-M`, each
+// optionally followed by `/step`.
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	out := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("bad step in %q", part)
+			}
+			step = s
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("bad value %q", part)
+				}
+			} else if step > 1 {
+				// "5/15" means every 15 starting at 5
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			out[v] = true
+		}
+	}
+	return out, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom[t.Day()]
+	dow := c.dow[int(t.Weekday())]
+	switch {
+	case c.domStar && c.dowStar:
+		return true
+	case c.domStar:
+		return dow
+	case c.dowStar:
+		return dom
+	}
+	return dom || dow
+}
+
+func (c *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Give up on schedules which can never match, like Feb 30th
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !c.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			// Truncate works in UTC, which is wrong for zones with a
+			// half hour offset.
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// ScheduleEntry runs the report named Report on the cron style Schedule
+type ScheduleEntry struct {
+	Report   string `json:"report"`
+	Schedule string `json:"schedule"`
+}
+
+// ScheduleConfig is the format of the file passed in --report-schedule-config
+type ScheduleConfig struct {
+	Schedules []ScheduleEntry `json:"schedules"`
+}
+
+// ReportStatus is what we know about a scheduled report's runs
+type ReportStatus struct {
+	Report       string
+	Schedule     string
+	NextRun      time.Time
+	Running      bool
+	LastStart    *time.Time `json:",omitempty"`
+	LastDuration string     `json:",omitempty"`
+	LastError    string     `json:",omitempty"`
+	Runs         int
+	Failures     int
+}
+
+type scheduledReport struct {
+	report   Report
+	schedule schedule
+	status   ReportStatus
+}
+
+// Scheduler runs reports on their schedules alongside the munger loop, so
+// they share its github client, rate limits and cache.
+type Scheduler struct {
+	sync.Mutex
+	reports []*scheduledReport
+	// running counts reports which have been started but not finished
+	running sync.WaitGroup
+}
+
+func newScheduler(config *ScheduleConfig, now time.Time) (*Scheduler, error) {
+	s := &Scheduler{}
+	for _, entry := range config.Schedules {
+		report, ok := reportMap[entry.Report]
+		if !ok {
+			return nil, fmt.Errorf("%v: not a valid report", entry.Report)
+		}
+		sched, err := parseSchedule(entry.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		next := sched.Next(now)
+		if next.IsZero() {
+			return nil, fmt.Errorf("schedule %q for %v never runs", entry.Schedule, entry.Report)
+		}
+		s.reports = append(s.reports, &scheduledReport{
+			report:   report,
+			schedule: sched,
+			status: ReportStatus{
+				Report:   entry.Report,
+				Schedule: entry.Schedule,
+				NextRun:  next,
+			},
+		})
+	}
+	return s, nil
+}
+
+func loadScheduler(file string, now time.Time) (*Scheduler, error) {
+	fp, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	config := &ScheduleConfig{}
+	if err := yaml.NewYAMLToJSONDecoder(fp).Decode(config); err != nil {
+		return nil, err
+	}
+	return newScheduler(config, now)
+}
+
+// runReport runs one report, turning a panic into an error so a broken
+// report can't take down the mungers.
+func runReport(report Report, cfg *github.Config) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return report.Report(cfg)
+}
+
+// RunDue starts every report whose next run is at or before `now` in its own
+// goroutine. A report which is still running from a previous run is skipped.
+func (s *Scheduler) RunDue(cfg *github.Config, now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	for _, sr := range s.reports {
+		if sr.status.NextRun.After(now) {
+			continue
+		}
+		if sr.status.Running {
+			glog.Warningf("Scheduled report %v is still running, skipping this run", sr.status.Report)
+		} else {
+			sr.status.Running = true
+			s.running.Add(1)
+			go s.run(sr, cfg)
+		}
+		// If we fell behind skip the missed runs rather than running
+		// back to back to catch up.
+		sr.status.NextRun = sr.schedule.Next(now)
+	}
+}
+
+// run runs one scheduled report and records how it went
+func (s *Scheduler) run(sr *scheduledReport, cfg *github.Config) {
+	defer s.running.Done()
+
+	glog.Infof("Running scheduled report %v", sr.status.Report)
+	start := time.Now()
+	err := runReport(sr.report, cfg)
+	end := time.Now()
+
+	s.Lock()
+	defer s.Unlock()
+	sr.status.Running = false
+	sr.status.LastStart = &start
+	sr.status.LastDuration = end.Sub(start).String()
+	sr.status.Runs++
+	sr.status.LastError = ""
+	if err != nil {
+		glog.Errorf("Scheduled report %v failed: %v", sr.status.Report, err)
+		sr.status.LastError = err.Error()
+		sr.status.Failures++
+	}
+}
+
+// Status returns the status of all scheduled reports
+func (s *Scheduler) Status() []ReportStatus {
+	s.Lock()
+	defer s.Unlock()
+	out := []ReportStatus{}
+	for _, sr := range s.reports {
+		out = append(out, sr.status)
+	}
+	return out
+}
+
+func (s *Scheduler) serveStatus(res http.ResponseWriter, req *http.Request) {
+	data, err := json.Marshal(s.Status())
+	if err != nil {
+		glog.Errorf("Unable to Marshal report status: %v", err)
+		res.Header().Set("Content-type", "text/plain")
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write(data)
+}
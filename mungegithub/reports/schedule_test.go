@@ -0,0 +1,167 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reports
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/mungegithub/github"
+
+	"github.com/spf13/cobra"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// Thursday
+	now := time.Date(2015, time.December, 10, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2015, time.December, 10, 10, 31, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2015, time.December, 10, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2015, time.December, 11, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2015, time.December, 10, 10, 45, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2015, time.December, 11, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1", time.Date(2015, time.December, 14, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2015, time.December, 13, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2016, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * 4", time.Date(2015, time.December, 15, 0, 0, 0, 0, time.UTC)},
+		{"@every 2h", now.Add(2 * time.Hour)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		sched, err := parseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.spec, err)
+			continue
+		}
+		if next := sched.Next(now); !next.Equal(test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.spec, test.expected, next)
+		}
+	}
+
+	// Hours start on the half hour in UTC
+	ist := time.FixedZone("IST", 5*3600+1800)
+	now = time.Date(2015, time.December, 10, 10, 30, 0, 0, ist)
+	tests = []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"@hourly", time.Date(2015, time.December, 10, 11, 0, 0, 0, ist)},
+		{"0 9 * * *", time.Date(2015, time.December, 11, 9, 0, 0, 0, ist)},
+		{"15 12 * * *", time.Date(2015, time.December, 10, 12, 15, 0, 0, ist)},
+	}
+	for _, test := range tests {
+		sched, err := parseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.spec, err)
+			continue
+		}
+		if next := sched.Next(now); !next.Equal(test.expected) {
+			t.Errorf("%q in IST: expected %v, got %v", test.spec, test.expected, next)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@every 10s", "@every soon", "@yearly"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+type fakeReport struct {
+	runs int
+	err  error
+	// If set the report doesn't finish until it is closed
+	block chan struct{}
+}
+
+func (f *fakeReport) Report(config *github.Config) error {
+	if f.block != nil {
+		<-f.block
+	}
+	f.runs++
+	if f.err != nil && f.err.Error() == "panic" {
+		panic("boom")
+	}
+	return f.err
+}
+func (f *fakeReport) AddFlags(cmd *cobra.Command, config *github.Config) {}
+func (f *fakeReport) Name() string                                       { return "fake" }
+
+func TestSchedulerRunDue(t *testing.T) {
+	report := &fakeReport{}
+	reportMap["fake"] = report
+	defer delete(reportMap, "fake")
+
+	start := time.Date(2015, time.December, 10, 10, 30, 0, 0, time.UTC)
+	if _, err := newScheduler(&ScheduleConfig{Schedules: []ScheduleEntry{{Report: "missing", Schedule: "@daily"}}}, start); err == nil {
+		t.Errorf("expected error for unknown report")
+	}
+	s, err := newScheduler(&ScheduleConfig{Schedules: []ScheduleEntry{{Report: "fake", Schedule: "@hourly"}}}, start)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.RunDue(nil, start.Add(10*time.Minute))
+	if report.runs != 0 {
+		t.Errorf("report ran before it was due")
+	}
+
+	s.RunDue(nil, start.Add(30*time.Minute))
+	s.running.Wait()
+	status := s.Status()[0]
+	if report.runs != 1 || status.Runs != 1 || status.Failures != 0 || status.LastStart == nil {
+		t.Errorf("expected one successful run: %#v", status)
+	}
+	if expected := start.Add(90 * time.Minute); !status.NextRun.Equal(expected) {
+		t.Errorf("expected next run at %v, got %v", expected, status.NextRun)
+	}
+
+	// Three hours late only runs once
+	report.err = fmt.Errorf("panic")
+	s.RunDue(nil, start.Add(4*time.Hour))
+	s.RunDue(nil, start.Add(4*time.Hour))
+	s.running.Wait()
+	status = s.Status()[0]
+	if report.runs != 2 || status.Failures != 1 || status.LastError != "panic: boom" {
+		t.Errorf("expected one failed run: runs=%d %#v", report.runs, status)
+	}
+
+	// A run which is due while the last one is still running is skipped
+	report.err = nil
+	report.block = make(chan struct{})
+	s.RunDue(nil, start.Add(5*time.Hour))
+	if status = s.Status()[0]; !status.Running {
+		t.Errorf("expected the report to be running: %#v", status)
+	}
+	s.RunDue(nil, start.Add(6*time.Hour))
+	close(report.block)
+	s.running.Wait()
+	status = s.Status()[0]
+	if report.runs != 3 || status.Running {
+		t.Errorf("expected one more run: runs=%d %#v", report.runs, status)
+	}
+	if expected := start.Add(6*time.Hour + 30*time.Minute); !status.NextRun.Equal(expected) {
+		t.Errorf("expected next run at %v, got %v", expected, status.NextRun)
+	}
+}
@@ -17,10 +17,7 @@ limitations under the License.
 package reports
 
 import (
-	"bytes"
 	"fmt"
-	"io"
-	"os/exec"
 	"sort"
 	"strings"
 	"time"
@@ -129,15 +126,6 @@ func gatherData(cfg *githubhelper.Config) (*reportData, error) {
 	return &r, nil
 }
 
-func (s *ShameReport) runCmd(r io.Reader) error {
-	args := strings.Split(s.Command, " ")
-	bin := args[0]
-	args = args[1:]
-	cmd := exec.Command(bin, args...)
-	cmd.Stdin = r
-	return cmd.Run()
-}
-
 // Report is the workhorse that actually makes the report.
 func (s *ShameReport) Report(cfg *githubhelper.Config) error {
 	r, err := gatherData(cfg)
@@ -157,6 +145,45 @@ func (s *ShameReport) Report(cfg *githubhelper.Config) error {
 	return nil
 }
 
+const shameGroupTemplate = `
+If you are in the To: line of this email, you have flaky tests to fix! Flaky
+tests, even if they flake only a small percentage of the time, cause the merge
+queue to become very long, which causes everyone on the team pain and
+suffering.  Please either fix the tests assigned to you or find them an owner
+who will fix them.
+
+There were {{.LowPriorityTests}} P2/P3 issues which are not reported here.
+
+Full report:
+{{.Report}}
+{{if .MissingAddresses}}
+These users couldn't be added to the To: line, as we have no address for them:
+
+{{.MissingAddresses}}
+
+Individuals with an accessible email and no assignments older than 3 days will
+be left off the group email, so please make your email address public in github!
+
+Note: non-google users are not emailed by this system.
+
+{{end}}`
+
+const shameIndividualTemplate = `
+Hi {{.User}},
+
+This is a note to let you know that you have flaky tests assigned to you.
+Owners of tests broken for less than 3 days are left off the group email!
+
+Full report:
+{{.Report}}`
+
+type shameTemplateData struct {
+	User             string
+	Report           string
+	LowPriorityTests int
+	MissingAddresses string
+}
+
 func (s *ShameReport) groupReport(r *reportData) (map[string]bool, error) {
 	needsIndividualEmail := map[string]bool{}
 	// Gather report body
@@ -193,44 +220,25 @@ func (s *ShameReport) groupReport(r *reportData) (map[string]bool, error) {
 	sort.Strings(to)
 	sort.Strings(missingAddresses)
 
-	dest := &bytes.Buffer{}
-
-	// Write the report
-	if s.From != "" {
-		fmt.Fprintf(dest, "From: %v\n", s.From)
+	n := s.notifier()
+	body, err := n.Render("shame-group", shameGroupTemplate, shameTemplateData{
+		Report:           strings.Join(chunks, "\n\n"),
+		LowPriorityTests: r.lowPriorityTests,
+		MissingAddresses: strings.Join(missingAddresses, ", "),
+	})
+	if err != nil {
+		return nil, err
 	}
-	if s.Cc != "" {
-		fmt.Fprintf(dest, "Cc: %v\n", s.Cc)
+	msg := &Message{
+		From:    s.From,
+		To:      to,
+		Subject: fmt.Sprintf("Kubernetes flaky Test Report: %v flaky tests", r.totalTests),
+		Body:    body,
 	}
-	fmt.Fprintf(dest, "To: %v\n", strings.Join(to, ","))
-	fmt.Fprintf(dest, "Subject: Kubernetes flaky Test Report: %v flaky tests\n", r.totalTests)
-	fmt.Fprintf(dest, `
-If you are in the To: line of this email, you have flaky tests to fix! Flaky
-tests, even if they flake only a small percentage of the time, cause the merge
-queue to become very long, which causes everyone on the team pain and
-suffering.  Please either fix the tests assigned to you or find them an owner
-who will fix them.
-
-There were %v P2/P3 issues which are not reported here.
-
-Full report:
-`, r.lowPriorityTests)
-	fmt.Fprintf(dest, "%s\n", strings.Join(chunks, "\n\n"))
-	if len(missingAddresses) > 0 {
-		fmt.Fprintf(dest, `
-These users couldn't be added to the To: line, as we have no address for them:
-
-%v
-
-Individuals with an accessible email and no assignments older than 3 days will
-be left off the group email, so please make your email address public in github!
-
-Note: non-google users are not emailed by this system.
-
-`, strings.Join(missingAddresses, ", "))
+	if s.Cc != "" {
+		msg.Cc = strings.Split(s.Cc, ",")
 	}
-
-	return needsIndividualEmail, s.runCmd(dest)
+	return needsIndividualEmail, n.Notify(msg)
 }
 
 func (s *ShameReport) individualReport(user string, r *reportData) error {
@@ -250,27 +258,27 @@ func (s *ShameReport) individualReport(user string, r *reportData) error {
 	}
 	sort.Strings(to)
 
-	dest := &bytes.Buffer{}
-
-	// Write the report
-	if s.From != "" {
-		fmt.Fprintf(dest, "From: %v\n", s.From)
+	n := s.notifier()
+	body, err := n.Render("shame-individual", shameIndividualTemplate, shameTemplateData{
+		User:   user,
+		Report: chunk,
+	})
+	if err != nil {
+		return err
 	}
 	// No Cc on individual emails!
-	fmt.Fprintf(dest, "To: %v\n", strings.Join(to, ","))
-	fmt.Fprint(dest, "Bcc: dbsmith@google.com\n") // so I know it works
-	fmt.Fprintf(dest, "Subject: Kubernetes flaky Test Report: %v flaky tests\n", r.totalTests)
-	fmt.Fprintf(dest, `
-Hi %v,
-
-This is a note to let you know that you have flaky tests assigned to you.
-Owners of tests broken for less than 3 days are left off the group email!
-
-Full report:
-`, user)
-	fmt.Fprint(dest, chunk)
+	return n.Notify(&Message{
+		From:    s.From,
+		To:      to,
+		Subject: fmt.Sprintf("Kubernetes flaky Test Report: %v flaky tests", r.totalTests),
+		Body:    body,
+	})
+}
 
-	return s.runCmd(dest)
+// notifier returns where the report is sent. Without --notify-config the
+// report is piped into --shame-report-cmd like it always has been.
+func (s *ShameReport) notifier() *Dispatcher {
+	return notifier("shame-report-cmd", &commandNotifier{Command: s.Command})
 }
 
 func mayEmail(email string) bool { return strings.HasSuffix(email, "@google.com") }
@@ -1,9 +1,9 @@
 "use strict";
 angular.module('SubmitQueueModule', ['ngMaterial', 'md.data.table', 'angular-toArrayFilter']);
 
-angular.module('SubmitQueueModule').controller('SQCntl', ['DataService', '$interval', '$location', SQCntl]);
+angular.module('SubmitQueueModule').controller('SQCntl', ['DataService', '$interval', '$location', '$scope', SQCntl]);
 
-function SQCntl(dataService, $interval, $location) {
+function SQCntl(dataService, $interval, $location, $scope) {
   var self = this;
   self.prs = {};
   self.users = {};
@@ -34,6 +34,39 @@ function SQCntl(dataService, $interval, $location) {
     });
   }
 
+  // Apply status changes as they happen. The polling above and below
+  // still runs in case the stream is unavailable.
+  streamStatus();
+
+  function streamStatus() {
+    if (typeof(EventSource) === "undefined") {
+      return;
+    }
+    var source = new EventSource('prs/stream');
+    source.addEventListener('snapshot', function(e) {
+      $scope.$apply(function() {
+        self.prs = JSON.parse(e.data).PRStatus;
+        self.prSearchTerms = getPRSearchTerms();
+      });
+    });
+    source.addEventListener('status', function(e) {
+      $scope.$apply(function() {
+        var pr = JSON.parse(e.data);
+        self.prs[pr.Number] = pr;
+        self.prSearchTerms = getPRSearchTerms();
+      });
+    });
+    source.addEventListener('history', function(e) {
+      $scope.$apply(function() {
+        if (!self.historyPRs) {
+          return;
+        }
+        self.historyPRs.push(JSON.parse(e.data));
+        self.historySearchTerms = getHistorySearchTerms();
+      });
+    });
+  }
+
   // Refresh every 30 seconds
   refreshGithubE2E();
   $interval(refreshGithubE2E, 30000);
@@ -78,6 +111,9 @@ function SQCntl(dataService, $interval, $location) {
       document.getElementById("github-api-limit-count").innerHTML = response.data.LimitRemaining;
       var nextReset = new Date(response.data.LimitResetTime);
       document.getElementById("github-api-limit-reset").innerHTML = nextReset.toLocaleTimeString();
+      var exhaustion = new Date(response.data.LimitExhaustionTime);
+      // Go's zero time means we will not run out before the reset
+      document.getElementById("github-api-limit-exhaustion").innerHTML = exhaustion.getFullYear() > 1 ? exhaustion.toLocaleTimeString() : "not before reset";
     });
   }
 
@@ -103,6 +139,36 @@ function SQCntl(dataService, $interval, $location) {
     });
   }
 
+  // Refresh every minute
+  refreshCodeFreeze();
+  $interval(refreshCodeFreeze, 60000);
+
+  function refreshCodeFreeze() {
+    dataService.getData('code-freeze').then(function successCallback(response) {
+      self.codeFreezes = response.data.Freezes;
+    });
+  }
+
+  // Refresh every minute
+  refreshDependencies();
+  $interval(refreshDependencies, 60000);
+
+  function refreshDependencies() {
+    dataService.getData('dependencies').then(function successCallback(response) {
+      self.dependencies = response.data.Dependencies;
+    });
+  }
+
+  // Refresh every minute
+  refreshAdminState();
+  $interval(refreshAdminState, 60000);
+
+  function refreshAdminState() {
+    dataService.getData('admin/state').then(function successCallback(response) {
+      self.adminState = response.data;
+    });
+  }
+
   function getE2E(builds) {
     var result = [];
     var failedBuild = false;
@@ -114,6 +180,11 @@ function SQCntl(dataService, $interval, $location) {
         // green check mark
         obj.state = '\u2713';
         obj.color = 'green';
+      } else if (value.indexOf('(non-blocking until') >= 0) {
+        // failing, but an admin said not to block the queue on it
+        obj.state = '\u2716';
+        obj.color = 'orange';
+        obj.msg = value;
       } else if (value == 'Not Stable') {
         // red X mark
         obj.state = '\u2716';
@@ -0,0 +1,90 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"k8s.io/kubernetes/pkg/util"
+)
+
+// loadBalancerBackend is the proxy the controller configures with the http,
+// https and tcp services it finds. The proxy is picked by the name in the
+// json config.
+type loadBalancerBackend interface {
+	// algorithms returns the balancing algorithms the proxy supports, the
+	// first one is used if the default algorithm isn't supported.
+	algorithms() []string
+
+	// write renders and validates the config of the services, replacing
+	// the current one if it changed, see loadBalancerConfig.write. Writes
+	// to stdout if dryRun == true.
+	write(services map[string][]service, dryRun bool) (bool, error)
+
+	// apply makes the proxy use the config last written. The proxy is
+	// reloaded if reload == true, even if the config could be applied
+	// without, to load files the config refers to, like certificates.
+	apply(services map[string][]service, reload bool) error
+
+	// stats returns the statistics of the proxy, in its own format.
+	stats() ([]byte, error)
+
+	// healthz returns an error if the proxy isn't serving.
+	healthz() error
+}
+
+// newBackend returns the backend for the proxy named in the config.
+func newBackend(cfg *loadBalancerConfig, reloadRateLimiter util.RateLimiter) (loadBalancerBackend, error) {
+	switch cfg.Name {
+	case "haproxy":
+		return newHAProxyBackend(cfg, reloadRateLimiter), nil
+	case "nginx":
+		return newNginxBackend(cfg, reloadRateLimiter), nil
+	}
+	return nil, fmt.Errorf("unknown loadbalancer %q, expected haproxy or nginx", cfg.Name)
+}
+
+// defaultAlgorithm returns the algorithm services use when they don't name
+// one, the configured one if the backend supports it.
+func defaultAlgorithm(b loadBalancerBackend, configured string) string {
+	algorithms := b.algorithms()
+	for _, a := range algorithms {
+		if a == configured {
+			return a
+		}
+	}
+	return algorithms[0]
+}
+
+// getStats returns the body of a stats page served by the proxy.
+func getStats(url string) ([]byte, error) {
+	response, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v returned %v: %v", url, response.StatusCode, string(contents))
+	}
+	return contents, nil
+}
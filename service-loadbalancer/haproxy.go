@@ -0,0 +1,66 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"k8s.io/kubernetes/pkg/util"
+)
+
+// See https://cbonte.github.io/haproxy-dconv/configuration-1.5.html#4.2-balance
+// In brief:
+//  * roundrobin: backend with the highest weight (how is this set?) receives new connection
+//  * leastconn: backend with least connections receives new connection
+//  * first: first server sorted by server id, with an available slot receives connection
+//  * source: connection given to backend based on hash of source ip
+var haproxyAlgorithms = []string{"roundrobin", "leastconn", "first", "source"}
+
+// haproxyBackend configures haproxy. Endpoint changes are applied through
+// its stats socket when they can, see runtime.go.
+type haproxyBackend struct {
+	cfg               *loadBalancerConfig
+	reloadRateLimiter util.RateLimiter
+
+	// slots are the server slots of each backend, and appliedSlots those
+	// haproxy currently has, along with the structure of its config.
+	slots            map[string][]serverSlot
+	appliedSlots     map[string][]serverSlot
+	appliedStructure []byte
+}
+
+func newHAProxyBackend(cfg *loadBalancerConfig, reloadRateLimiter util.RateLimiter) *haproxyBackend {
+	return &haproxyBackend{cfg: cfg, reloadRateLimiter: reloadRateLimiter}
+}
+
+func (h *haproxyBackend) algorithms() []string {
+	return haproxyAlgorithms
+}
+
+func (h *haproxyBackend) write(services map[string][]service, dryRun bool) (bool, error) {
+	h.assignServerSlots(services["http"], services["tcp"])
+	return h.cfg.write(services, dryRun)
+}
+
+func (h *haproxyBackend) stats() ([]byte, error) {
+	return getStats(fmt.Sprintf("http://localhost:%v/;csv", h.cfg.statsPort))
+}
+
+func (h *haproxyBackend) healthz() error {
+	_, err := getStats(fmt.Sprintf("http://localhost:%v", h.cfg.statsPort))
+	return err
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	// haproxy server weights, see
+	// http://cbonte.github.io/haproxy-dconv/configuration-1.5.html#5.2-weight
+	minWeight = 1
+	maxWeight = 256
+)
+
+// healthCheck configures active health checks of the endpoints of a
+// service. Zero values keep the defaults of haproxy.
+type healthCheck struct {
+	// Path is requested from endpoints of http services, a 2xx or 3xx
+	// response means they're healthy. Endpoints of tcp services, or of http
+	// services without a path, are healthy if they accept connections.
+	Path string
+	// Interval between checks, in milliseconds.
+	Interval int64
+	// Rise is the number of successful checks before an endpoint is up,
+	// Fall the number of failed checks before it's down.
+	Rise int
+	Fall int
+}
+
+// parseHealthCheck returns the health check of a service, nil if it has none
+// of the health check annotations.
+func parseHealthCheck(annotations serviceAnnotations) (*healthCheck, error) {
+	check := &healthCheck{}
+	found := false
+	if val, ok := annotations.getHealthCheckPath(); ok {
+		found = true
+		if !strings.HasPrefix(val, "/") || strings.ContainsAny(val, " \t\r\n") {
+			return nil, fmt.Errorf("health check path %q must start with / and can't contain whitespace", val)
+		}
+		check.Path = val
+	}
+	if val, ok := annotations.getHealthCheckInterval(); ok {
+		found = true
+		interval, err := parseMillis(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid health check interval: %v", err)
+		}
+		check.Interval = interval
+	}
+	for _, count := range []struct {
+		name  string
+		get   func() (string, bool)
+		value *int
+	}{
+		{"rise", annotations.getHealthCheckRise, &check.Rise},
+		{"fall", annotations.getHealthCheckFall, &check.Fall},
+	} {
+		val, ok := count.get()
+		if !ok {
+			continue
+		}
+		found = true
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("health check %v %q must be a positive number", count.name, val)
+		}
+		*count.value = n
+	}
+	if !found {
+		return nil, nil
+	}
+	return check, nil
+}
+
+// parseMillis parses a positive duration, eg. 2s, into milliseconds.
+func parseMillis(val string) (int64, error) {
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, err
+	}
+	if d < time.Millisecond {
+		return 0, fmt.Errorf("%q must be at least 1ms", val)
+	}
+	return int64(d / time.Millisecond), nil
+}
+
+// parseWeight parses the weight of an endpoint.
+func parseWeight(val string) (int, error) {
+	weight, err := strconv.Atoi(val)
+	if err != nil || weight < minWeight || weight > maxWeight {
+		return 0, fmt.Errorf("weight %q must be a number from %v to %v", val, minWeight, maxWeight)
+	}
+	return weight, nil
+}
+
+// DefaultServer returns the options every server of the backend of the
+// service gets, empty if haproxy's defaults apply.
+func (s service) DefaultServer() string {
+	opts := []string{}
+	if s.HealthCheck != nil {
+		if s.HealthCheck.Interval > 0 {
+			opts = append(opts, fmt.Sprintf("inter %v", s.HealthCheck.Interval))
+		}
+		if s.HealthCheck.Rise > 0 {
+			opts = append(opts, fmt.Sprintf("rise %v", s.HealthCheck.Rise))
+		}
+		if s.HealthCheck.Fall > 0 {
+			opts = append(opts, fmt.Sprintf("fall %v", s.HealthCheck.Fall))
+		}
+	}
+	if s.SlowStart > 0 {
+		opts = append(opts, fmt.Sprintf("slowstart %v", s.SlowStart))
+	}
+	if s.Limits.MaxConn > 0 {
+		opts = append(opts, fmt.Sprintf("maxconn %v", s.Limits.MaxConn))
+	}
+	return strings.Join(opts, " ")
+}
+
+// hasWeightAnnotation returns true if the pod sets the weight of its endpoints.
+func hasWeightAnnotation(pod *api.Pod) bool {
+	_, ok := pod.Annotations[lbWeightKey]
+	return ok
+}
+
+// getEndpointWeights returns the weights of the endpoints of a service from
+// the weight annotation of their pods, by pod ip. Endpoints missing from it
+// keep the default weight.
+func (lbc *loadBalancerController) getEndpointWeights(s *api.Service) map[string]int {
+	weights := map[string]int{}
+	if lbc.podStore == nil {
+		return weights
+	}
+	ep, err := lbc.epLister.GetServiceEndpoints(s)
+	if err != nil {
+		return weights
+	}
+	for _, ss := range ep.Subsets {
+		for _, epAddress := range ss.Addresses {
+			ref := epAddress.TargetRef
+			if ref == nil || ref.Kind != "Pod" {
+				continue
+			}
+			obj, exists, err := lbc.podStore.GetByKey(fmt.Sprintf("%v/%v", ref.Namespace, ref.Name))
+			if err != nil || !exists {
+				continue
+			}
+			val, ok := obj.(*api.Pod).Annotations[lbWeightKey]
+			if !ok {
+				continue
+			}
+			weight, err := parseWeight(val)
+			if err != nil {
+				glog.Errorf("Ignoring weight of pod %v/%v: %v", ref.Namespace, ref.Name, err)
+				continue
+			}
+			weights[epAddress.IP] = weight
+		}
+	}
+	return weights
+}
+
+// endpointWeights maps endpoints to the weights of their pod ips, nil if
+// none of them has a weight.
+func endpointWeights(eps []string, podWeights map[string]int) map[string]int {
+	var weights map[string]int
+	for _, ep := range eps {
+		host, _, err := net.SplitHostPort(ep)
+		if err != nil {
+			continue
+		}
+		if weight, ok := podWeights[host]; ok {
+			if weights == nil {
+				weights = map[string]int{}
+			}
+			weights[ep] = weight
+		}
+	}
+	return weights
+}
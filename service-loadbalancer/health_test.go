@@ -0,0 +1,186 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/util"
+)
+
+func TestParseHealthCheck(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expected    *healthCheck
+		valid       bool
+	}{
+		{"no health check", map[string]string{}, nil, true},
+		{
+			name: "all options",
+			annotations: map[string]string{
+				lbHealthCheckPathKey:     "/healthz?full=1",
+				lbHealthCheckIntervalKey: "2s",
+				lbHealthCheckRiseKey:     "2",
+				lbHealthCheckFallKey:     "3",
+			},
+			expected: &healthCheck{Path: "/healthz?full=1", Interval: 2000, Rise: 2, Fall: 3},
+			valid:    true,
+		},
+		{
+			name:        "connect check",
+			annotations: map[string]string{lbHealthCheckIntervalKey: "500ms"},
+			expected:    &healthCheck{Interval: 500},
+			valid:       true,
+		},
+		{"relative path", map[string]string{lbHealthCheckPathKey: "healthz"}, nil, false},
+		{"path with spaces", map[string]string{lbHealthCheckPathKey: "/health z"}, nil, false},
+		{"bad interval", map[string]string{lbHealthCheckIntervalKey: "2"}, nil, false},
+		{"zero interval", map[string]string{lbHealthCheckIntervalKey: "0s"}, nil, false},
+		{"bad rise", map[string]string{lbHealthCheckRiseKey: "two"}, nil, false},
+		{"zero fall", map[string]string{lbHealthCheckFallKey: "0"}, nil, false},
+	}
+	for _, tc := range testCases {
+		check, err := parseHealthCheck(serviceAnnotations(tc.annotations))
+		if (err == nil) != tc.valid || !reflect.DeepEqual(check, tc.expected) {
+			t.Errorf("%v: expected %+v valid %v, got %+v, %v", tc.name, tc.expected, tc.valid, check, err)
+		}
+	}
+}
+
+func TestParseWeight(t *testing.T) {
+	testCases := []struct {
+		weight   string
+		expected int
+		valid    bool
+	}{
+		{"1", 1, true},
+		{"256", 256, true},
+		{"0", 0, false},
+		{"257", 0, false},
+		{"heavy", 0, false},
+	}
+	for _, tc := range testCases {
+		weight, err := parseWeight(tc.weight)
+		if (err == nil) != tc.valid || weight != tc.expected {
+			t.Errorf("%q: expected weight %v valid %v, got %v, %v", tc.weight, tc.expected, tc.valid, weight, err)
+		}
+	}
+}
+
+func TestDefaultServer(t *testing.T) {
+	testCases := []struct {
+		svc      service
+		expected string
+	}{
+		{service{}, ""},
+		{service{HealthCheck: &healthCheck{Path: "/healthz"}}, ""},
+		{service{HealthCheck: &healthCheck{Interval: 2000, Rise: 2, Fall: 3}}, "inter 2000 rise 2 fall 3"},
+		{service{HealthCheck: &healthCheck{Fall: 1}, SlowStart: 30000}, "fall 1 slowstart 30000"},
+		{service{SlowStart: 10000}, "slowstart 10000"},
+	}
+	for _, tc := range testCases {
+		if got := tc.svc.DefaultServer(); got != tc.expected {
+			t.Errorf("%+v: expected %q, got %q", tc.svc, tc.expected, got)
+		}
+	}
+}
+
+// buildHealthTestLoadBalancer returns a loadbalancer with an http service
+// checked on /healthz, a tcp service checked by connecting, and endpoints
+// weighted by the annotations of their pods.
+func buildHealthTestLoadBalancer(t *testing.T) *loadBalancerController {
+	pods := []*api.Pod{
+		{ObjectMeta: api.ObjectMeta{Name: "heavy", Namespace: ns,
+			Annotations: map[string]string{lbWeightKey: "10"}}},
+		{ObjectMeta: api.ObjectMeta{Name: "plain", Namespace: ns}},
+		{ObjectMeta: api.ObjectMeta{Name: "bad", Namespace: ns,
+			Annotations: map[string]string{lbWeightKey: "1000"}}},
+	}
+	endpointAddresses := []api.EndpointAddress{}
+	for i, pod := range pods {
+		endpointAddresses = append(endpointAddresses, api.EndpointAddress{
+			IP:        []string{"1.2.3.4", "5.6.7.8", "9.9.9.9"}[i],
+			TargetRef: &api.ObjectReference{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name},
+		})
+	}
+	endpointPorts := []api.EndpointPort{
+		{Port: 80, Protocol: "TCP"},
+		{Port: 3306, Protocol: "TCP"},
+	}
+	servicePorts := []api.ServicePort{
+		{Port: 80, TargetPort: util.NewIntOrStringFromInt(80)},
+		{Port: 3306, TargetPort: util.NewIntOrStringFromInt(3306)},
+	}
+	svc := getService(servicePorts)
+	svc.ObjectMeta.Name = "web"
+	svc.ObjectMeta.Annotations = map[string]string{
+		lbHealthCheckPathKey:     "/healthz",
+		lbHealthCheckIntervalKey: "5s",
+		lbHealthCheckFallKey:     "2",
+		lbSlowStartKey:           "30s",
+	}
+	flb := newFakeLoadBalancerController(
+		[]*api.Endpoints{getEndpoints(svc, endpointAddresses, endpointPorts)}, []*api.Service{svc})
+	flb.podStore = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, pod := range pods {
+		if err := flb.podStore.Add(pod); err != nil {
+			t.Fatalf("Unable to add pod: %v", err)
+		}
+	}
+	flb.tcpServices = map[string]int{"web": 3306}
+	useTestConfig(flb, "roundrobin")
+	return flb
+}
+
+func TestGetServicesHealthChecksAndWeights(t *testing.T) {
+	httpSvc, tcpSvc, _ := buildHealthTestLoadBalancer(t).getServices()
+	if len(httpSvc) != 1 || len(tcpSvc) != 1 {
+		t.Fatalf("Expected an http and a tcp service, got %+v %+v", httpSvc, tcpSvc)
+	}
+	expectedCheck := &healthCheck{Path: "/healthz", Interval: 5000, Fall: 2}
+	for _, svc := range []service{httpSvc[0], tcpSvc[0]} {
+		if !reflect.DeepEqual(svc.HealthCheck, expectedCheck) || svc.SlowStart != 30000 {
+			t.Errorf("Expected service %v to have health check %+v and slow start 30000, got %+v %v",
+				svc.Name, expectedCheck, svc.HealthCheck, svc.SlowStart)
+		}
+	}
+	expectedWeights := map[string]int{"1.2.3.4:80": 10}
+	if !reflect.DeepEqual(httpSvc[0].Weights, expectedWeights) {
+		t.Errorf("Expected weights %v, got %v", expectedWeights, httpSvc[0].Weights)
+	}
+}
+
+func TestHealthChecksAndWeights(t *testing.T) {
+	flb := buildHealthTestLoadBalancer(t)
+	httpSvc, tcpSvc, _ := flb.getServices()
+	if _, err := flb.backend.write(
+		map[string][]service{
+			"http": httpSvc,
+			"tcp":  tcpSvc,
+		}, false); err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	template, _ := filepath.Abs("./test-samples/TestHealthChecksAndWeights.cfg")
+	compareCfgFiles(t, flb.cfg.Config, template)
+	os.Remove(flb.cfg.Config)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// serviceLimits protect a service from a single client or from more traffic
+// than its endpoints can handle. Zero values mean no limit.
+type serviceLimits struct {
+	// MaxConn is the number of concurrent connections to each endpoint,
+	// further connections wait in a queue until one is closed.
+	MaxConn int
+	// RateLimit is the number of requests per second a client ip can send
+	// to an http service, further requests are denied.
+	RateLimit int
+	// ConnRateLimit is the number of connections per second a client ip can
+	// open to a tcp service, further connections are rejected.
+	ConnRateLimit int
+}
+
+// parseLimits returns the limits of a service from its annotations, falling
+// back to the given defaults. Rate limits only apply to http services and
+// connection rate limits to tcp services, as http services share a frontend.
+func parseLimits(annotations serviceAnnotations, defaults serviceLimits, tcp bool) (serviceLimits, error) {
+	if tcp {
+		defaults.RateLimit = 0
+		if _, ok := annotations.getRateLimit(); ok {
+			return defaults, fmt.Errorf("rate limits only apply to http services")
+		}
+	} else {
+		defaults.ConnRateLimit = 0
+		if _, ok := annotations.getConnRateLimit(); ok {
+			return defaults, fmt.Errorf("connection rate limits only apply to tcp services")
+		}
+	}
+	limits := defaults
+	for _, limit := range []struct {
+		name  string
+		get   func() (string, bool)
+		value *int
+	}{
+		{"max connections", annotations.getMaxConn, &limits.MaxConn},
+		{"rate limit", annotations.getRateLimit, &limits.RateLimit},
+		{"connection rate limit", annotations.getConnRateLimit, &limits.ConnRateLimit},
+	} {
+		val, ok := limit.get()
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return defaults, fmt.Errorf("%v %q must be a number, 0 for no limit", limit.name, val)
+		}
+		*limit.value = n
+	}
+	return limits, nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/util"
+)
+
+func TestParseLimits(t *testing.T) {
+	defaults := serviceLimits{MaxConn: 100, RateLimit: 10, ConnRateLimit: 5}
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		tcp         bool
+		expected    serviceLimits
+		valid       bool
+	}{
+		{"http defaults", map[string]string{}, false, serviceLimits{MaxConn: 100, RateLimit: 10}, true},
+		{"tcp defaults", map[string]string{}, true, serviceLimits{MaxConn: 100, ConnRateLimit: 5}, true},
+		{
+			name:        "http limits",
+			annotations: map[string]string{lbMaxConnKey: "20", lbRateLimitKey: "0"},
+			expected:    serviceLimits{MaxConn: 20},
+			valid:       true,
+		},
+		{
+			name:        "tcp limits",
+			annotations: map[string]string{lbConnRateLimitKey: "50"},
+			tcp:         true,
+			expected:    serviceLimits{MaxConn: 100, ConnRateLimit: 50},
+			valid:       true,
+		},
+		{
+			name:        "negative limit",
+			annotations: map[string]string{lbMaxConnKey: "-1"},
+			expected:    serviceLimits{MaxConn: 100, RateLimit: 10},
+		},
+		{
+			name:        "bad limit",
+			annotations: map[string]string{lbRateLimitKey: "lots"},
+			expected:    serviceLimits{MaxConn: 100, RateLimit: 10},
+		},
+		{
+			name:        "rate limit of tcp service",
+			annotations: map[string]string{lbRateLimitKey: "10"},
+			tcp:         true,
+			expected:    serviceLimits{MaxConn: 100, ConnRateLimit: 5},
+		},
+		{
+			name:        "connection rate limit of http service",
+			annotations: map[string]string{lbConnRateLimitKey: "10"},
+			expected:    serviceLimits{MaxConn: 100, RateLimit: 10},
+		},
+	}
+	for _, tc := range testCases {
+		limits, err := parseLimits(serviceAnnotations(tc.annotations), defaults, tc.tcp)
+		if (err == nil) != tc.valid || limits != tc.expected {
+			t.Errorf("%v: expected %+v valid %v, got %+v, %v", tc.name, tc.expected, tc.valid, limits, err)
+		}
+	}
+}
+
+// buildLimitsTestLoadBalancer returns a loadbalancer with a rate limited
+// http service, a rate limited http service with session affinity, and a
+// connection rate limited tcp service, all limiting connections to their
+// endpoints by default.
+func buildLimitsTestLoadBalancer() *loadBalancerController {
+	endpointAddresses := []api.EndpointAddress{{IP: "1.2.3.4"}}
+	endpointPorts := []api.EndpointPort{{Port: 80, Protocol: "TCP"}}
+	servicePorts := []api.ServicePort{
+		{Port: 80, TargetPort: util.NewIntOrStringFromInt(80)},
+	}
+	tcpServicePorts := []api.ServicePort{
+		{Port: 3306, TargetPort: util.NewIntOrStringFromInt(80)},
+	}
+
+	annotations := map[string]map[string]string{
+		"api": {
+			lbRateLimitKey: "20",
+		},
+		"sticky": {
+			lbRateLimitKey: "5",
+			lbMaxConnKey:   "10",
+		},
+		"db": {
+			lbConnRateLimitKey: "50",
+		},
+	}
+	services := []*api.Service{}
+	endpoints := []*api.Endpoints{}
+	for name, a := range annotations {
+		svc := getService(servicePorts)
+		if name == "db" {
+			svc = getService(tcpServicePorts)
+		}
+		svc.ObjectMeta.Name = name
+		svc.ObjectMeta.Annotations = a
+		if name == "sticky" {
+			svc.Spec.SessionAffinity = "ClientIP"
+		}
+		services = append(services, svc)
+		endpoints = append(endpoints, getEndpoints(svc, endpointAddresses, endpointPorts))
+	}
+	flb := newFakeLoadBalancerController(endpoints, services)
+	flb.tcpServices = map[string]int{"db": 3306}
+	flb.defaultLimits = serviceLimits{MaxConn: 100}
+	useTestConfig(flb, "roundrobin")
+	return flb
+}
+
+func TestLimits(t *testing.T) {
+	flb := buildLimitsTestLoadBalancer()
+	httpSvc, tcpSvc, _ := flb.getServices()
+	if _, err := flb.backend.write(
+		map[string][]service{
+			"http": httpSvc,
+			"tcp":  tcpSvc,
+		}, false); err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	template, _ := filepath.Abs("./test-samples/TestLimits.cfg")
+	compareCfgFiles(t, flb.cfg.Config, template)
+	os.Remove(flb.cfg.Config)
+}
@@ -17,16 +17,108 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/golang/glog"
 	"github.com/ziutek/syslog"
 )
 
+// httpLogLine matches the haproxy http log format, after the optional
+// haproxy[pid]: tag the syslog header parser may leave, see
+// http://cbonte.github.io/haproxy-dconv/configuration-1.5.html#8.2.3
+// client:port [accept date] frontend backend/server Tq/Tw/Tc/Tr/Tt status
+// bytes request-cookie response-cookie termination-state
+// actconn/feconn/beconn/srv_conn/retries srv_queue/backend_queue
+// {captured headers} "request"
+var httpLogLine = regexp.MustCompile(`^(?:\S+\[\d+\]: )?(\S+):(\d+) \[([^\]]+)\] (\S+) (\S+)/(\S+) ` +
+	`(-?\d+)/(-?\d+)/(-?\d+)/(-?\d+)/\+?(-?\d+) (-?\d+) \+?(\d+) \S+ \S+ (\S+) ` +
+	`\d+/\d+/\d+/\d+/\+?\d+ \d+/\d+(?: \{[^}]*\})* "(.*)"$`)
+
+// accessLogRecord is a request logged by haproxy. Timings are in
+// milliseconds, -1 if the request was aborted before reaching that step.
+type accessLogRecord struct {
+	Time             string `json:"time"`
+	Client           string `json:"client"`
+	ClientPort       int    `json:"clientPort"`
+	Frontend         string `json:"frontend"`
+	Backend          string `json:"backend"`
+	Server           string `json:"server"`
+	RequestTime      int    `json:"requestTime"`
+	QueueTime        int    `json:"queueTime"`
+	ConnectTime      int    `json:"connectTime"`
+	ResponseTime     int    `json:"responseTime"`
+	TotalTime        int    `json:"totalTime"`
+	Status           int    `json:"status"`
+	Bytes            int64  `json:"bytes"`
+	TerminationState string `json:"terminationState"`
+	Request          string `json:"request"`
+}
+
+// parseAccessLog parses an haproxy http log line, returns false for any
+// other line.
+func parseAccessLog(line string) (*accessLogRecord, bool) {
+	m := httpLogLine.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+	// The expression only matches numbers in these groups, but they can
+	// still be out of range.
+	ints := []int{}
+	for _, val := range append([]string{m[2]}, m[7:13]...) {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, false
+		}
+		ints = append(ints, n)
+	}
+	size, err := strconv.ParseInt(m[13], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &accessLogRecord{
+		Time:             m[3],
+		Client:           m[1],
+		ClientPort:       ints[0],
+		Frontend:         m[4],
+		Backend:          m[5],
+		Server:           m[6],
+		RequestTime:      ints[1],
+		QueueTime:        ints[2],
+		ConnectTime:      ints[3],
+		ResponseTime:     ints[4],
+		TotalTime:        ints[5],
+		Status:           ints[6],
+		Bytes:            size,
+		TerminationState: m[14],
+		Request:          m[15],
+	}, true
+}
+
+// statusClass returns the class of an http status code, eg. 2xx.
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// observe adds a request to the metrics of its backend.
+func (r *accessLogRecord) observe() {
+	backendRequests.WithLabelValues(r.Backend, statusClass(r.Status)).Inc()
+	if r.TotalTime >= 0 {
+		backendLatency.WithLabelValues(r.Backend).Observe(float64(r.TotalTime) / 1000)
+	}
+}
+
 type handler struct {
 	*syslog.BaseHandler
+	out io.Writer
 }
 
 type syslogServer struct {
@@ -35,12 +127,12 @@ type syslogServer struct {
 
 // newSyslogServer start a syslog server using a unix socket to listen for connections
 func newSyslogServer(path string) (*syslogServer, error) {
-	glog.Infof("Starting syslog server for haproxy using %v as socket", path)
+	glog.Infof("Starting syslog server for the loadbalancer using %v as socket", path)
 	// remove the socket file if exists
 	os.Remove(path)
 
 	server := &syslogServer{syslog.NewServer()}
-	server.AddHandler(newHandler())
+	server.AddHandler(newHandler(os.Stdout))
 	err := server.Listen(path)
 	if err != nil {
 		return nil, err
@@ -49,8 +141,8 @@ func newSyslogServer(path string) (*syslogServer, error) {
 	return server, nil
 }
 
-func newHandler() *handler {
-	h := handler{syslog.NewBaseHandler(1000, nil, false)}
+func newHandler(out io.Writer) *handler {
+	h := handler{syslog.NewBaseHandler(1000, nil, false), out}
 	go h.mainLoop()
 	return &h
 }
@@ -61,9 +153,21 @@ func (h *handler) mainLoop() {
 		if message == nil {
 			break
 		}
-
-		fmt.Printf("servicelb [%s] %s%s\n", strings.ToUpper(message.Severity.String()), message.Tag, message.Content)
+		h.handle(message)
 	}
 
 	h.End()
 }
+
+// handle writes http requests as json records and adds them to the
+// metrics, anything else is written as it is.
+func (h *handler) handle(message *syslog.Message) {
+	if record, ok := parseAccessLog(strings.TrimSpace(message.Tag + message.Content)); ok {
+		record.observe()
+		if b, err := json.Marshal(record); err == nil {
+			fmt.Fprintf(h.out, "%s\n", b)
+			return
+		}
+	}
+	fmt.Fprintf(h.out, "servicelb [%s] %s%s\n", strings.ToUpper(message.Severity.String()), message.Tag, message.Content)
+}
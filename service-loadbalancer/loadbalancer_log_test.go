@@ -17,14 +17,105 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"reflect"
 	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/ziutek/syslog"
 )
 
-func TestSyslogSocket(t *testing.T) {
-	server, err := newSyslogServer("test-syslog.socket")
-	if err != nil {
-		t.Fatalf("Unexpected error, received %+v, expected a valid syslog socket server", err)
+func TestParseAccessLog(t *testing.T) {
+	testCases := []struct {
+		name     string
+		line     string
+		expected *accessLogRecord
+	}{
+		{
+			name: "request",
+			line: `haproxy[42]: 10.0.0.1:51234 [18/Oct/2015:14:02:11.123] httpfrontend nginxsvc/s1 10/0/2/30/42 200 2750 - - ---- 1/1/0/1/0 0/0 "GET /nginxsvc/index.html HTTP/1.1"`,
+			expected: &accessLogRecord{
+				Time: "18/Oct/2015:14:02:11.123", Client: "10.0.0.1", ClientPort: 51234,
+				Frontend: "httpfrontend", Backend: "nginxsvc", Server: "s1",
+				RequestTime: 10, QueueTime: 0, ConnectTime: 2, ResponseTime: 30, TotalTime: 42,
+				Status: 200, Bytes: 2750, TerminationState: "----",
+				Request: "GET /nginxsvc/index.html HTTP/1.1",
+			},
+		},
+		{
+			name: "aborted request with captured headers and no tag",
+			line: `::1:4000 [18/Oct/2015:14:02:12.000] httpsfrontend team-a_api:8080/<NOSRV> -1/-1/-1/-1/+5000 -1 +0 - - CR-- 2/2/0/0/0 0/0 {example.com} {} "<BADREQ>"`,
+			expected: &accessLogRecord{
+				Time: "18/Oct/2015:14:02:12.000", Client: "::1", ClientPort: 4000,
+				Frontend: "httpsfrontend", Backend: "team-a_api:8080", Server: "<NOSRV>",
+				RequestTime: -1, QueueTime: -1, ConnectTime: -1, ResponseTime: -1, TotalTime: 5000,
+				Status: -1, Bytes: 0, TerminationState: "CR--",
+				Request: "<BADREQ>",
+			},
+		},
+		{
+			name: "tcp connection",
+			line: `haproxy[42]: 10.0.0.1:51234 [18/Oct/2015:14:02:11.123] mysql:3306 mysql:3306/s1 0/0/5012 212 -- 1/1/1/1/0 0/0`,
+		},
+		{
+			name: "haproxy message",
+			line: `haproxy[42]: Proxy httpfrontend started.`,
+		},
+	}
+	for _, tc := range testCases {
+		record, ok := parseAccessLog(tc.line)
+		if ok != (tc.expected != nil) || !reflect.DeepEqual(record, tc.expected) {
+			t.Errorf("%v: expected %+v, got %+v", tc.name, tc.expected, record)
+		}
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	for status, expected := range map[int]string{200: "2xx", 301: "3xx", 404: "4xx", 503: "5xx", -1: "unknown", 999: "unknown"} {
+		if class := statusClass(status); class != expected {
+			t.Errorf("Expected status %v to be %v, got %v", status, expected, class)
+		}
 	}
+}
 
-	server.Shutdown()
+// counterValue returns the value of a counter.
+func counterValue(t *testing.T, c interface {
+	Write(*dto.Metric) error
+}) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatalf("Unable to read metric: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestHandle(t *testing.T) {
+	var out bytes.Buffer
+	h := &handler{out: &out}
+	requests := backendRequests.WithLabelValues("logtest", "5xx")
+	before := counterValue(t, requests)
+
+	// haproxy sends no hostname, so the syslog server takes its tag for one
+	h.handle(&syslog.Message{
+		Severity: syslog.Info,
+		Tag:      "10",
+		Content:  `.0.0.1:51234 [18/Oct/2015:14:02:11.123] httpfrontend logtest/s1 0/0/1/2/3 503 100 - - ---- 1/1/0/1/0 0/0 "GET / HTTP/1.1"`,
+	})
+	var record accessLogRecord
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a json record, got %q: %v", out.String(), err)
+	}
+	if record.Backend != "logtest" || record.Status != 503 || record.TotalTime != 3 {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if after := counterValue(t, requests); after != before+1 {
+		t.Errorf("Expected the request to be counted, got %v requests after %v", after, before)
+	}
+
+	out.Reset()
+	h.handle(&syslog.Message{Severity: syslog.Notice, Tag: "haproxy", Content: "[42]: Proxy httpfrontend started."})
+	if expected := "servicelb [NOTICE] haproxy[42]: Proxy httpfrontend started.\n"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
 }
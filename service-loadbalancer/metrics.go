@@ -0,0 +1,83 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "servicelb"
+
+var (
+	reloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reloads_total",
+		Help:      "Number of times haproxy was reloaded with a new config.",
+	})
+	reloadErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reload_errors_total",
+		Help:      "Number of times reloading haproxy failed.",
+	})
+	runtimeUpdates = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "runtime_updates_total",
+		Help:      "Number of times endpoint changes were applied through the haproxy stats socket instead of a reload.",
+	})
+	runtimeUpdateErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "runtime_update_errors_total",
+		Help:      "Number of times updating haproxy through the stats socket failed, falling back to a reload.",
+	})
+	configValidationErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_validation_errors_total",
+		Help:      "Number of rendered configs rejected by the check command.",
+	})
+	udpSessions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "udp_sessions",
+		Help:      "Number of clients with an open session through the udp proxy.",
+	})
+	udpBindErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "udp_bind_errors_total",
+		Help:      "Number of times the udp proxy was unable to listen on the port of a udp service.",
+	})
+	backendRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "backend_requests_total",
+		Help:      "Number of http requests in the access log, by backend and status code class.",
+	}, []string{"backend", "code"})
+	backendLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "backend_request_duration_seconds",
+		Help:      "Time from accepting http requests to sending the last byte of the response, by backend.",
+	}, []string{"backend"})
+)
+
+func init() {
+	prometheus.MustRegister(reloads)
+	prometheus.MustRegister(reloadErrors)
+	prometheus.MustRegister(runtimeUpdates)
+	prometheus.MustRegister(runtimeUpdateErrors)
+	prometheus.MustRegister(configValidationErrors)
+	prometheus.MustRegister(udpSessions)
+	prometheus.MustRegister(udpBindErrors)
+	prometheus.MustRegister(backendRequests)
+	prometheus.MustRegister(backendLatency)
+}
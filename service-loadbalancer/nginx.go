@@ -0,0 +1,83 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/util"
+)
+
+// See http://nginx.org/en/docs/http/ngx_http_upstream_module.html
+// In brief:
+//  * round_robin: requests are distributed over the servers in turn
+//  * least_conn: request goes to the server with the least active connections
+//  * ip_hash: request goes to a server based on a hash of the client ip
+var nginxAlgorithms = []string{"round_robin", "least_conn", "ip_hash"}
+
+// nginxBackend configures nginx, reloading it on every change. nginx only
+// loadbalances http services.
+type nginxBackend struct {
+	cfg               *loadBalancerConfig
+	reloadRateLimiter util.RateLimiter
+}
+
+func newNginxBackend(cfg *loadBalancerConfig, reloadRateLimiter util.RateLimiter) *nginxBackend {
+	return &nginxBackend{cfg: cfg, reloadRateLimiter: reloadRateLimiter}
+}
+
+func (n *nginxBackend) algorithms() []string {
+	return nginxAlgorithms
+}
+
+func (n *nginxBackend) write(services map[string][]service, dryRun bool) (bool, error) {
+	httpServices := map[string][]service{}
+	for kind, svcs := range services {
+		if kind != "tcp" {
+			httpServices[kind] = svcs
+			continue
+		}
+		for _, svc := range svcs {
+			glog.Errorf("Ignoring tcp service %v, nginx only loadbalances http services", svc.Name)
+		}
+	}
+	// nginx only counts failed requests to endpoints, it doesn't check them
+	for _, svc := range httpServices["http"] {
+		if svc.HealthCheck != nil && (svc.HealthCheck.Path != "" || svc.HealthCheck.Rise > 0) {
+			glog.Errorf("Ignoring the health check path and rise of service %v, nginx takes endpoints out after failed requests", svc.Name)
+		}
+		if svc.SlowStart > 0 {
+			glog.Errorf("Ignoring the slow start of service %v, nginx doesn't support it", svc.Name)
+		}
+	}
+	return n.cfg.write(httpServices, dryRun)
+}
+
+func (n *nginxBackend) apply(services map[string][]service, reload bool) error {
+	n.reloadRateLimiter.Accept()
+	return n.cfg.reload()
+}
+
+func (n *nginxBackend) stats() ([]byte, error) {
+	return getStats(fmt.Sprintf("http://localhost:%v/nginx_status", n.cfg.statsPort))
+}
+
+func (n *nginxBackend) healthz() error {
+	_, err := n.stats()
+	return err
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/util"
+)
+
+// useNginx switches the loadbalancer of a test to nginx.
+func useNginx(flb *loadBalancerController) {
+	cfg, _ := filepath.Abs("./test-samples/loadbalancer-nginx_test.json")
+	nginxCfg := parseCfg(cfg, "roundrobin")
+	nginxCfg.Config = flb.cfg.Config
+	nginxCfg.httpsPort = flb.cfg.httpsPort
+	nginxCfg.sslCertsDir = flb.cfg.sslCertsDir
+	nginxCfg.statsPort = 1936
+	flb.cfg = nginxCfg
+	flb.backend = newNginxBackend(flb.cfg, util.NewFakeRateLimiter())
+}
+
+func TestNginxDefaultAlgorithm(t *testing.T) {
+	flb := buildTestLoadBalancer("")
+	useNginx(flb)
+	httpSvc, tcpSvc, _ := flb.getServices()
+	for _, svc := range httpSvc {
+		if svc.Algorithm != "round_robin" {
+			t.Errorf("Expected service %v to use round_robin, got %v", svc.Name, svc.Algorithm)
+		}
+	}
+	if _, err := flb.backend.write(
+		map[string][]service{
+			"http": httpSvc,
+			"tcp":  tcpSvc,
+		}, false); err != nil {
+		t.Fatalf("Expected a valid nginx config, but an error was returned: %v", err)
+	}
+	template, _ := filepath.Abs("./test-samples/TestNginxDefaultAlgorithm.conf")
+	compareCfgFiles(t, flb.cfg.Config, template)
+	os.Remove(flb.cfg.Config)
+}
+
+func TestNginxPathRouting(t *testing.T) {
+	flb := buildPathTestLoadBalancer()
+	useNginx(flb)
+	httpSvc, tcpSvc, _ := flb.getServices()
+	if _, err := flb.backend.write(
+		map[string][]service{
+			"http": httpSvc,
+			"tcp":  tcpSvc,
+		}, false); err != nil {
+		t.Fatalf("Expected a valid nginx config, but an error was returned: %v", err)
+	}
+	template, _ := filepath.Abs("./test-samples/TestNginxPathRouting.conf")
+	compareCfgFiles(t, flb.cfg.Config, template)
+	os.Remove(flb.cfg.Config)
+}
+
+func TestNginxSslTermination(t *testing.T) {
+	flb := buildSslTestLoadBalancer(t, "foo-tls", newTLSSecret(t, "foo-tls", "foo.example.com"))
+	useNginx(flb)
+	httpSvc, tcpSvc, _ := flb.getServices()
+	if _, err := flb.backend.write(
+		map[string][]service{
+			"http":  httpSvc,
+			"https": sslTermServices(httpSvc),
+			"tcp":   tcpSvc,
+		}, false); err != nil {
+		t.Fatalf("Expected a valid nginx config, but an error was returned: %v", err)
+	}
+	template, _ := filepath.Abs("./test-samples/TestNginxSslTermination.conf")
+	compareCfgFiles(t, flb.cfg.Config, template)
+	os.Remove(flb.cfg.Config)
+}
+
+func TestNginxLimits(t *testing.T) {
+	flb := buildLimitsTestLoadBalancer()
+	useNginx(flb)
+	httpSvc, tcpSvc, _ := flb.getServices()
+	if _, err := flb.backend.write(
+		map[string][]service{
+			"http": httpSvc,
+			"tcp":  tcpSvc,
+		}, false); err != nil {
+		t.Fatalf("Expected a valid nginx config, but an error was returned: %v", err)
+	}
+	template, _ := filepath.Abs("./test-samples/TestNginxLimits.conf")
+	compareCfgFiles(t, flb.cfg.Config, template)
+	os.Remove(flb.cfg.Config)
+}
+
+func TestNginxHealthCheck(t *testing.T) {
+	flb := buildHealthTestLoadBalancer(t)
+	useNginx(flb)
+	httpSvc, tcpSvc, _ := flb.getServices()
+	if _, err := flb.backend.write(
+		map[string][]service{
+			"http": httpSvc,
+			"tcp":  tcpSvc,
+		}, false); err != nil {
+		t.Fatalf("Expected a valid nginx config, but an error was returned: %v", err)
+	}
+	template, _ := filepath.Abs("./test-samples/TestNginxHealthCheck.conf")
+	compareCfgFiles(t, flb.cfg.Config, template)
+	os.Remove(flb.cfg.Config)
+}
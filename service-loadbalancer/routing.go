@@ -0,0 +1,259 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Paths end up in haproxy acls and regular expressions, so only allow
+// characters which need no escaping in either.
+var pathRegexp = regexp.MustCompile(`^/[A-Za-z0-9._~:/-]*$`)
+
+// parsePath validates a path from an annotation and strips any trailing /.
+func parsePath(path string) (string, error) {
+	if !pathRegexp.MatchString(path) || strings.Contains(path, "//") {
+		return "", fmt.Errorf("invalid path %q, must start with / and match %v", path, pathRegexp)
+	}
+	if path != "/" {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path, nil
+}
+
+// parsePathRewrite validates a path rewrite from an annotation. It always ends
+// with a /, which replaces the / following the matched path prefix.
+func parsePathRewrite(rewrite string) (string, error) {
+	path, err := parsePath(rewrite)
+	if err != nil {
+		return "", err
+	}
+	if path != "/" {
+		path += "/"
+	}
+	return path, nil
+}
+
+// lbRule routes the requests matching the host and/or the path acls of a
+// backend to it.
+type lbRule struct {
+	Backend string
+	Host    string
+	Path    string
+	// RateLimit of the backend, for proxies which limit requests by
+	// location.
+	RateLimit int
+
+	// The path prefix of the service and what it's rewritten to, empty
+	// if requests are passed on unchanged.
+	RewritePrefix string
+	RewritePath   string
+}
+
+// The kinds of rules, in the order they are matched in.
+const (
+	// A host with a custom path, for services sharing a host.
+	hostPathRule = iota
+	// Any path on a host.
+	hostRule
+	// A path on any host.
+	pathRule
+)
+
+func (r lbRule) kind() int {
+	switch {
+	case r.Host != "" && r.Path != "":
+		return hostPathRule
+	case r.Host != "":
+		return hostRule
+	}
+	return pathRule
+}
+
+// rulesBySpecificity orders rules so the most specific one matching a request
+// comes first, with longer paths before their prefixes.
+type rulesBySpecificity []lbRule
+
+func (r rulesBySpecificity) Len() int {
+	return len(r)
+}
+func (r rulesBySpecificity) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+func (r rulesBySpecificity) Less(i, j int) bool {
+	if ki, kj := r[i].kind(), r[j].kind(); ki != kj {
+		return ki < kj
+	}
+	if len(r[i].Path) != len(r[j].Path) {
+		return len(r[i].Path) > len(r[j].Path)
+	}
+	if r[i].Path != r[j].Path {
+		return r[i].Path < r[j].Path
+	}
+	if r[i].Host != r[j].Host {
+		return r[i].Host < r[j].Host
+	}
+	return r[i].Backend < r[j].Backend
+}
+
+// ruleConflict is a rule which was dropped because the rule of another
+// backend has the same host and path.
+type ruleConflict struct {
+	Rule  lbRule
+	Owner string
+}
+
+func (c ruleConflict) String() string {
+	return fmt.Sprintf("rule for host %q path %q to %v conflicts with the rule to %v",
+		c.Rule.Host, c.Rule.Path, c.Rule.Backend, c.Owner)
+}
+
+// pathNamespace returns the namespace a path is reserved for, the first
+// segment of /namespace/name paths.
+func pathNamespace(path string) string {
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[1]
+}
+
+// getRules returns the rules routing requests to the given http services, in
+// the order haproxy has to match them, and the conflicting rules it dropped.
+// Of the rules for the same host and path, the one for the default path of a
+// service wins so a custom path can't take over another service's url, then
+// the one to the backend sorting first. So which service gets the traffic
+// doesn't depend on the order haproxy happens to see them in.
+func getRules(services []service) ([]lbRule, []ruleConflict) {
+	rules := []lbRule{}
+	custom := map[lbRule]bool{}
+	for _, svc := range services {
+		rule := lbRule{Backend: svc.Name, RateLimit: svc.Limits.RateLimit}
+		if svc.RewritePath != "" {
+			rule.RewritePrefix = svc.Path
+			rule.RewritePath = svc.RewritePath
+		}
+		pathRule, hostRule, hostPathRule := rule, rule, rule
+		pathRule.Path = svc.Path
+		hostRule.Host = svc.Host
+		hostPathRule.Host, hostPathRule.Path = svc.Host, svc.Path
+		switch {
+		case svc.customPath && svc.Host != "":
+			rules = append(rules, hostPathRule)
+			custom[hostPathRule] = true
+		case svc.Host != "":
+			rules = append(rules, pathRule, hostRule)
+		default:
+			rules = append(rules, pathRule)
+			custom[pathRule] = svc.customPath
+		}
+	}
+	sort.Sort(rulesBySpecificity(rules))
+
+	owners := map[lbRule]lbRule{}
+	for _, rule := range rules {
+		key := lbRule{Host: rule.Host, Path: rule.Path}
+		if owner, ok := owners[key]; !ok || (custom[owner] && !custom[rule]) {
+			owners[key] = rule
+		}
+	}
+	out := []lbRule{}
+	conflicts := []ruleConflict{}
+	for _, rule := range rules {
+		if owner := owners[lbRule{Host: rule.Host, Path: rule.Path}]; owner != rule {
+			conflicts = append(conflicts, ruleConflict{Rule: rule, Owner: owner.Backend})
+			continue
+		}
+		out = append(out, rule)
+	}
+	return out, conflicts
+}
+
+// virtualHost is a host and the rules for requests to it, in the order they
+// are matched in, for proxies which pick the host before the path. The
+// default host, for requests to any other host, has no name.
+type virtualHost struct {
+	Host    string
+	SslCert string
+	Rules   []lbRule
+	// CatchAll is true if a rule matches any path on the host.
+	CatchAll bool
+}
+
+// getVirtualHosts groups the rules of the services by host, ending with the
+// default host. Rules for any path on a host leave out the rules for paths
+// on any host, as those are never matched for it.
+func getVirtualHosts(services []service, rules []lbRule) []virtualHost {
+	certs := map[string]string{}
+	for _, svc := range services {
+		if svc.Host != "" && svc.SslCert != "" && certs[svc.Host] == "" {
+			certs[svc.Host] = svc.SslCert
+		}
+	}
+	byHost := map[string]*virtualHost{}
+	vhosts := []*virtualHost{}
+	pathRules := []lbRule{}
+	for _, rule := range rules {
+		if rule.kind() == pathRule {
+			pathRules = append(pathRules, rule)
+			continue
+		}
+		vhost, ok := byHost[rule.Host]
+		if !ok {
+			vhost = &virtualHost{Host: rule.Host, SslCert: certs[rule.Host]}
+			byHost[rule.Host] = vhost
+			vhosts = append(vhosts, vhost)
+		}
+		vhost.Rules = append(vhost.Rules, rule)
+	}
+
+	out := []virtualHost{}
+	for _, vhost := range vhosts {
+		paths := map[string]bool{}
+		rules := []lbRule{}
+		for _, rule := range vhost.Rules {
+			// A rule for any path is a rule for /
+			path := rule.Path
+			if path == "" {
+				path = "/"
+			}
+			if !paths[path] {
+				paths[path] = true
+				rules = append(rules, rule)
+			}
+		}
+		if !paths["/"] {
+			for _, rule := range pathRules {
+				if !paths[rule.Path] {
+					paths[rule.Path] = true
+					rules = append(rules, rule)
+				}
+			}
+		}
+		vhost.Rules = rules
+		vhost.CatchAll = paths["/"]
+		out = append(out, *vhost)
+	}
+	defaultHost := virtualHost{Rules: pathRules}
+	for _, rule := range pathRules {
+		defaultHost.CatchAll = defaultHost.CatchAll || rule.Path == "/"
+	}
+	return append(out, defaultHost)
+}
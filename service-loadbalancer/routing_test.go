@@ -0,0 +1,298 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/pkg/util"
+)
+
+func TestParsePath(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected string
+		rewrite  string
+		valid    bool
+	}{
+		{"/", "/", "/", true},
+		{"/api", "/api", "/api/", true},
+		{"/api/", "/api", "/api/", true},
+		{"/api/v1.2", "/api/v1.2", "/api/v1.2/", true},
+		{"api", "", "", false},
+		{"", "", "", false},
+		{"/api//v1", "", "", false},
+		{"/api v1", "", "", false},
+		{"/api?v=1", "", "", false},
+	}
+	for _, tc := range testCases {
+		path, err := parsePath(tc.path)
+		if (err == nil) != tc.valid || path != tc.expected {
+			t.Errorf("%q: expected path %q valid %v, got %q, %v", tc.path, tc.expected, tc.valid, path, err)
+		}
+		rewrite, err := parsePathRewrite(tc.path)
+		if (err == nil) != tc.valid || rewrite != tc.rewrite {
+			t.Errorf("%q: expected rewrite %q valid %v, got %q, %v", tc.path, tc.rewrite, tc.valid, rewrite, err)
+		}
+	}
+}
+
+func TestGetRules(t *testing.T) {
+	testCases := []struct {
+		name      string
+		services  []service
+		expected  []lbRule
+		conflicts []ruleConflict
+	}{
+		{
+			name: "longer paths first",
+			services: []service{
+				{Name: "a", Path: "/a"},
+				{Name: "b", Path: "/a/b"},
+				{Name: "root", Path: "/", customPath: true},
+			},
+			expected: []lbRule{
+				{Backend: "b", Path: "/a/b"},
+				{Backend: "a", Path: "/a"},
+				{Backend: "root", Path: "/"},
+			},
+		},
+		{
+			name: "services sharing a host",
+			services: []service{
+				{Name: "api", Path: "/api", Host: "foo.com", customPath: true},
+				{Name: "web", Path: "/web", Host: "foo.com"},
+				{Name: "other", Path: "/other"},
+			},
+			expected: []lbRule{
+				{Backend: "api", Host: "foo.com", Path: "/api"},
+				{Backend: "web", Host: "foo.com"},
+				{Backend: "other", Path: "/other"},
+				{Backend: "web", Path: "/web"},
+			},
+		},
+		{
+			name: "conflicting rules",
+			services: []service{
+				{Name: "a", Path: "/api", customPath: true},
+				{Name: "b", Path: "/api", customPath: true},
+				{Name: "c", Path: "/c", Host: "foo.com"},
+				{Name: "d", Path: "/d", Host: "foo.com"},
+			},
+			expected: []lbRule{
+				{Backend: "c", Host: "foo.com"},
+				{Backend: "a", Path: "/api"},
+				{Backend: "c", Path: "/c"},
+				{Backend: "d", Path: "/d"},
+			},
+			conflicts: []ruleConflict{
+				{Rule: lbRule{Backend: "d", Host: "foo.com"}, Owner: "c"},
+				{Rule: lbRule{Backend: "b", Path: "/api"}, Owner: "a"},
+			},
+		},
+		{
+			name: "default path wins over a custom path",
+			services: []service{
+				{Name: "a", Path: "/team-a/web", customPath: true},
+				{Name: "team-a_web", Path: "/team-a/web"},
+			},
+			expected: []lbRule{
+				{Backend: "team-a_web", Path: "/team-a/web"},
+			},
+			conflicts: []ruleConflict{
+				{Rule: lbRule{Backend: "a", Path: "/team-a/web"}, Owner: "team-a_web"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		rules, conflicts := getRules(tc.services)
+		if !reflect.DeepEqual(rules, tc.expected) {
+			t.Errorf("%v: expected rules %+v, got %+v", tc.name, tc.expected, rules)
+		}
+		if len(conflicts) != len(tc.conflicts) || len(conflicts) > 0 && !reflect.DeepEqual(conflicts, tc.conflicts) {
+			t.Errorf("%v: expected conflicts %+v, got %+v", tc.name, tc.conflicts, conflicts)
+		}
+	}
+}
+
+func TestGetVirtualHosts(t *testing.T) {
+	services := []service{
+		{Name: "api", Path: "/api", Host: "foo.com", SslCert: "foo.pem", customPath: true},
+		{Name: "web", Path: "/web", Host: "foo.com"},
+		{Name: "other", Path: "/other"},
+		{Name: "root", Path: "/", customPath: true},
+	}
+	expected := []virtualHost{
+		{
+			Host:    "foo.com",
+			SslCert: "foo.pem",
+			Rules: []lbRule{
+				{Backend: "api", Host: "foo.com", Path: "/api"},
+				{Backend: "web", Host: "foo.com"},
+			},
+			CatchAll: true,
+		},
+		{
+			Rules: []lbRule{
+				{Backend: "other", Path: "/other"},
+				{Backend: "web", Path: "/web"},
+				{Backend: "root", Path: "/"},
+			},
+			CatchAll: true,
+		},
+	}
+	rules, _ := getRules(services)
+	vhosts := getVirtualHosts(services, rules)
+	if !reflect.DeepEqual(vhosts, expected) {
+		t.Errorf("Expected virtual hosts %+v, got %+v", expected, vhosts)
+	}
+}
+
+// buildPathTestLoadBalancer returns a loadbalancer with services api and web
+// sharing a host, and a service with an invalid path.
+func buildPathTestLoadBalancer() *loadBalancerController {
+	endpointAddresses := []api.EndpointAddress{{IP: "1.2.3.4"}}
+	endpointPorts := []api.EndpointPort{{Port: 80, Protocol: "TCP"}}
+	servicePorts := []api.ServicePort{
+		{Port: 80, TargetPort: util.NewIntOrStringFromInt(80)},
+	}
+
+	annotations := map[string]map[string]string{
+		"api": {
+			lbHostKey:        "foo.example.com",
+			lbPathKey:        "/api/",
+			lbPathRewriteKey: "/v1",
+		},
+		"web": {
+			lbHostKey: "foo.example.com",
+			lbPathKey: "/",
+		},
+		"bad": {
+			lbPathKey: "bad path",
+		},
+	}
+	services := []*api.Service{}
+	endpoints := []*api.Endpoints{}
+	for name, a := range annotations {
+		svc := getService(servicePorts)
+		svc.ObjectMeta.Name = name
+		svc.ObjectMeta.Annotations = a
+		services = append(services, svc)
+		endpoints = append(endpoints, getEndpoints(svc, endpointAddresses, endpointPorts))
+	}
+	flb := newFakeLoadBalancerController(endpoints, services)
+	useTestConfig(flb, "roundrobin")
+	return flb
+}
+
+func TestGetServicesPaths(t *testing.T) {
+	expected := map[string]service{
+		"api": {Path: "/api", RewritePath: "/v1/", customPath: true},
+		"web": {Path: "/", customPath: true},
+		"bad": {Path: "/bad", RewritePath: "/"},
+	}
+	httpSvc, _, _ := buildPathTestLoadBalancer().getServices()
+	if len(httpSvc) != len(expected) {
+		t.Fatalf("Expected services %+v, got %+v", expected, httpSvc)
+	}
+	for _, svc := range httpSvc {
+		e := expected[svc.Name]
+		if svc.Path != e.Path || svc.RewritePath != e.RewritePath || svc.customPath != e.customPath {
+			t.Errorf("Expected service %v to have path %q rewrite %q, got %q %q",
+				svc.Name, e.Path, e.RewritePath, svc.Path, svc.RewritePath)
+		}
+	}
+}
+
+func TestPathRouting(t *testing.T) {
+	flb := buildPathTestLoadBalancer()
+	httpSvc, tcpSvc, _ := flb.getServices()
+	if _, err := flb.backend.write(
+		map[string][]service{
+			"http": httpSvc,
+			"tcp":  tcpSvc,
+		}, false); err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	template, _ := filepath.Abs("./test-samples/TestPathRouting.cfg")
+	compareCfgFiles(t, flb.cfg.Config, template)
+	os.Remove(flb.cfg.Config)
+}
+
+func TestGetServicesReservedPaths(t *testing.T) {
+	endpointAddresses := []api.EndpointAddress{{IP: "1.2.3.4"}}
+	endpointPorts := []api.EndpointPort{{Port: 80, Protocol: "TCP"}}
+	servicePorts := []api.ServicePort{
+		{Port: 80, TargetPort: util.NewIntOrStringFromInt(80)},
+	}
+
+	testCases := []struct {
+		namespace string
+		name      string
+		path      string
+		expected  string
+	}{
+		{"team-a", "foo", "", "/team-a/foo"},
+		// Services can't take paths in the namespace of another
+		{"team-b", "hijack", "/team-a/foo", "/team-b/hijack"},
+		{ns, "web", "/team-a/web", "/web"},
+		// but can in their own
+		{"team-b", "api", "/team-b/v1", "/team-b/v1"},
+		{"team-b", "root", "/", "/"},
+	}
+	services := []*api.Service{}
+	endpoints := []*api.Endpoints{}
+	for _, tc := range testCases {
+		svc := getService(servicePorts)
+		svc.ObjectMeta.Name = tc.name
+		svc.ObjectMeta.Namespace = tc.namespace
+		if tc.path != "" {
+			svc.ObjectMeta.Annotations = map[string]string{lbPathKey: tc.path}
+		}
+		services = append(services, svc)
+		endpoints = append(endpoints, getEndpoints(svc, endpointAddresses, endpointPorts))
+	}
+	flb := newFakeLoadBalancerController(endpoints, services)
+	useTestConfig(flb, "roundrobin")
+	recorder := &record.FakeRecorder{}
+	flb.recorder = recorder
+
+	httpSvc, _, _ := flb.getServices()
+	paths := map[string]string{}
+	for _, svc := range httpSvc {
+		paths[svc.Path] = svc.Name
+	}
+	for _, tc := range testCases {
+		if _, ok := paths[tc.expected]; !ok {
+			t.Errorf("Expected service %v/%v on path %v, got %v", tc.namespace, tc.name, tc.expected, paths)
+		}
+	}
+	if len(recorder.Events) != 2 {
+		t.Errorf("Expected an event for each reserved path, got %q", recorder.Events)
+	}
+	for _, event := range recorder.Events {
+		if !strings.HasPrefix(event, "PathConflict ") {
+			t.Errorf("Unexpected event %q", event)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// Address of the server slots no endpoint has been assigned to yet.
+	unusedSlotHost = "127.0.0.1"
+
+	// How long to wait for haproxy to answer a stats socket command.
+	statsSocketTimeout = 5 * time.Second
+
+	// Weight of servers without one in the config.
+	defaultWeight = 1
+
+	// Tells haproxy_reload not to carry the server state over to the new
+	// haproxy, it doesn't match the config after a failed update.
+	discardServerStateEnv = "DISCARD_SERVER_STATE=1"
+)
+
+// serverSlot is a pre-allocated server in a backend. Endpoints are moved in
+// and out of slots through the haproxy stats socket, so only a change in the
+// number of slots of a backend needs a reload.
+type serverSlot struct {
+	Name     string
+	Address  string
+	Disabled bool
+	// Weight of the endpoint in the slot, 0 for the default weight.
+	Weight int
+}
+
+// slotPort returns the port of a host:port address.
+func slotPort(address string) string {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+// assignSlots returns the slots of a backend serving the given endpoints.
+// Endpoints keep the slots they had, new endpoints take the first disabled
+// slot with the same port, and slots are added in multiples of increment
+// when there's none. Slots are never removed.
+func assignSlots(prev []serverSlot, eps []string, increment int) []serverSlot {
+	slots := make([]serverSlot, len(prev))
+	copy(slots, prev)
+	wanted := map[string]bool{}
+	for _, ep := range eps {
+		wanted[ep] = true
+	}
+	placed := map[string]bool{}
+	for i := range slots {
+		addr := slots[i].Address
+		slots[i].Disabled = !wanted[addr] || placed[addr]
+		if !slots[i].Disabled {
+			placed[addr] = true
+		}
+	}
+
+	for _, ep := range eps {
+		if placed[ep] {
+			continue
+		}
+		free := -1
+		for i := range slots {
+			if slots[i].Disabled && slotPort(slots[i].Address) == slotPort(ep) {
+				free = i
+				break
+			}
+		}
+		if free == -1 {
+			slots = append(slots, serverSlot{Name: fmt.Sprintf("s%d", len(slots)+1)})
+			free = len(slots) - 1
+		}
+		slots[free].Address = ep
+		slots[free].Disabled = false
+		placed[ep] = true
+	}
+
+	if increment < 1 {
+		increment = 1
+	}
+	port := ""
+	if len(eps) > 0 {
+		port = slotPort(eps[0])
+	}
+	for len(slots)%increment != 0 {
+		slots = append(slots, serverSlot{
+			Name:     fmt.Sprintf("s%d", len(slots)+1),
+			Address:  net.JoinHostPort(unusedSlotHost, port),
+			Disabled: true,
+		})
+	}
+	return slots
+}
+
+// assignServerSlots assigns server slots to the endpoints of the services,
+// keeping the slots of the last assignment stable, and weights them.
+func (h *haproxyBackend) assignServerSlots(services ...[]service) {
+	slots := map[string][]serverSlot{}
+	for _, svcs := range services {
+		for i := range svcs {
+			svcs[i].Servers = assignSlots(h.slots[svcs[i].Name], svcs[i].Ep, h.cfg.serverSlots)
+			for j := range svcs[i].Servers {
+				svcs[i].Servers[j].Weight = 0
+				if !svcs[i].Servers[j].Disabled {
+					svcs[i].Servers[j].Weight = svcs[i].Weights[svcs[i].Servers[j].Address]
+				}
+			}
+			slots[svcs[i].Name] = svcs[i].Servers
+		}
+	}
+	h.slots = slots
+}
+
+// structure renders the config with the server slots stripped of their hosts,
+// state and weight. haproxy can be updated through the stats socket if this doesn't
+// change.
+func (cfg *loadBalancerConfig) structure(services map[string][]service) ([]byte, error) {
+	masked := map[string][]service{}
+	for kind, svcs := range services {
+		for _, svc := range svcs {
+			servers := []serverSlot{}
+			for _, slot := range svc.Servers {
+				servers = append(servers, serverSlot{Name: slot.Name, Address: slotPort(slot.Address)})
+			}
+			svc.Servers = servers
+			svc.Ep = nil
+			masked[kind] = append(masked[kind], svc)
+		}
+	}
+	var b bytes.Buffer
+	if err := cfg.render(&b, masked); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// runtimeCommand sends a command to the haproxy stats socket.
+func (cfg *loadBalancerConfig) runtimeCommand(cmd string) error {
+	conn, err := net.DialTimeout("unix", cfg.StatsSocket, statsSocketTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(statsSocketTimeout))
+	if _, err := fmt.Fprintf(conn, "%v\n", cmd); err != nil {
+		return err
+	}
+	out, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+	// Commands answer with nothing on success, except for address changes.
+	if msg := strings.TrimSpace(string(out)); msg != "" && !strings.HasPrefix(msg, "IP changed") {
+		return fmt.Errorf("%v: %v", cmd, msg)
+	}
+	return nil
+}
+
+// updateServers moves the endpoints of every backend haproxy already has
+// into their slots through the stats socket. Returns the number of commands
+// sent.
+func (h *haproxyBackend) updateServers() (int, error) {
+	sent := 0
+	for backend, slots := range h.slots {
+		applied := h.appliedSlots[backend]
+		for i, slot := range slots {
+			if i >= len(applied) {
+				break
+			}
+			cmds := []string{}
+			moved := slot.Address != applied[i].Address
+			enabled := applied[i].Disabled && !slot.Disabled
+			if moved && !slot.Disabled {
+				host, _, err := net.SplitHostPort(slot.Address)
+				if err != nil {
+					return sent, err
+				}
+				cmds = append(cmds, fmt.Sprintf("set server %v/%v addr %v", backend, slot.Name, host))
+			}
+			if slot.Disabled != applied[i].Disabled {
+				state := "ready"
+				if slot.Disabled {
+					state = "maint"
+				}
+				cmds = append(cmds, fmt.Sprintf("set server %v/%v state %v", backend, slot.Name, state))
+			}
+			// A slot keeps the weight of the last endpoint in it, so an
+			// endpoint taking a slot always sets its own.
+			if (slot.Weight != applied[i].Weight || moved || enabled) && !slot.Disabled {
+				weight := slot.Weight
+				if weight == 0 {
+					weight = defaultWeight
+				}
+				cmds = append(cmds, fmt.Sprintf("set weight %v/%v %v", backend, slot.Name, weight))
+			}
+			for _, cmd := range cmds {
+				glog.V(2).Infof("Sending %q to %v", cmd, h.cfg.StatsSocket)
+				if err := h.cfg.runtimeCommand(cmd); err != nil {
+					return sent, err
+				}
+				sent++
+			}
+		}
+	}
+	return sent, nil
+}
+
+// apply makes haproxy use the config just written. If only endpoints changed
+// they're updated through the stats socket, anything else is a reload.
+func (h *haproxyBackend) apply(services map[string][]service, reload bool) error {
+	structure, err := h.cfg.structure(services)
+	if err != nil {
+		return err
+	}
+	env := []string{}
+	if h.cfg.StatsSocket != "" && h.appliedSlots != nil {
+		sent, err := h.updateServers()
+		switch {
+		case err != nil:
+			runtimeUpdateErrors.Inc()
+			glog.Errorf("Reloading, failed to update servers through the stats socket: %v", err)
+			// Servers we didn't get to are in the state of the old
+			// config, which must not be loaded by the new haproxy.
+			env = append(env, discardServerStateEnv)
+		case !reload && bytes.Equal(structure, h.appliedStructure):
+			if sent > 0 {
+				runtimeUpdates.Inc()
+				glog.Infof("Updated %v servers through the stats socket", sent)
+			}
+			h.appliedSlots = h.slots
+			return nil
+		}
+		// The server state is saved and loaded across the reload, so the
+		// updates above keep servers taken down through the stats socket
+		// from staying down in the new config.
+	}
+
+	h.reloadRateLimiter.Accept()
+	if err := h.cfg.reload(env...); err != nil {
+		return err
+	}
+	h.appliedSlots = h.slots
+	h.appliedStructure = structure
+	return nil
+}
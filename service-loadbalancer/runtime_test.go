@@ -0,0 +1,252 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/util"
+)
+
+func TestAssignSlots(t *testing.T) {
+	testCases := []struct {
+		name      string
+		prev      []serverSlot
+		eps       []string
+		increment int
+		expected  []serverSlot
+	}{
+		{
+			name:      "new backend",
+			eps:       []string{"1.1.1.1:80", "2.2.2.2:80", "3.3.3.3:80"},
+			increment: 2,
+			expected: []serverSlot{
+				{Name: "s1", Address: "1.1.1.1:80"},
+				{Name: "s2", Address: "2.2.2.2:80"},
+				{Name: "s3", Address: "3.3.3.3:80"},
+				{Name: "s4", Address: "127.0.0.1:80", Disabled: true},
+			},
+		},
+		{
+			name: "endpoints keep their slots",
+			prev: []serverSlot{
+				{Name: "s1", Address: "1.1.1.1:80"},
+				{Name: "s2", Address: "2.2.2.2:80"},
+				{Name: "s3", Address: "127.0.0.1:80", Disabled: true},
+			},
+			eps: []string{"2.2.2.2:80", "4.4.4.4:80", "5.5.5.5:80"},
+			expected: []serverSlot{
+				{Name: "s1", Address: "4.4.4.4:80"},
+				{Name: "s2", Address: "2.2.2.2:80"},
+				{Name: "s3", Address: "5.5.5.5:80"},
+			},
+		},
+		{
+			name: "removed endpoints are disabled",
+			prev: []serverSlot{
+				{Name: "s1", Address: "1.1.1.1:80"},
+				{Name: "s2", Address: "2.2.2.2:80"},
+			},
+			eps: []string{"2.2.2.2:80"},
+			expected: []serverSlot{
+				{Name: "s1", Address: "1.1.1.1:80", Disabled: true},
+				{Name: "s2", Address: "2.2.2.2:80"},
+			},
+		},
+		{
+			name: "slots only take endpoints on their port",
+			prev: []serverSlot{
+				{Name: "s1", Address: "1.1.1.1:80", Disabled: true},
+			},
+			eps:       []string{"2.2.2.2:8080"},
+			increment: 2,
+			expected: []serverSlot{
+				{Name: "s1", Address: "1.1.1.1:80", Disabled: true},
+				{Name: "s2", Address: "2.2.2.2:8080"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		slots := assignSlots(tc.prev, tc.eps, tc.increment)
+		if !reflect.DeepEqual(slots, tc.expected) {
+			t.Errorf("%v: expected slots %+v, got %+v", tc.name, tc.expected, slots)
+		}
+	}
+}
+
+// fakeStatsSocket records the commands sent to a stats socket.
+type fakeStatsSocket struct {
+	listener net.Listener
+	lock     sync.Mutex
+	cmds     []string
+	reply    string
+}
+
+func newFakeStatsSocket(t *testing.T, path string) *fakeStatsSocket {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Unable to listen on %v: %v", path, err)
+	}
+	s := &fakeStatsSocket{listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			cmd, _ := bufio.NewReader(conn).ReadString('\n')
+			s.lock.Lock()
+			s.cmds = append(s.cmds, cmd[:len(cmd)-1])
+			conn.Write([]byte(s.reply))
+			s.lock.Unlock()
+			conn.Close()
+		}
+	}()
+	return s
+}
+
+func (s *fakeStatsSocket) commands() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cmds := s.cmds
+	s.cmds = nil
+	return cmds
+}
+
+func TestApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatalf("Unable to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socket := newFakeStatsSocket(t, filepath.Join(dir, "haproxy"))
+	defer socket.listener.Close()
+	reloaded := filepath.Join(dir, "reloaded")
+
+	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
+	h := newHAProxyBackend(parseCfg(cfg, "roundrobin"), util.NewFakeRateLimiter())
+	h.cfg.serverSlots = 2
+	h.cfg.ReloadCmd = "printf '%s' \"$DISCARD_SERVER_STATE\" > " + reloaded
+	h.cfg.StatsSocket = filepath.Join(dir, "haproxy")
+
+	testCases := []struct {
+		name    string
+		eps     []string
+		weights map[string]int
+		reload  bool
+		cmds    []string
+	}{
+		{
+			name:   "first sync reloads",
+			eps:    []string{"1.1.1.1:80"},
+			reload: true,
+		},
+		{
+			name: "new endpoint takes a slot",
+			eps:  []string{"1.1.1.1:80", "2.2.2.2:80"},
+			cmds: []string{
+				"set server foo/s2 addr 2.2.2.2",
+				"set server foo/s2 state ready",
+				"set weight foo/s2 1",
+			},
+		},
+		{
+			name: "removed endpoint is disabled",
+			eps:  []string{"2.2.2.2:80"},
+			cmds: []string{"set server foo/s1 state maint"},
+		},
+		{
+			name:   "more slots reload",
+			eps:    []string{"2.2.2.2:80", "3.3.3.3:80", "4.4.4.4:80"},
+			reload: true,
+			cmds: []string{
+				"set server foo/s1 addr 3.3.3.3",
+				"set server foo/s1 state ready",
+				"set weight foo/s1 1",
+			},
+		},
+		{
+			name:    "weights are set",
+			eps:     []string{"2.2.2.2:80", "3.3.3.3:80", "4.4.4.4:80"},
+			weights: map[string]int{"2.2.2.2:80": 5},
+			cmds:    []string{"set weight foo/s2 5"},
+		},
+		{
+			name: "removed weights are reset",
+			eps:  []string{"2.2.2.2:80", "3.3.3.3:80", "4.4.4.4:80"},
+			cmds: []string{"set weight foo/s2 1"},
+		},
+		{
+			name:    "weighted endpoint",
+			eps:     []string{"2.2.2.2:80", "3.3.3.3:80", "4.4.4.4:80"},
+			weights: map[string]int{"2.2.2.2:80": 5},
+			cmds:    []string{"set weight foo/s2 5"},
+		},
+		{
+			name: "weighted endpoint is removed",
+			eps:  []string{"3.3.3.3:80", "4.4.4.4:80"},
+			cmds: []string{"set server foo/s2 state maint"},
+		},
+		{
+			name: "slot of a weighted endpoint is reused",
+			eps:  []string{"3.3.3.3:80", "4.4.4.4:80", "5.5.5.5:80"},
+			cmds: []string{
+				"set server foo/s2 addr 5.5.5.5",
+				"set server foo/s2 state ready",
+				"set weight foo/s2 1",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		httpSvc := []service{{Name: "foo", Path: "/foo", Ep: tc.eps, Weights: tc.weights}}
+		h.assignServerSlots(httpSvc)
+		if err := h.apply(map[string][]service{"http": httpSvc}, false); err != nil {
+			t.Fatalf("%v: unexpected error: %v", tc.name, err)
+		}
+		discard, err := ioutil.ReadFile(reloaded)
+		if reload := err == nil; reload != tc.reload {
+			t.Errorf("%v: expected reload %v, got %v", tc.name, tc.reload, reload)
+		}
+		if len(discard) > 0 {
+			t.Errorf("%v: expected the server state to be kept", tc.name)
+		}
+		os.Remove(reloaded)
+		if cmds := socket.commands(); !reflect.DeepEqual(cmds, tc.cmds) {
+			t.Errorf("%v: expected commands %q, got %q", tc.name, tc.cmds, cmds)
+		}
+	}
+
+	// A failing command falls back to a reload without the stale state
+	socket.reply = "No such server.\n"
+	httpSvc := []service{{Name: "foo", Path: "/foo", Ep: []string{"2.2.2.2:80"}}}
+	h.assignServerSlots(httpSvc)
+	if err := h.apply(map[string][]service{"http": httpSvc}, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if discard, err := ioutil.ReadFile(reloaded); err != nil {
+		t.Errorf("Expected a reload after a failed command, got %v", err)
+	} else if string(discard) != "1" {
+		t.Errorf("Expected the server state to be discarded, got %q", discard)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -24,6 +25,7 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
@@ -33,13 +35,16 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/openshift/origin/pkg/util/proc"
+	"github.com/prometheus/client_golang/prometheus"
 	flag "github.com/spf13/pflag"
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/client/record"
 	"k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/pkg/controller/framework"
 	"k8s.io/kubernetes/pkg/fields"
 	kubectl_util "k8s.io/kubernetes/pkg/kubectl/cmd/util"
+	"k8s.io/kubernetes/pkg/labels"
 	"k8s.io/kubernetes/pkg/util"
 	"k8s.io/kubernetes/pkg/util/workqueue"
 )
@@ -51,6 +56,18 @@ const (
 	lbAlgorithmKey           = "serviceloadbalancer/lb.algorithm"
 	lbHostKey                = "serviceloadbalancer/lb.host"
 	lbCookieStickySessionKey = "serviceloadbalancer/lb.cookie-sticky-session"
+	lbSslTermSecretKey       = "serviceloadbalancer/lb.ssl-term-secret"
+	lbPathKey                = "serviceloadbalancer/lb.path"
+	lbPathRewriteKey         = "serviceloadbalancer/lb.path-rewrite"
+	lbHealthCheckPathKey     = "serviceloadbalancer/lb.health-check-path"
+	lbHealthCheckIntervalKey = "serviceloadbalancer/lb.health-check-interval"
+	lbHealthCheckRiseKey     = "serviceloadbalancer/lb.health-check-rise"
+	lbHealthCheckFallKey     = "serviceloadbalancer/lb.health-check-fall"
+	lbSlowStartKey           = "serviceloadbalancer/lb.slow-start"
+	lbWeightKey              = "serviceloadbalancer/lb.weight"
+	lbMaxConnKey             = "serviceloadbalancer/lb.max-conn"
+	lbRateLimitKey           = "serviceloadbalancer/lb.rate-limit"
+	lbConnRateLimitKey       = "serviceloadbalancer/lb.conn-rate-limit"
 	defaultErrorPage         = "file:///etc/haproxy/errors/404.http"
 )
 
@@ -63,14 +80,6 @@ var (
 	// Error used to indicate that a sync is deferred because the controller isn't ready yet
 	errDeferredSync = fmt.Errorf("deferring sync till endpoints controller has synced")
 
-	// See https://cbonte.github.io/haproxy-dconv/configuration-1.5.html#4.2-balance
-	// In brief:
-	//  * roundrobin: backend with the highest weight (how is this set?) receives new connection
-	//  * leastconn: backend with least connections receives new connection
-	//  * first: first server sorted by server id, with an available slot receives connection
-	//  * source: connection given to backend based on hash of source ip
-	supportedAlgorithms = []string{"roundrobin", "leastconn", "first", "source"}
-
 	config = flags.String("cfg", "loadbalancer.json", `path to load balancer json config.
 		Note that this is *not* the path to the configuration file for the load balancer
 		itself, but rather, the path to the json configuration of how you would like the
@@ -96,11 +105,32 @@ var (
 
 	tcpServices = flags.String("tcp-services", "", `Comma separated list of tcp/https
 		serviceName:servicePort pairings. This assumes you've opened up the right
-		hostPorts for each service that serves ingress traffic.`)
+		hostPorts for each service that serves ingress traffic. Services outside
+		the namespace of the loadbalancer are named namespace/serviceName.`)
+
+	udpServices = flags.String("udp-services", "", `Comma separated list of udp
+		serviceName:servicePort pairings, forwarded by the loadbalancer itself
+		since haproxy and nginx don't loadbalance udp. Every service port needs
+		a udp hostPort, and services outside the namespace of the loadbalancer
+		are named namespace/serviceName.`)
+
+	udpIdleTimeout = flags.Duration("udp-idle-timeout", time.Minute, `Time after
+		which a udp client that hasn't sent or received a datagram loses its
+		session with an endpoint.`)
 
 	targetService = flags.String(
 		"target-service", "", `Restrict loadbalancing to a single target service.`)
 
+	// Services in the namespace of the loadbalancer keep their plain names,
+	// services in other namespaces are routed as /namespace/name and their
+	// backends are named namespace_name, so they never collide.
+
+	allNamespaces = flags.Bool("all-namespaces", false, `Loadbalance services in all
+		namespaces, not just the namespace of the loadbalancer.`)
+
+	namespaceSelector = flags.String("namespace-selector", "", `Loadbalance services
+		in the namespaces matching this label selector. Implies --all-namespaces.`)
+
 	// ForwardServices == true:
 	// The lb just forwards packets to the vip of the service and we use
 	// kube-proxy's inbuilt load balancing. You get rules:
@@ -118,9 +148,28 @@ var (
 		instead of endpoints. This will use kube-proxy's inbuilt load balancing.`)
 
 	httpPort  = flags.Int("http-port", 80, `Port to expose http services.`)
+	httpsPort = flags.Int("https-port", 443, `Port to expose https services which
+		terminate ssl in the loadbalancer.`)
+	sslCertsDir = flags.String("ssl-certs-dir", "/etc/haproxy/certs", `Directory the
+		certificates from the secrets named in the `+lbSslTermSecretKey+`
+		annotation are written to. Anything else in it is removed.`)
 	statsPort = flags.Int("stats-port", 1936, `Port for loadbalancer stats,
 		Used in the loadbalancer liveness probe.`)
 
+	defaultMaxConn = flags.Int("default-max-conn", 0, `Connections to each endpoint
+		of a service without the lb.max-conn annotation, 0 for no limit.`)
+
+	defaultRateLimit = flags.Int("default-rate-limit", 0, `Requests per second each
+		client ip can send to an http service without the lb.rate-limit
+		annotation, 0 for no limit.`)
+
+	defaultConnRateLimit = flags.Int("default-conn-rate-limit", 0, `Connections per
+		second each client ip can open to a tcp service without the
+		lb.conn-rate-limit annotation, 0 for no limit.`)
+
+	serverSlots = flags.Int("server-slots", 10, `Backends get servers in multiples
+		of this, so endpoints can be added through the stats socket without a reload.`)
+
 	startSyslog = flags.Bool("syslog", false, `if set, it will start a syslog server
 		that will forward haproxy logs to stdout.`)
 
@@ -140,9 +189,23 @@ type service struct {
 	Name string
 	Ep   []string
 
+	// Path is the url prefix the service is routed on, /name or
+	// /namespace/name unless set with the lbPathKey annotation, plus the
+	// :port for services not on port 80.
+	Path       string
+	customPath bool
+
+	// RewritePath replaces the Path prefix of requests to the service, the
+	// default path is stripped. Empty if requests are passed on unchanged.
+	RewritePath string
+
+	// Servers are the slots of the backend Ep are assigned to.
+	Servers []serverSlot
+
 	// FrontendPort is the port that the loadbalancer listens on for traffic
-	// for this service. For http, it's always :80, for each tcp service it
-	// is the service port of any service matching a name in the tcpServices set.
+	// for this service. For http, it's always :80, for each tcp or udp service
+	// it is the service port of any service matching a name in the tcpServices
+	// or udpServices set.
 	FrontendPort int
 
 	// Host if not empty it will add a new haproxy acl to route traffic using the
@@ -167,6 +230,36 @@ type service struct {
 	// The name of the cookie is SERVERID
 	// This only can be used in http services
 	CookieStickySession bool
+
+	// SslCert is the path to the certificate used to terminate ssl for
+	// this service, from the secret in the lbSslTermSecretKey annotation.
+	// If set the service is also served on the https port, routed by the
+	// SNI host name. This only can be used in http services.
+	SslCert string
+	sslPem  []byte
+
+	// HealthCheck enables active health checks of the endpoints, nil if
+	// only the endpoints controller decides which endpoints get traffic.
+	HealthCheck *healthCheck
+
+	// SlowStart is the time in milliseconds over which the weight of a new
+	// or recovered endpoint ramps up to its full weight, 0 if it starts
+	// with its full weight.
+	SlowStart int64
+
+	// Weights of endpoints from the lbWeightKey annotation of their pod,
+	// endpoints missing from it have the default weight.
+	Weights map[string]int
+
+	// Limits on the traffic to the service.
+	Limits serviceLimits
+}
+
+// String formats the service without sslPem, which holds the private key.
+func (s service) String() string {
+	type plainService service
+	s.sslPem = nil
+	return fmt.Sprintf("%+v", plainService(s))
 }
 
 type serviceByName []service
@@ -189,8 +282,17 @@ type loadBalancerConfig struct {
 	Config         string `json:"config" description:"path to loadbalancers configuration file."`
 	Template       string `json:"template" description:"template for the load balancer config."`
 	Algorithm      string `json:"algorithm" description:"loadbalancing algorithm."`
+	StatsSocket    string `json:"statsSocket" description:"haproxy stats socket endpoint changes are applied through, instead of reloading."`
+	CheckCmd       string `json:"checkCmd" description:"command validating a config before it's used, the path of the config is appended."`
 	startSyslog    bool   `description:"indicates if the load balancer uses syslog."`
 	lbDefAlgorithm string `description:"custom default load balancer algorithm".`
+	statsPort      int    `description:"port the loadbalancer serves its stats on."`
+	serverSlots    int    `description:"multiple backends are given server slots in."`
+	httpsPort      int    `description:"port to expose https services on."`
+	sslCertsDir    string `description:"directory ssl certificates are written to."`
+	// stagedSslCertsDir is where the certificates of the config being
+	// written are staged, the check command sees them there.
+	stagedSslCertsDir string
 }
 
 type staticPageHandler struct {
@@ -216,6 +318,61 @@ func (s serviceAnnotations) getCookieStickySession() (string, bool) {
 	return val, ok
 }
 
+func (s serviceAnnotations) getSslTermSecret() (string, bool) {
+	val, ok := s[lbSslTermSecretKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getPath() (string, bool) {
+	val, ok := s[lbPathKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getPathRewrite() (string, bool) {
+	val, ok := s[lbPathRewriteKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getHealthCheckPath() (string, bool) {
+	val, ok := s[lbHealthCheckPathKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getHealthCheckInterval() (string, bool) {
+	val, ok := s[lbHealthCheckIntervalKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getHealthCheckRise() (string, bool) {
+	val, ok := s[lbHealthCheckRiseKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getHealthCheckFall() (string, bool) {
+	val, ok := s[lbHealthCheckFallKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getSlowStart() (string, bool) {
+	val, ok := s[lbSlowStartKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getMaxConn() (string, bool) {
+	val, ok := s[lbMaxConnKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getRateLimit() (string, bool) {
+	val, ok := s[lbRateLimitKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getConnRateLimit() (string, bool) {
+	val, ok := s[lbConnRateLimitKey]
+	return val, ok
+}
+
 // Get serves the error page
 func (s *staticPageHandler) Getfunc(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(404)
@@ -254,26 +411,113 @@ func (s *staticPageHandler) loadUrl(url string) error {
 	return nil
 }
 
-// write writes the configuration file, will write to stdout if dryRun == true
-func (cfg *loadBalancerConfig) write(services map[string][]service, dryRun bool) (err error) {
-	var w io.Writer
+// invalidConfigError is returned by write when the check command rejects the
+// rendered config.
+type invalidConfigError struct {
+	path   string
+	output string
+	err    error
+}
+
+func (e *invalidConfigError) Error() string {
+	return fmt.Sprintf("invalid config %v: %v -- %v", e.path, e.err, e.output)
+}
+
+// write writes the configuration file, will write to stdout if dryRun == true.
+// The config is rendered to a temporary file next to it, and only replaces it
+// if it changed and passes the check command. Rejected configs are kept in a
+// .rejected file. Returns true if the config was replaced.
+func (cfg *loadBalancerConfig) write(services map[string][]service, dryRun bool) (changed bool, err error) {
 	if dryRun {
-		w = os.Stdout
-	} else {
-		w, err = os.Create(cfg.Config)
+		return false, cfg.render(os.Stdout, services)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(cfg.Config), filepath.Base(cfg.Config)+".")
+	if err != nil {
+		return false, err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	err = cfg.render(f, services)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	rendered, err := ioutil.ReadFile(tmp)
+	if err != nil {
+		return false, err
+	}
+	if current, err := ioutil.ReadFile(cfg.Config); err == nil && bytes.Equal(current, rendered) {
+		return false, nil
+	}
+	if cfg.CheckCmd != "" {
+		checked := tmp
+		if cfg.stagedSslCertsDir != "" {
+			if checked, err = cfg.renderStaged(services); err != nil {
+				return false, err
+			}
+			defer os.Remove(checked)
+		}
+		output, err := exec.Command("sh", "-c", fmt.Sprintf("%v %v", cfg.CheckCmd, checked)).CombinedOutput()
 		if err != nil {
-			return
+			rejected := cfg.Config + ".rejected"
+			if renameErr := os.Rename(tmp, rejected); renameErr != nil {
+				glog.Errorf("Unable to keep rejected config: %v", renameErr)
+			}
+			return false, &invalidConfigError{path: rejected, output: string(output), err: err}
 		}
 	}
-	var t *template.Template
-	t, err = template.ParseFiles(cfg.Template)
+	if err := os.Chmod(tmp, 0644); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp, cfg.Config)
+}
+
+// renderStaged renders the config with the staged certificates to a
+// temporary file for the check command, and returns its path.
+func (cfg *loadBalancerConfig) renderStaged(services map[string][]service) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(cfg.Config), filepath.Base(cfg.Config)+".")
 	if err != nil {
-		return
+		return "", err
+	}
+	staged := *cfg
+	staged.sslCertsDir = cfg.stagedSslCertsDir
+	err = staged.render(f, withStagedSslCerts(services, cfg.stagedSslCertsDir))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// render executes the template with the given services.
+func (cfg *loadBalancerConfig) render(w io.Writer, services map[string][]service) error {
+	t, err := template.New(filepath.Base(cfg.Template)).Funcs(template.FuncMap{
+		"replace": strings.Replace,
+	}).ParseFiles(cfg.Template)
+	if err != nil {
+		return err
 	}
 
 	conf := make(map[string]interface{})
 	conf["startSyslog"] = strconv.FormatBool(cfg.startSyslog)
 	conf["services"] = services
+	rules := map[string][]lbRule{}
+	vhosts := map[string][]virtualHost{}
+	for _, kind := range []string{"http", "https"} {
+		rules[kind], _ = getRules(services[kind])
+		vhosts[kind] = getVirtualHosts(services[kind], rules[kind])
+	}
+	conf["rules"] = rules
+	conf["vhosts"] = vhosts
+	conf["httpsPort"] = cfg.httpsPort
+	conf["sslCertsDir"] = cfg.sslCertsDir
+	conf["statsPort"] = cfg.statsPort
 
 	// default load balancer algorithm is roundrobin
 	conf["defLbAlgorithm"] = lbDefAlgorithm
@@ -285,32 +529,63 @@ func (cfg *loadBalancerConfig) write(services map[string][]service, dryRun bool)
 }
 
 // reload reloads the loadbalancer using the reload cmd specified in the json manifest.
-func (cfg *loadBalancerConfig) reload() error {
-	output, err := exec.Command("sh", "-c", cfg.ReloadCmd).CombinedOutput()
+// env is added to the environment of the reload cmd.
+func (cfg *loadBalancerConfig) reload(env ...string) error {
+	cmd := exec.Command("sh", "-c", cfg.ReloadCmd)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	output, err := cmd.CombinedOutput()
 	msg := fmt.Sprintf("%v -- %v", cfg.Name, string(output))
 	if err != nil {
+		reloadErrors.Inc()
 		return fmt.Errorf("error restarting %v: %v", msg, err)
 	}
+	reloads.Inc()
 	glog.Infof(msg)
 	return nil
 }
 
 // loadBalancerController watches the kubernetes api and adds/removes services
-// from the loadbalancer, via loadBalancerConfig.
+// from the loadbalancer, via its backend.
 type loadBalancerController struct {
-	cfg               *loadBalancerConfig
-	queue             *workqueue.Type
-	client            *unversioned.Client
-	epController      *framework.Controller
-	svcController     *framework.Controller
-	svcLister         cache.StoreToServiceLister
-	epLister          cache.StoreToEndpointsLister
-	reloadRateLimiter util.RateLimiter
-	template          string
-	targetService     string
-	forwardServices   bool
-	tcpServices       map[string]int
-	httpPort          int
+	cfg              *loadBalancerConfig
+	backend          loadBalancerBackend
+	queue            *workqueue.Type
+	client           *unversioned.Client
+	epController     *framework.Controller
+	svcController    *framework.Controller
+	secretController *framework.Controller
+	nsController     *framework.Controller
+	podController    *framework.Controller
+	svcLister        cache.StoreToServiceLister
+	epLister         cache.StoreToEndpointsLister
+	secretStore      cache.Store
+	nsStore          cache.Store
+	podStore         cache.Store
+	template         string
+	targetService    string
+	forwardServices  bool
+	tcpServices      map[string]int
+	udpServices      map[string]int
+	udpProxier       *udpProxier
+	defaultLimits    serviceLimits
+	httpPort         int
+	sslCertsDir      string
+	// namespace of the loadbalancer, its services are routed without a
+	// /namespace prefix.
+	namespace string
+	// namespaceSelector restricts the namespaces services are loadbalanced
+	// in, nil if services in every watched namespace are.
+	namespaceSelector labels.Selector
+	// applyPending is true if the config on disk failed to apply.
+	applyPending bool
+	// reloadPending is true if the certificates changed since the proxy
+	// was last reloaded.
+	reloadPending bool
+	// recorder records events about the loadbalancer pod podRef.
+	recorder record.EventRecorder
+	podRef   *api.ObjectReference
 }
 
 // getEndpoints returns a list of <endpoint ip>:<port> for a given service/target port combination.
@@ -348,29 +623,73 @@ func (lbc *loadBalancerController) getEndpoints(
 	return
 }
 
+// getQualifiedServiceName returns the name of the service, qualified with
+// its namespace unless it's in the given namespace.
+func getQualifiedServiceName(s *api.Service, namespace string) string {
+	if s.Namespace == namespace {
+		return s.Name
+	}
+	return fmt.Sprintf("%v/%v", s.Namespace, s.Name)
+}
+
 // encapsulates all the hacky convenience type name modifications for lb rules.
 // - :80 services don't need a :80 postfix
-// - default ns should be accessible without /ns/name (when we have /ns support)
-func getServiceNameForLBRule(s *api.Service, servicePort int) string {
+// - the namespace of the lb is accessible without /ns/name
+func getServiceNameForLBRule(s *api.Service, servicePort int, namespace string) string {
+	name := getQualifiedServiceName(s, namespace)
 	if servicePort == 80 {
-		return s.Name
+		return name
+	}
+	return fmt.Sprintf("%v:%v", name, servicePort)
+}
+
+// getBackendName returns the haproxy backend name for the lb rule, which
+// can't contain a /.
+func getBackendName(rule string) string {
+	return strings.Replace(rule, "/", "_", -1)
+}
+
+// namespaceSelected returns true if services in the namespace should be
+// loadbalanced.
+func (lbc *loadBalancerController) namespaceSelected(namespace string) bool {
+	if lbc.namespaceSelector == nil {
+		return true
 	}
-	return fmt.Sprintf("%v:%v", s.Name, servicePort)
+	obj, exists, err := lbc.nsStore.GetByKey(namespace)
+	if err != nil || !exists {
+		return false
+	}
+	return lbc.namespaceSelector.Matches(labels.Set(obj.(*api.Namespace).Labels))
 }
 
 // getServices returns a list of services and their endpoints.
-func (lbc *loadBalancerController) getServices() (httpSvc []service, tcpSvc []service) {
+func (lbc *loadBalancerController) getServices() (httpSvc []service, tcpSvc []service, udpSvc []service) {
 	ep := []string{}
+	algorithms := lbc.backend.algorithms()
+	defAlgorithm := defaultAlgorithm(lbc.backend, lbc.cfg.lbDefAlgorithm)
 	services, _ := lbc.svcLister.List()
+	// The /namespace/ prefix of a namespace with services is reserved for
+	// them.
+	namespaces := map[string]bool{}
+	for _, s := range services.Items {
+		if s.Namespace != lbc.namespace && lbc.namespaceSelected(s.Namespace) {
+			namespaces[s.Namespace] = true
+		}
+	}
 	for _, s := range services.Items {
 		if s.Spec.Type == api.ServiceTypeLoadBalancer {
 			glog.Infof("Ignoring service %v, it already has a loadbalancer", s.Name)
 			continue
 		}
+		if !lbc.namespaceSelected(s.Namespace) {
+			glog.V(2).Infof("Ignoring service %v/%v, its namespace isn't selected", s.Namespace, s.Name)
+			continue
+		}
 		for _, servicePort := range s.Spec.Ports {
 			// TODO: headless services?
-			sName := s.Name
-			if servicePort.Protocol == api.ProtocolUDP ||
+			sName := getQualifiedServiceName(&s, lbc.namespace)
+			udp := servicePort.Protocol == api.ProtocolUDP
+			if port, ok := lbc.udpServices[sName]; udp && (!ok || port != servicePort.Port) ||
 				(lbc.targetService != "" && lbc.targetService != sName) {
 				glog.Infof("Ignoring %v: %+v", sName, servicePort)
 				continue
@@ -387,24 +706,69 @@ func (lbc *loadBalancerController) getServices() (httpSvc []service, tcpSvc []se
 					sName, servicePort)
 				continue
 			}
+			rule := getServiceNameForLBRule(&s, servicePort.Port, lbc.namespace)
 			newSvc := service{
-				Name: getServiceNameForLBRule(&s, servicePort.Port),
-				Ep:   ep,
+				Name:        getBackendName(rule),
+				Ep:          ep,
+				Path:        "/" + rule,
+				RewritePath: "/",
+			}
+
+			if val, ok := serviceAnnotations(s.ObjectMeta.Annotations).getPath(); ok {
+				if path, err := parsePath(val); err != nil {
+					glog.Errorf("Ignoring path of service %v: %v", sName, err)
+				} else if ns := pathNamespace(path); ns != s.Namespace && namespaces[ns] {
+					lbc.reportPathConflict("Ignoring path %v of service %v, /%v/ is reserved for the services in namespace %v",
+						path, sName, ns, ns)
+				} else {
+					if servicePort.Port != 80 {
+						path = fmt.Sprintf("%v:%v", path, servicePort.Port)
+					}
+					newSvc.Path = path
+					newSvc.customPath = true
+					newSvc.RewritePath = ""
+				}
+			}
+
+			if val, ok := serviceAnnotations(s.ObjectMeta.Annotations).getPathRewrite(); ok {
+				if rewrite, err := parsePathRewrite(val); err != nil {
+					glog.Errorf("Ignoring path rewrite of service %v: %v", sName, err)
+				} else {
+					newSvc.RewritePath = rewrite
+				}
 			}
 
 			if val, ok := serviceAnnotations(s.ObjectMeta.Annotations).getHost(); ok {
 				newSvc.Host = val
 			}
 
+			if check, err := parseHealthCheck(serviceAnnotations(s.ObjectMeta.Annotations)); err != nil {
+				glog.Errorf("Not checking the health of service %v: %v", sName, err)
+			} else {
+				newSvc.HealthCheck = check
+			}
+
+			if val, ok := serviceAnnotations(s.ObjectMeta.Annotations).getSlowStart(); ok {
+				if slowStart, err := parseMillis(val); err != nil {
+					glog.Errorf("Ignoring slow start of service %v: %v", sName, err)
+				} else {
+					newSvc.SlowStart = slowStart
+				}
+			}
+
+			if !lbc.forwardServices {
+				newSvc.Weights = endpointWeights(ep, lbc.getEndpointWeights(&s))
+			}
+
 			if val, ok := serviceAnnotations(s.ObjectMeta.Annotations).getAlgorithm(); ok {
-				for _, current := range supportedAlgorithms {
+				for _, current := range algorithms {
 					if val == current {
 						newSvc.Algorithm = val
 						break
 					}
 				}
 			} else {
-				newSvc.Algorithm = lbc.cfg.lbDefAlgorithm
+				newSvc.Algorithm = defAlgorithm
 			}
 
 			// By default sticky session is disabled
@@ -413,10 +777,15 @@ func (lbc *loadBalancerController) getServices() (httpSvc []service, tcpSvc []se
 				newSvc.SessionAffinity = true
 			}
 
-			if port, ok := lbc.tcpServices[sName]; ok && port == servicePort.Port {
+			if udp {
+				newSvc.FrontendPort = servicePort.Port
+				udpSvc = append(udpSvc, newSvc)
+			} else if port, ok := lbc.tcpServices[sName]; ok && port == servicePort.Port {
 				newSvc.FrontendPort = servicePort.Port
+				newSvc.Limits = lbc.getLimits(&s, sName, true)
 				tcpSvc = append(tcpSvc, newSvc)
 			} else {
+				newSvc.Limits = lbc.getLimits(&s, sName, false)
 				if val, ok := serviceAnnotations(s.ObjectMeta.Annotations).getCookieStickySession(); ok {
 					b, err := strconv.ParseBool(val)
 					if err == nil {
@@ -424,6 +793,16 @@ func (lbc *loadBalancerController) getServices() (httpSvc []service, tcpSvc []se
 					}
 				}
 
+				if secretName, ok := serviceAnnotations(s.ObjectMeta.Annotations).getSslTermSecret(); ok {
+					path, pem, err := lbc.getSslCert(&s, secretName)
+					if err != nil {
+						glog.Errorf("Not terminating ssl for service %v: %v", sName, err)
+					} else {
+						newSvc.SslCert = path
+						newSvc.sslPem = pem
+					}
+				}
+
 				newSvc.FrontendPort = lbc.httpPort
 				httpSvc = append(httpSvc, newSvc)
 			}
@@ -433,32 +812,112 @@ func (lbc *loadBalancerController) getServices() (httpSvc []service, tcpSvc []se
 
 	sort.Sort(serviceByName(httpSvc))
 	sort.Sort(serviceByName(tcpSvc))
+	sort.Sort(serviceByName(udpSvc))
 
 	return
 }
 
+// reportPathConflict logs a conflict between the paths of services, and
+// records it as an event of the loadbalancer pod.
+func (lbc *loadBalancerController) reportPathConflict(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	glog.Errorf("%v", msg)
+	if lbc.recorder != nil {
+		lbc.recorder.Eventf(lbc.podRef, "PathConflict", "%v", msg)
+	}
+}
+
+// getLimits returns the limits of a tcp or http service, the defaults if its
+// annotations are invalid.
+func (lbc *loadBalancerController) getLimits(s *api.Service, sName string, tcp bool) serviceLimits {
+	limits, err := parseLimits(serviceAnnotations(s.ObjectMeta.Annotations), lbc.defaultLimits, tcp)
+	if err != nil {
+		glog.Errorf("Using the default limits for service %v: %v", sName, err)
+	}
+	return limits
+}
+
 // sync all services with the loadbalancer.
 func (lbc *loadBalancerController) sync(dryRun bool) error {
-	if !lbc.epController.HasSynced() || !lbc.svcController.HasSynced() {
+	if !lbc.epController.HasSynced() || !lbc.svcController.HasSynced() || !lbc.secretController.HasSynced() ||
+		!lbc.podController.HasSynced() || (lbc.nsController != nil && !lbc.nsController.HasSynced()) {
 		time.Sleep(100 * time.Millisecond)
 		return errDeferredSync
 	}
-	httpSvc, tcpSvc := lbc.getServices()
+	return lbc.syncServices(dryRun)
+}
+
+// syncServices writes the config of the services in the stores, and applies
+// it if it or the certificates changed.
+func (lbc *loadBalancerController) syncServices(dryRun bool) error {
+	httpSvc, tcpSvc, udpSvc := lbc.getServices()
+	if !dryRun && lbc.udpProxier != nil {
+		// A udp port we can't listen on must not hold up the http and
+		// tcp services.
+		if err := lbc.udpProxier.sync(udpSvc); err != nil {
+			glog.Errorf("Unable to proxy every udp service: %v", err)
+		}
+	}
 	if len(httpSvc) == 0 && len(tcpSvc) == 0 {
 		return nil
 	}
-	if err := lbc.cfg.write(
-		map[string][]service{
-			"http": httpSvc,
-			"tcp":  tcpSvc,
-		}, dryRun); err != nil {
+	_, conflicts := getRules(httpSvc)
+	for _, conflict := range conflicts {
+		lbc.reportPathConflict("Ignoring %v", conflict)
+	}
+	httpsSvc := sslTermServices(httpSvc)
+	staging := ""
+	if !dryRun {
+		var err error
+		if staging, err = stageSslCerts(lbc.sslCertsDir, httpsSvc); err != nil {
+			return err
+		}
+		defer os.RemoveAll(staging)
+	}
+	services := map[string][]service{
+		"http":  httpSvc,
+		"https": httpsSvc,
+		"tcp":   tcpSvc,
+	}
+	lbc.cfg.stagedSslCertsDir = staging
+	changed, err := lbc.backend.write(services, dryRun)
+	lbc.cfg.stagedSslCertsDir = ""
+	if invalid, ok := err.(*invalidConfigError); ok {
+		// Retrying can't fix the config, so wait for the next change and
+		// keep running with the last good config.
+		configValidationErrors.Inc()
+		glog.Errorf("Keeping the last good config: %v", invalid)
+		if lbc.recorder != nil {
+			lbc.recorder.Eventf(lbc.podRef, "InvalidConfig", "Kept the last good config, see %v: %v", invalid.path, invalid.output)
+		}
+		return nil
+	}
+	if err != nil {
 		return err
 	}
-	if dryRun {
+	// Certificates can change without the config, so they are swapped in
+	// whenever the config is good, and reloaded if they changed.
+	if !dryRun {
+		certsChanged, err := commitSslCerts(lbc.sslCertsDir, staging)
+		if certsChanged {
+			lbc.reloadPending = true
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if dryRun || (!changed && !lbc.applyPending && !lbc.reloadPending) {
 		return nil
 	}
-	lbc.reloadRateLimiter.Accept()
-	return lbc.cfg.reload()
+	// A config that failed to apply is still applied on the next sync,
+	// even though it's unchanged by then.
+	lbc.applyPending = true
+	if err := lbc.backend.apply(services, lbc.reloadPending); err != nil {
+		return err
+	}
+	lbc.applyPending = false
+	lbc.reloadPending = false
+	return nil
 }
 
 // worker handles the work queue.
@@ -474,34 +933,65 @@ func (lbc *loadBalancerController) worker() {
 	}
 }
 
-// newLoadBalancerController creates a new controller from the given config.
-func newLoadBalancerController(cfg *loadBalancerConfig, kubeClient *unversioned.Client, namespace string) *loadBalancerController {
-
-	lbc := loadBalancerController{
-		cfg:    cfg,
-		client: kubeClient,
-		queue:  workqueue.New(),
-		reloadRateLimiter: util.NewTokenBucketRateLimiter(
-			reloadQPS, int(reloadQPS)),
-		targetService:   *targetService,
-		forwardServices: *forwardServices,
-		httpPort:        *httpPort,
-		tcpServices:     map[string]int{},
+// parseServicePorts parses a comma separated list of serviceName:servicePort
+// pairings, ignoring misconfigured ones.
+func parseServicePorts(protocol, services string) map[string]int {
+	servicePorts := map[string]int{}
+	if services == "" {
+		return servicePorts
 	}
-
-	for _, service := range strings.Split(*tcpServices, ",") {
+	for _, service := range strings.Split(services, ",") {
 		portSplit := strings.Split(service, ":")
-		if len(portSplit) != 2 {
-			glog.Errorf("Ignoring misconfigured TCP service %v", service)
+		if len(portSplit) != 2 || strings.Count(portSplit[0], "/") > 1 {
+			glog.Errorf("Ignoring misconfigured %v service %v", protocol, service)
 			continue
 		}
 		if port, err := strconv.Atoi(portSplit[1]); err != nil {
-			glog.Errorf("Ignoring misconfigured TCP service %v: %v", service, err)
+			glog.Errorf("Ignoring misconfigured %v service %v: %v", protocol, service, err)
 			continue
 		} else {
-			lbc.tcpServices[portSplit[0]] = port
+			servicePorts[portSplit[0]] = port
 		}
 	}
+	return servicePorts
+}
+
+// newLoadBalancerController creates a new controller from the given config.
+// Services are watched in all namespaces if watchNamespace is
+// api.NamespaceAll, and restricted to those matching nsSelector if not nil.
+func newLoadBalancerController(cfg *loadBalancerConfig, backend loadBalancerBackend, kubeClient *unversioned.Client, namespace, watchNamespace string, nsSelector labels.Selector) *loadBalancerController {
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartRecordingToSink(kubeClient.Events(""))
+	// The hostname of a pod is its name
+	podName, err := os.Hostname()
+	if err != nil {
+		glog.Errorf("Unable to get the name of the pod for events: %v", err)
+	}
+
+	lbc := loadBalancerController{
+		cfg:             cfg,
+		backend:         backend,
+		client:          kubeClient,
+		queue:           workqueue.New(),
+		targetService:   *targetService,
+		forwardServices: *forwardServices,
+		httpPort:        *httpPort,
+		sslCertsDir:     *sslCertsDir,
+		tcpServices:     parseServicePorts("TCP", *tcpServices),
+		udpServices:     parseServicePorts("UDP", *udpServices),
+		udpProxier:      newUDPProxier("", *udpIdleTimeout),
+		namespace:       namespace,
+		defaultLimits: serviceLimits{
+			MaxConn:       *defaultMaxConn,
+			RateLimit:     *defaultRateLimit,
+			ConnRateLimit: *defaultConnRateLimit,
+		},
+		recorder: eventBroadcaster.NewRecorder(
+			api.EventSource{Component: "service-loadbalancer"}),
+		podRef: &api.ObjectReference{Kind: "Pod", Namespace: namespace, Name: podName},
+	}
+
 	enqueue := func(obj interface{}) {
 		key, err := keyFunc(obj)
 		if err != nil {
@@ -522,14 +1012,70 @@ func newLoadBalancerController(cfg *loadBalancerConfig, kubeClient *unversioned.
 
 	lbc.svcLister.Store, lbc.svcController = framework.NewInformer(
 		cache.NewListWatchFromClient(
-			lbc.client, "services", namespace, fields.Everything()),
+			lbc.client, "services", watchNamespace, fields.Everything()),
 		&api.Service{}, resyncPeriod, eventHandlers)
 
 	lbc.epLister.Store, lbc.epController = framework.NewInformer(
 		cache.NewListWatchFromClient(
-			lbc.client, "endpoints", namespace, fields.Everything()),
+			lbc.client, "endpoints", watchNamespace, fields.Everything()),
 		&api.Endpoints{}, resyncPeriod, eventHandlers)
 
+	// Only secrets which could be used to terminate ssl trigger a sync
+	secretHandlers := framework.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if isTLSSecret(obj.(*api.Secret)) {
+				enqueue(obj)
+			}
+		},
+		DeleteFunc: enqueue,
+		UpdateFunc: func(old, cur interface{}) {
+			if !reflect.DeepEqual(old, cur) && (isTLSSecret(old.(*api.Secret)) || isTLSSecret(cur.(*api.Secret))) {
+				enqueue(cur)
+			}
+		},
+	}
+	lbc.secretStore, lbc.secretController = framework.NewInformer(
+		cache.NewListWatchFromClient(
+			lbc.client, "secrets", watchNamespace, fields.Everything()),
+		&api.Secret{}, resyncPeriod, secretHandlers)
+
+	// Only pods weighting their endpoints trigger a sync, endpoints coming
+	// and going trigger one through the endpoints.
+	podHandlers := framework.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if hasWeightAnnotation(obj.(*api.Pod)) {
+				enqueue(obj)
+			}
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			if old.(*api.Pod).Annotations[lbWeightKey] != cur.(*api.Pod).Annotations[lbWeightKey] {
+				enqueue(cur)
+			}
+		},
+	}
+	lbc.podStore, lbc.podController = framework.NewInformer(
+		cache.NewListWatchFromClient(
+			lbc.client, "pods", watchNamespace, fields.Everything()),
+		&api.Pod{}, resyncPeriod, podHandlers)
+
+	if nsSelector != nil {
+		// Namespaces coming in or out of the selector add or remove services
+		nsHandlers := framework.ResourceEventHandlerFuncs{
+			AddFunc:    enqueue,
+			DeleteFunc: enqueue,
+			UpdateFunc: func(old, cur interface{}) {
+				if !reflect.DeepEqual(old.(*api.Namespace).Labels, cur.(*api.Namespace).Labels) {
+					enqueue(cur)
+				}
+			},
+		}
+		lbc.namespaceSelector = nsSelector
+		lbc.nsStore, lbc.nsController = framework.NewInformer(
+			cache.NewListWatchFromClient(
+				lbc.client, "namespaces", api.NamespaceAll, fields.Everything()),
+			&api.Namespace{}, resyncPeriod, nsHandlers)
+	}
+
 	return &lbc
 }
 
@@ -552,30 +1098,30 @@ func parseCfg(configPath string, defLbAlgorithm string) *loadBalancerConfig {
 }
 
 // registerHandlers  services liveness probes.
-func registerHandlers(s *staticPageHandler) {
+func registerHandlers(s *staticPageHandler, backend loadBalancerBackend) {
 	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		// Delegate a check to the haproxy stats service.
-		response, err := http.Get(fmt.Sprintf("http://localhost:%v", *statsPort))
-		if err != nil {
+		// Delegate a check to the backend.
+		if err := backend.healthz(); err != nil {
 			glog.Infof("Error %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 		} else {
-			defer response.Body.Close()
-			if response.StatusCode != http.StatusOK {
-				contents, err := ioutil.ReadAll(response.Body)
-				if err != nil {
-					glog.Infof("Error reading resonse on receiving status %v: %v",
-						response.StatusCode, err)
-				}
-				glog.Infof("%v\n", string(contents))
-				w.WriteHeader(response.StatusCode)
-			} else {
-				w.WriteHeader(200)
-				w.Write([]byte("ok"))
-			}
+			w.WriteHeader(200)
+			w.Write([]byte("ok"))
+		}
+	})
+
+	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := backend.stats()
+		if err != nil {
+			glog.Infof("Error %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
+		w.Write(stats)
 	})
 
+	http.Handle("/metrics", prometheus.Handler())
+
 	// handler for not matched traffic
 	http.HandleFunc("/", s.Getfunc)
 
@@ -595,19 +1141,32 @@ func main() {
 	clientConfig := kubectl_util.DefaultClientConfig(flags)
 	flags.Parse(os.Args)
 	cfg := parseCfg(*config, *lbDefAlgorithm)
+	cfg.httpsPort = *httpsPort
+	cfg.sslCertsDir = *sslCertsDir
+	cfg.statsPort = *statsPort
+	cfg.serverSlots = *serverSlots
+	backend, err := newBackend(cfg, util.NewTokenBucketRateLimiter(reloadQPS, int(reloadQPS)))
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
 	if len(*tcpServices) == 0 {
 		glog.Infof("All tcp/https services will be ignored.")
 	}
+	if len(*udpServices) == 0 {
+		glog.Infof("All udp services will be ignored.")
+	}
+	if *defaultMaxConn < 0 || *defaultRateLimit < 0 || *defaultConnRateLimit < 0 {
+		glog.Fatalf("Default limits can't be negative, use 0 for no limit")
+	}
 
 	var kubeClient *unversioned.Client
-	var err error
 
 	defErrorPage := newStaticPageHandler(*errorPage, defaultErrorPage)
 	if defErrorPage == nil {
 		glog.Fatalf("Failed to load the default error page")
 	}
 
-	go registerHandlers(defErrorPage)
+	go registerHandlers(defErrorPage, backend)
 
 	proc.StartReaper()
 
@@ -638,10 +1197,25 @@ func main() {
 		namespace = "default"
 	}
 
-	// TODO: Handle multiple namespaces
-	lbc := newLoadBalancerController(cfg, kubeClient, namespace)
+	watchNamespace := namespace
+	var nsSelector labels.Selector
+	if *namespaceSelector != "" {
+		if nsSelector, err = labels.Parse(*namespaceSelector); err != nil {
+			glog.Fatalf("Invalid namespace selector %v: %v", *namespaceSelector, err)
+		}
+		watchNamespace = api.NamespaceAll
+	} else if *allNamespaces {
+		watchNamespace = api.NamespaceAll
+	}
+
+	lbc := newLoadBalancerController(cfg, backend, kubeClient, namespace, watchNamespace, nsSelector)
 	go lbc.epController.Run(util.NeverStop)
 	go lbc.svcController.Run(util.NeverStop)
+	go lbc.secretController.Run(util.NeverStop)
+	go lbc.podController.Run(util.NeverStop)
+	if lbc.nsController != nil {
+		go lbc.nsController.Run(util.NeverStop)
+	}
 	if *dry {
 		dryRun(lbc)
 	} else {
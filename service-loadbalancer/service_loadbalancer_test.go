@@ -22,10 +22,12 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/labels"
 	"k8s.io/kubernetes/pkg/util"
 	"k8s.io/kubernetes/pkg/util/sets"
 
@@ -88,6 +90,7 @@ func newFakeLoadBalancerController(endpoints []*api.Endpoints, services []*api.S
 	flb.epLister.Store = storeEps(endpoints)
 	flb.svcLister.Store = storeServices(services)
 	flb.httpPort = 80
+	flb.namespace = ns
 	return &flb
 }
 
@@ -157,10 +160,11 @@ func TestGetServices(t *testing.T) {
 	flb := newFakeLoadBalancerController(endpoints, []*api.Service{svc1, svc2})
 	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
 	flb.cfg = parseCfg(cfg, "roundrobin")
+	flb.backend = newHAProxyBackend(flb.cfg, util.NewFakeRateLimiter())
 	flb.tcpServices = map[string]int{
 		svc1.Name: 20,
 	}
-	http, tcp := flb.getServices()
+	http, tcp, _ := flb.getServices()
 	serviceURLEp := fmt.Sprintf("%v:%v", svc1.Name, 20)
 	if len(tcp) != 1 || tcp[0].Name != serviceURLEp || tcp[0].FrontendPort != 20 {
 		t.Fatalf("Unexpected tcp service %+v expected %+v", tcp, svc1.Name)
@@ -203,6 +207,147 @@ func TestGetServices(t *testing.T) {
 	}
 }
 
+func TestGetServicesMultipleNamespaces(t *testing.T) {
+	endpointAddresses := []api.EndpointAddress{{IP: "1.2.3.4"}}
+	endpointPorts := []api.EndpointPort{{Port: 80, Protocol: "TCP"}}
+	servicePorts := []api.ServicePort{
+		{Port: 80, TargetPort: util.NewIntOrStringFromInt(80)},
+		{Port: 20, TargetPort: util.NewIntOrStringFromInt(80)},
+	}
+
+	// A service named foo in the namespace of the loadbalancer, and in two
+	// other namespaces of which only team-a is labeled.
+	services := []*api.Service{}
+	endpoints := []*api.Endpoints{}
+	for _, namespace := range []string{ns, "team-a", "team-b"} {
+		svc := getService(servicePorts)
+		svc.ObjectMeta.Name = "foo"
+		svc.ObjectMeta.Namespace = namespace
+		services = append(services, svc)
+		endpoints = append(endpoints, getEndpoints(svc, endpointAddresses, endpointPorts))
+	}
+	namespaces := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, namespace := range []*api.Namespace{
+		{ObjectMeta: api.ObjectMeta{Name: ns}},
+		{ObjectMeta: api.ObjectMeta{Name: "team-a", Labels: map[string]string{"lb": "true"}}},
+		{ObjectMeta: api.ObjectMeta{Name: "team-b"}},
+	} {
+		namespaces.Add(namespace)
+	}
+	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
+
+	testCases := []struct {
+		selector string
+		http     map[string]string
+		tcp      map[string]string
+	}{
+		{
+			selector: "",
+			http: map[string]string{
+				"foo":           "/foo",
+				"team-a_foo":    "/team-a/foo",
+				"team-b_foo":    "/team-b/foo",
+				"team-b_foo:20": "/team-b/foo:20",
+			},
+			tcp: map[string]string{
+				"foo:20":        "/foo:20",
+				"team-a_foo:20": "/team-a/foo:20",
+			},
+		},
+		{
+			selector: "lb=true",
+			http: map[string]string{
+				"team-a_foo": "/team-a/foo",
+			},
+			tcp: map[string]string{
+				"team-a_foo:20": "/team-a/foo:20",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		flb := newFakeLoadBalancerController(endpoints, services)
+		flb.cfg = parseCfg(cfg, "roundrobin")
+		flb.backend = newHAProxyBackend(flb.cfg, util.NewFakeRateLimiter())
+		flb.tcpServices = map[string]int{
+			"foo":        20,
+			"team-a/foo": 20,
+		}
+		if tc.selector != "" {
+			selector, err := labels.Parse(tc.selector)
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %v: %v", tc.selector, err)
+			}
+			flb.namespaceSelector = selector
+			flb.nsStore = namespaces
+		}
+		http, tcp, _ := flb.getServices()
+		for _, svcs := range []struct {
+			got      []service
+			expected map[string]string
+		}{{http, tc.http}, {tcp, tc.tcp}} {
+			got := map[string]string{}
+			for _, svc := range svcs.got {
+				got[svc.Name] = svc.Path
+			}
+			if !reflect.DeepEqual(got, svcs.expected) {
+				t.Errorf("%q: expected services %v, got %v", tc.selector, svcs.expected, got)
+			}
+		}
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("Unable to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	flb := buildTestLoadBalancer("")
+	httpSvc, tcpSvc, _ := flb.getServices()
+	services := map[string][]service{
+		"http": httpSvc,
+		"tcp":  tcpSvc,
+	}
+	flb.cfg.Config = filepath.Join(dir, "haproxy.cfg")
+	rejected := flb.cfg.Config + ".rejected"
+
+	testCases := []struct {
+		name     string
+		checkCmd string
+		changed  bool
+		invalid  bool
+	}{
+		{"new config", "test -s", true, false},
+		{"unchanged config", "false", false, false},
+	}
+	for _, tc := range testCases {
+		flb.cfg.CheckCmd = tc.checkCmd
+		changed, err := flb.backend.write(services, false)
+		if _, invalid := err.(*invalidConfigError); changed != tc.changed || invalid != tc.invalid || (err != nil && !invalid) {
+			t.Errorf("%v: expected changed %v invalid %v, got %v, %v", tc.name, tc.changed, tc.invalid, changed, err)
+		}
+	}
+
+	// An invalid config leaves the last good one in place
+	good, _ := ioutil.ReadFile(flb.cfg.Config)
+	flb.cfg.CheckCmd = "false"
+	httpSvc[0].Algorithm = "leastconn"
+	changed, err := flb.backend.write(services, false)
+	if _, ok := err.(*invalidConfigError); changed || !ok {
+		t.Fatalf("Expected an invalid config, got %v, %v", changed, err)
+	}
+	if current, _ := ioutil.ReadFile(flb.cfg.Config); !bytes.Equal(current, good) {
+		t.Errorf("Expected the last good config to be kept")
+	}
+	if _, err := os.Stat(rejected); err != nil {
+		t.Errorf("Expected the rejected config to be kept: %v", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+		t.Errorf("Expected only the config and the rejected config, got %v files", len(files))
+	}
+}
+
 func TestNewStaticPageHandler(t *testing.T) {
 	defPagePath, _ := filepath.Abs("haproxy.cfg")
 	defErrorPath, _ := filepath.Abs("template.cfg")
@@ -258,15 +403,11 @@ func buildTestLoadBalancer(lbDefAlgorithm string) *loadBalancerController {
 		getEndpoints(svc2, endpointAddresses, endpointPorts),
 	}
 	flb := newFakeLoadBalancerController(endpoints, []*api.Service{svc1, svc2})
-	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
 	// do not have the input parameters. We need to specify a default.
 	if lbDefAlgorithm == "" {
 		lbDefAlgorithm = "roundrobin"
 	}
-
-	flb.cfg = parseCfg(cfg, lbDefAlgorithm)
-	cfgFile, _ := filepath.Abs("test-" + string(util.NewUUID()))
-	flb.cfg.Config = cfgFile
+	useTestConfig(flb, lbDefAlgorithm)
 	flb.tcpServices = map[string]int{
 		svc1.Name: 20,
 	}
@@ -274,6 +415,16 @@ func buildTestLoadBalancer(lbDefAlgorithm string) *loadBalancerController {
 	return flb
 }
 
+// useTestConfig gives a fake controller the test haproxy config, written to
+// a new file in the current directory.
+func useTestConfig(flb *loadBalancerController, lbDefAlgorithm string) {
+	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
+	flb.cfg = parseCfg(cfg, lbDefAlgorithm)
+	flb.backend = newHAProxyBackend(flb.cfg, util.NewFakeRateLimiter())
+	cfgFile, _ := filepath.Abs("test-" + string(util.NewUUID()))
+	flb.cfg.Config = cfgFile
+}
+
 // compareCfgFiles check that two files are equals
 func compareCfgFiles(t *testing.T, orig, template string) {
 	f1, err := ioutil.ReadFile(orig)
@@ -292,8 +443,8 @@ func compareCfgFiles(t *testing.T, orig, template string) {
 
 func TestDefaultAlgorithm(t *testing.T) {
 	flb := buildTestLoadBalancer("")
-	httpSvc, tcpSvc := flb.getServices()
-	if err := flb.cfg.write(
+	httpSvc, tcpSvc, _ := flb.getServices()
+	if _, err := flb.backend.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -307,8 +458,8 @@ func TestDefaultAlgorithm(t *testing.T) {
 
 func TestDefaultCustomAlgorithm(t *testing.T) {
 	flb := buildTestLoadBalancer("leastconn")
-	httpSvc, tcpSvc := flb.getServices()
-	if err := flb.cfg.write(
+	httpSvc, tcpSvc, _ := flb.getServices()
+	if _, err := flb.backend.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -322,9 +473,9 @@ func TestDefaultCustomAlgorithm(t *testing.T) {
 
 func TestSyslog(t *testing.T) {
 	flb := buildTestLoadBalancer("")
-	httpSvc, tcpSvc := flb.getServices()
+	httpSvc, tcpSvc, _ := flb.getServices()
 	flb.cfg.startSyslog = true
-	if err := flb.cfg.write(
+	if _, err := flb.backend.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -338,9 +489,9 @@ func TestSyslog(t *testing.T) {
 
 func TestSvcCustomAlgorithm(t *testing.T) {
 	flb := buildTestLoadBalancer("")
-	httpSvc, tcpSvc := flb.getServices()
+	httpSvc, tcpSvc, _ := flb.getServices()
 	httpSvc[0].Algorithm = "leastconn"
-	if err := flb.cfg.write(
+	if _, err := flb.backend.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -354,9 +505,9 @@ func TestSvcCustomAlgorithm(t *testing.T) {
 
 func TestCustomDefaultAndSvcAlgorithm(t *testing.T) {
 	flb := buildTestLoadBalancer("leastconn")
-	httpSvc, tcpSvc := flb.getServices()
+	httpSvc, tcpSvc, _ := flb.getServices()
 	httpSvc[0].Algorithm = "roundrobin"
-	if err := flb.cfg.write(
+	if _, err := flb.backend.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -370,9 +521,9 @@ func TestCustomDefaultAndSvcAlgorithm(t *testing.T) {
 
 func TestServiceAffinity(t *testing.T) {
 	flb := buildTestLoadBalancer("")
-	httpSvc, tcpSvc := flb.getServices()
+	httpSvc, tcpSvc, _ := flb.getServices()
 	httpSvc[0].SessionAffinity = true
-	if err := flb.cfg.write(
+	if _, err := flb.backend.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -386,10 +537,10 @@ func TestServiceAffinity(t *testing.T) {
 
 func TestServiceAffinityWithCookies(t *testing.T) {
 	flb := buildTestLoadBalancer("")
-	httpSvc, tcpSvc := flb.getServices()
+	httpSvc, tcpSvc, _ := flb.getServices()
 	httpSvc[0].SessionAffinity = true
 	httpSvc[0].CookieStickySession = true
-	if err := flb.cfg.write(
+	if _, err := flb.backend.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -0,0 +1,171 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	// Keys of the certificate and private key in a tls secret, both PEM
+	// encoded.
+	tlsCertKey = "tls.crt"
+	tlsKeyKey  = "tls.key"
+)
+
+// isTLSSecret returns true if the secret holds a certificate and key.
+func isTLSSecret(secret *api.Secret) bool {
+	_, hasCert := secret.Data[tlsCertKey]
+	_, hasKey := secret.Data[tlsKeyKey]
+	return hasCert && hasKey
+}
+
+// getSslCert returns the path the certificate for the service will be written
+// to, and the PEM encoded certificate followed by its key, as haproxy wants
+// them. The secret must be in the namespace of the service.
+func (lbc *loadBalancerController) getSslCert(s *api.Service, secretName string) (string, []byte, error) {
+	if lbc.secretStore == nil {
+		return "", nil, fmt.Errorf("secrets are not being watched")
+	}
+	key := fmt.Sprintf("%v/%v", s.Namespace, secretName)
+	obj, exists, err := lbc.secretStore.GetByKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	if !exists {
+		return "", nil, fmt.Errorf("secret %v does not exist", key)
+	}
+	secret := obj.(*api.Secret)
+	if !isTLSSecret(secret) {
+		return "", nil, fmt.Errorf("secret %v must contain %v and %v", key, tlsCertKey, tlsKeyKey)
+	}
+	cert := secret.Data[tlsCertKey]
+	privKey := secret.Data[tlsKeyKey]
+	// haproxy refuses to start with a bad certificate, taking down every
+	// service, so check it first.
+	if _, err := tls.X509KeyPair(cert, privKey); err != nil {
+		return "", nil, fmt.Errorf("secret %v has an invalid certificate: %v", key, err)
+	}
+	pem := append(append([]byte{}, cert...), '\n')
+	pem = append(pem, privKey...)
+	path := filepath.Join(lbc.sslCertsDir, fmt.Sprintf("%v_%v.pem", s.Namespace, secretName))
+	return path, pem, nil
+}
+
+// stageSslCerts writes out the certificates of the services which terminate
+// tls to a staging directory next to dir, so the config can be checked with
+// them before they replace the certificates in use. Returns the staging
+// directory.
+func stageSslCerts(dir string, services []service) (string, error) {
+	staging := filepath.Clean(dir) + ".staging"
+	if err := os.RemoveAll(staging); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(staging, 0700); err != nil {
+		return "", err
+	}
+	written := map[string]bool{}
+	for _, svc := range services {
+		if svc.SslCert == "" || written[svc.SslCert] {
+			continue
+		}
+		written[svc.SslCert] = true
+		if err := ioutil.WriteFile(filepath.Join(staging, filepath.Base(svc.SslCert)), svc.sslPem, 0600); err != nil {
+			return "", err
+		}
+	}
+	return staging, nil
+}
+
+// commitSslCerts moves the staged certificates into dir, and removes any
+// certificates no longer in use. Returns whether any certificate changed,
+// the proxy only reads them when it's reloaded.
+func commitSslCerts(dir, staging string) (bool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return false, err
+	}
+	staged, err := ioutil.ReadDir(staging)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	inUse := map[string]bool{}
+	for _, f := range staged {
+		inUse[f.Name()] = true
+		stagedPath := filepath.Join(staging, f.Name())
+		path := filepath.Join(dir, f.Name())
+		if !changed {
+			current, err := ioutil.ReadFile(path)
+			pem, stagedErr := ioutil.ReadFile(stagedPath)
+			changed = err != nil || stagedErr != nil || !bytes.Equal(current, pem)
+		}
+		if err := os.Rename(stagedPath, path); err != nil {
+			return changed, err
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return changed, err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".pem") || inUse[f.Name()] {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		glog.Infof("Removing unused certificate %v", path)
+		if err := os.Remove(path); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, os.RemoveAll(staging)
+}
+
+// withStagedSslCerts returns the services with their certificates in the
+// staging directory.
+func withStagedSslCerts(services map[string][]service, staging string) map[string][]service {
+	out := map[string][]service{}
+	for kind, svcs := range services {
+		for _, svc := range svcs {
+			if svc.SslCert != "" {
+				svc.SslCert = filepath.Join(staging, filepath.Base(svc.SslCert))
+			}
+			out[kind] = append(out[kind], svc)
+		}
+	}
+	return out
+}
+
+// sslTermServices returns the services which terminate tls.
+func sslTermServices(httpSvc []service) []service {
+	out := []service{}
+	for _, svc := range httpSvc {
+		if svc.SslCert != "" {
+			out = append(out, svc)
+		}
+	}
+	return out
+}
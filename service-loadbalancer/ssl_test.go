@@ -0,0 +1,285 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/util"
+)
+
+// newTLSSecret returns a secret with a self signed certificate for host.
+func newTLSSecret(t *testing.T, name, host string) *api.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unable to marshal key: %v", err)
+	}
+	return &api.Secret{
+		ObjectMeta: api.ObjectMeta{Name: name, Namespace: ns},
+		Data: map[string][]byte{
+			tlsCertKey: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			tlsKeyKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		},
+	}
+}
+
+// buildSslTestLoadBalancer returns a loadbalancer with an http service svc-1
+// terminating ssl with the given secret, and an http service svc-2 which
+// does not.
+func buildSslTestLoadBalancer(t *testing.T, secretName string, secrets ...*api.Secret) *loadBalancerController {
+	endpointAddresses := []api.EndpointAddress{{IP: "1.2.3.4"}}
+	endpointPorts := []api.EndpointPort{{Port: 80, Protocol: "TCP"}}
+	servicePorts := []api.ServicePort{
+		{Port: 10, TargetPort: util.NewIntOrStringFromInt(80)},
+	}
+
+	svc1 := getService(servicePorts)
+	svc1.ObjectMeta.Name = "svc-1"
+	svc1.ObjectMeta.Annotations = map[string]string{
+		lbHostKey:          "foo.example.com",
+		lbSslTermSecretKey: secretName,
+	}
+	svc2 := getService(servicePorts)
+	svc2.ObjectMeta.Name = "svc-2"
+	endpoints := []*api.Endpoints{
+		getEndpoints(svc1, endpointAddresses, endpointPorts),
+		getEndpoints(svc2, endpointAddresses, endpointPorts),
+	}
+	flb := newFakeLoadBalancerController(endpoints, []*api.Service{svc1, svc2})
+	flb.sslCertsDir = "/etc/haproxy/certs"
+	flb.secretStore = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, secret := range secrets {
+		if err := flb.secretStore.Add(secret); err != nil {
+			t.Fatalf("Unable to add secret: %v", err)
+		}
+	}
+	useTestConfig(flb, "roundrobin")
+	flb.cfg.httpsPort = 443
+	flb.cfg.sslCertsDir = flb.sslCertsDir
+	return flb
+}
+
+func TestGetServicesSslTermination(t *testing.T) {
+	badSecret := &api.Secret{
+		ObjectMeta: api.ObjectMeta{Name: "bad", Namespace: ns},
+		Data: map[string][]byte{
+			tlsCertKey: []byte("not a certificate"),
+			tlsKeyKey:  []byte("not a key"),
+		},
+	}
+	tokenSecret := &api.Secret{
+		ObjectMeta: api.ObjectMeta{Name: "token", Namespace: ns},
+		Data:       map[string][]byte{"token": []byte("abc")},
+	}
+	testCases := []struct {
+		secretName string
+		expected   string
+	}{
+		{"foo-tls", "/etc/haproxy/certs/default_foo-tls.pem"},
+		{"missing", ""},
+		{"bad", ""},
+		{"token", ""},
+	}
+	for _, tc := range testCases {
+		flb := buildSslTestLoadBalancer(t, tc.secretName,
+			newTLSSecret(t, "foo-tls", "foo.example.com"), badSecret, tokenSecret)
+		httpSvc, _, _ := flb.getServices()
+		for _, svc := range httpSvc {
+			expected := ""
+			if svc.Name == "svc-1:10" {
+				expected = tc.expected
+			}
+			if svc.SslCert != expected {
+				t.Errorf("%v: expected service %v to use certificate %q, got %q", tc.secretName, svc.Name, expected, svc.SslCert)
+			}
+			if expected != "" && len(svc.sslPem) == 0 {
+				t.Errorf("%v: expected service %v to have a certificate", tc.secretName, svc.Name)
+			}
+		}
+	}
+}
+
+func TestServiceStringOmitsKey(t *testing.T) {
+	secret := newTLSSecret(t, "foo-tls", "foo.example.com")
+	flb := buildSslTestLoadBalancer(t, "foo-tls", secret)
+	httpSvc, _, _ := flb.getServices()
+	key := secret.Data[tlsKeyKey]
+	for _, out := range []string{
+		fmt.Sprintf("%v", httpSvc),
+		fmt.Sprintf("%+v", httpSvc),
+		fmt.Sprintf("%+v", httpSvc[0]),
+		fmt.Sprintf("%+v", &httpSvc[0]),
+	} {
+		if !strings.Contains(out, "svc-1:10") {
+			t.Errorf("Expected the service in %q", out)
+		}
+		if strings.Contains(out, string(key)) || strings.Contains(out, strings.Trim(fmt.Sprint(key), "[]")) {
+			t.Errorf("Expected the private key to be left out of %q", out)
+		}
+	}
+}
+
+func TestSslTermination(t *testing.T) {
+	flb := buildSslTestLoadBalancer(t, "foo-tls", newTLSSecret(t, "foo-tls", "foo.example.com"))
+	// The config is checked with the staged certificates, but written with
+	// the ones in use.
+	flb.cfg.stagedSslCertsDir = "/etc/haproxy/certs.staging"
+	flb.cfg.CheckCmd = "grep -q 'crt /etc/haproxy/certs.staging$'"
+	httpSvc, tcpSvc, _ := flb.getServices()
+	if _, err := flb.backend.write(
+		map[string][]service{
+			"http":  httpSvc,
+			"https": sslTermServices(httpSvc),
+			"tcp":   tcpSvc,
+		}, false); err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	template, _ := filepath.Abs("./test-samples/TestSslTermination.cfg")
+	compareCfgFiles(t, flb.cfg.Config, template)
+	os.Remove(flb.cfg.Config)
+}
+
+func TestStageSslCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("Unable to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	certsDir := filepath.Join(dir, "certs")
+	os.Mkdir(certsDir, 0700)
+
+	stale := filepath.Join(certsDir, "default_stale.pem")
+	if err := ioutil.WriteFile(stale, []byte("stale"), 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cert := filepath.Join(certsDir, "default_foo-tls.pem")
+	services := []service{
+		{Name: "svc-1:10", SslCert: cert, sslPem: []byte("cert")},
+		{Name: "svc-1:20", SslCert: cert, sslPem: []byte("cert")},
+	}
+	staging, err := stageSslCerts(certsDir, services)
+	if err != nil {
+		t.Fatalf("Unexpected error staging certificates: %v", err)
+	}
+
+	// Staging leaves the certificates in use alone
+	if _, err := os.Stat(cert); !os.IsNotExist(err) {
+		t.Errorf("Expected certificate %v to only be staged, got %v", cert, err)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Errorf("Expected certificate %v to be kept until the certificates are committed, got %v", stale, err)
+	}
+	staged := withStagedSslCerts(map[string][]service{"https": services}, staging)
+	if data, err := ioutil.ReadFile(staged["https"][0].SslCert); err != nil || string(data) != "cert" {
+		t.Errorf("Expected certificate %v to be staged, got %q, %v", staged["https"][0].SslCert, data, err)
+	}
+
+	if changed, err := commitSslCerts(certsDir, staging); err != nil || !changed {
+		t.Fatalf("Expected the certificates to change, got %v, %v", changed, err)
+	}
+	if data, err := ioutil.ReadFile(cert); err != nil || string(data) != "cert" {
+		t.Errorf("Expected certificate %v to be written, got %q, %v", cert, data, err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Expected unused certificate %v to be removed, got %v", stale, err)
+	}
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Errorf("Expected staging directory %v to be removed, got %v", staging, err)
+	}
+
+	// Committing the same certificates changes nothing
+	if staging, err = stageSslCerts(certsDir, services); err != nil {
+		t.Fatalf("Unexpected error staging certificates: %v", err)
+	}
+	if changed, err := commitSslCerts(certsDir, staging); err != nil || changed {
+		t.Errorf("Expected the certificates to be unchanged, got %v, %v", changed, err)
+	}
+}
+
+func TestSslCertRotationReloads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	flb := buildSslTestLoadBalancer(t, "foo-tls", newTLSSecret(t, "foo-tls", "foo.example.com"))
+	defer os.Remove(flb.cfg.Config)
+	flb.sslCertsDir = filepath.Join(dir, "certs")
+	flb.cfg.sslCertsDir = flb.sslCertsDir
+	reloaded := filepath.Join(dir, "reloaded")
+	flb.cfg.ReloadCmd = "touch " + reloaded
+
+	syncReloads := func() bool {
+		if err := flb.syncServices(false); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		_, err := os.Stat(reloaded)
+		os.Remove(reloaded)
+		return err == nil
+	}
+	if !syncReloads() {
+		t.Errorf("Expected the first sync to reload")
+	}
+	if syncReloads() {
+		t.Errorf("Expected no reload without changes")
+	}
+
+	// The config is the same with the new certificate, but the proxy must
+	// still reload to use it.
+	rotated := newTLSSecret(t, "foo-tls", "foo.example.com")
+	if err := flb.secretStore.Update(rotated); err != nil {
+		t.Fatalf("Unable to update secret: %v", err)
+	}
+	if !syncReloads() {
+		t.Errorf("Expected a reload after the secret changed")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(flb.sslCertsDir, "default_foo-tls.pem"))
+	if err != nil || !bytes.Contains(data, rotated.Data[tlsKeyKey]) {
+		t.Errorf("Expected the rotated certificate to be written, got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Large enough for any udp datagram.
+const udpBufferSize = 65535
+
+// udpProxier forwards udp services, which neither haproxy nor nginx
+// loadbalance, with a proxy per frontend port.
+type udpProxier struct {
+	// host the proxies listen on, empty for all interfaces.
+	host        string
+	idleTimeout time.Duration
+	proxies     map[int]*udpProxy
+}
+
+func newUDPProxier(host string, idleTimeout time.Duration) *udpProxier {
+	return &udpProxier{host: host, idleTimeout: idleTimeout, proxies: map[int]*udpProxy{}}
+}
+
+// sync starts proxies for new services, updates the endpoints of existing
+// ones and stops the proxies of services that are gone. Services it can't
+// listen for are retried on the next sync.
+func (p *udpProxier) sync(services []service) error {
+	wanted := map[int]bool{}
+	var errs []error
+	for _, svc := range services {
+		wanted[svc.FrontendPort] = true
+		proxy, ok := p.proxies[svc.FrontendPort]
+		if !ok {
+			var err error
+			proxy, err = newUDPProxy(net.JoinHostPort(p.host, fmt.Sprintf("%v", svc.FrontendPort)), p.idleTimeout)
+			if err != nil {
+				udpBindErrors.Inc()
+				errs = append(errs, fmt.Errorf("unable to proxy udp service %v: %v", svc.Name, err))
+				continue
+			}
+			glog.Infof("Proxying udp service %v on port %v", svc.Name, svc.FrontendPort)
+			p.proxies[svc.FrontendPort] = proxy
+			go proxy.serve()
+		}
+		proxy.setEndpoints(svc.Ep)
+	}
+	for port, proxy := range p.proxies {
+		if !wanted[port] {
+			glog.Infof("Stopping the udp proxy on port %v", port)
+			proxy.close()
+			delete(p.proxies, port)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// udpProxy forwards the datagrams it receives to the endpoints of a service,
+// round robin by client. Every client has a session with its own connection
+// to an endpoint, so replies find their way back to it. Sessions are closed
+// once they've been idle in both directions for idleTimeout.
+type udpProxy struct {
+	conn        *net.UDPConn
+	idleTimeout time.Duration
+
+	lock      sync.Mutex
+	endpoints []string
+	next      int
+	sessions  map[string]*udpSession
+}
+
+type udpSession struct {
+	endpoint   string
+	conn       *net.UDPConn
+	lastActive time.Time
+}
+
+func newUDPProxy(address string, idleTimeout time.Duration) (*udpProxy, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpProxy{
+		conn:        conn,
+		idleTimeout: idleTimeout,
+		sessions:    map[string]*udpSession{},
+	}, nil
+}
+
+// setEndpoints replaces the endpoints of the proxy, closing the sessions of
+// clients with endpoints that are gone.
+func (p *udpProxy) setEndpoints(endpoints []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.endpoints = endpoints
+	current := map[string]bool{}
+	for _, ep := range endpoints {
+		current[ep] = true
+	}
+	for client, session := range p.sessions {
+		if !current[session.endpoint] {
+			session.conn.Close()
+			delete(p.sessions, client)
+		}
+	}
+}
+
+// serve forwards datagrams from clients until the proxy is closed.
+func (p *udpProxy) serve() {
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, client, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			if opErr, ok := err.(*net.OpError); ok && opErr.Temporary() {
+				continue
+			}
+			glog.V(2).Infof("Stopped udp proxy on %v: %v", p.conn.LocalAddr(), err)
+			return
+		}
+		session, err := p.getSession(client)
+		if err != nil {
+			glog.Errorf("Dropping datagram from %v: %v", client, err)
+			continue
+		}
+		if _, err := session.conn.Write(buf[:n]); err != nil {
+			glog.Errorf("Unable to forward datagram from %v to %v: %v", client, session.endpoint, err)
+		}
+	}
+}
+
+// getSession returns the session of a client, starting a new one with the
+// next endpoint if it has none.
+func (p *udpProxy) getSession(client *net.UDPAddr) (*udpSession, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if session, ok := p.sessions[client.String()]; ok {
+		session.lastActive = time.Now()
+		return session, nil
+	}
+	if len(p.endpoints) == 0 {
+		return nil, fmt.Errorf("no endpoints")
+	}
+	endpoint := p.endpoints[p.next%len(p.endpoints)]
+	p.next++
+	addr, err := net.ResolveUDPAddr("udp", endpoint)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	session := &udpSession{endpoint: endpoint, conn: conn, lastActive: time.Now()}
+	p.sessions[client.String()] = session
+	udpSessions.Inc()
+	go p.reply(client, session)
+	return session, nil
+}
+
+// reply forwards the replies of the endpoint of a session to its client
+// until the session is idle or closed.
+func (p *udpProxy) reply(client *net.UDPAddr, session *udpSession) {
+	defer udpSessions.Dec()
+	buf := make([]byte, udpBufferSize)
+	for {
+		p.lock.Lock()
+		deadline := session.lastActive.Add(p.idleTimeout)
+		p.lock.Unlock()
+		session.conn.SetReadDeadline(deadline)
+		n, err := session.conn.Read(buf)
+		if err == nil {
+			p.lock.Lock()
+			session.lastActive = time.Now()
+			p.lock.Unlock()
+			if _, err := p.conn.WriteToUDP(buf[:n], client); err != nil {
+				glog.Errorf("Unable to forward reply from %v to %v: %v", session.endpoint, client, err)
+			}
+			continue
+		}
+		if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
+			p.lock.Lock()
+			idle := time.Since(session.lastActive) >= p.idleTimeout
+			if idle && p.sessions[client.String()] == session {
+				glog.V(2).Infof("Closing idle udp session of %v with %v", client, session.endpoint)
+				delete(p.sessions, client.String())
+				session.conn.Close()
+			}
+			p.lock.Unlock()
+			if idle {
+				return
+			}
+			continue
+		}
+		// The session was closed, or the endpoint refused a datagram and
+		// the next one from the client starts a new session.
+		p.lock.Lock()
+		if p.sessions[client.String()] == session {
+			glog.V(2).Infof("Closing udp session of %v with %v: %v", client, session.endpoint, err)
+			delete(p.sessions, client.String())
+			session.conn.Close()
+		}
+		p.lock.Unlock()
+		return
+	}
+}
+
+// close stops the proxy and closes all its sessions.
+func (p *udpProxy) close() {
+	p.conn.Close()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for client, session := range p.sessions {
+		session.conn.Close()
+		delete(p.sessions, client)
+	}
+}
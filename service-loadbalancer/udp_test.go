@@ -0,0 +1,199 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/util"
+)
+
+func TestParseServicePorts(t *testing.T) {
+	testCases := []struct {
+		services string
+		expected map[string]int
+	}{
+		{"", map[string]int{}},
+		{"dns:53", map[string]int{"dns": 53}},
+		{"dns:53,kube-system/syslog:514", map[string]int{"dns": 53, "kube-system/syslog": 514}},
+		{"dns,a/b/c:53,syslog:port,ntp:123", map[string]int{"ntp": 123}},
+	}
+	for _, tc := range testCases {
+		if got := parseServicePorts("UDP", tc.services); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%q: expected %v, got %v", tc.services, tc.expected, got)
+		}
+	}
+}
+
+func TestGetServicesUDP(t *testing.T) {
+	endpointAddresses := []api.EndpointAddress{{IP: "1.2.3.4"}}
+	endpointPorts := []api.EndpointPort{
+		{Port: 53, Protocol: "UDP"},
+		{Port: 514, Protocol: "UDP"},
+	}
+	servicePorts := []api.ServicePort{
+		{Port: 53, Protocol: api.ProtocolUDP, TargetPort: util.NewIntOrStringFromInt(53)},
+		{Port: 53, Protocol: api.ProtocolTCP, TargetPort: util.NewIntOrStringFromInt(53)},
+		{Port: 514, Protocol: api.ProtocolUDP, TargetPort: util.NewIntOrStringFromInt(514)},
+	}
+	svc := getService(servicePorts)
+	flb := newFakeLoadBalancerController(
+		[]*api.Endpoints{getEndpoints(svc, endpointAddresses, endpointPorts)}, []*api.Service{svc})
+	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
+	flb.cfg = parseCfg(cfg, "roundrobin")
+	flb.backend = newHAProxyBackend(flb.cfg, util.NewFakeRateLimiter())
+	flb.udpServices = map[string]int{svc.Name: 53}
+
+	// Only the listed udp port is forwarded, the tcp port with the same
+	// number is still an http service.
+	http, tcp, udp := flb.getServices()
+	if len(udp) != 1 || udp[0].FrontendPort != 53 || !reflect.DeepEqual(udp[0].Ep, []string{"1.2.3.4:53"}) {
+		t.Errorf("Expected a udp service on port 53, got %+v", udp)
+	}
+	if len(http) != 1 || http[0].Name != svc.Name+":53" || len(tcp) != 0 {
+		t.Errorf("Expected the tcp port to be an http service, got http %+v tcp %+v", http, tcp)
+	}
+}
+
+// newUDPEchoServer returns a udp server replying to every datagram with the
+// given prefix and the datagram.
+func newUDPEchoServer(t *testing.T, prefix string) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	go func() {
+		buf := make([]byte, udpBufferSize)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(append([]byte(prefix), buf[:n]...), addr)
+		}
+	}()
+	return conn
+}
+
+// getFreeUDPPort returns a udp port on localhost nothing is listening on.
+func getFreeUDPPort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// exchange sends a datagram through conn and returns the reply.
+func exchange(t *testing.T, conn *net.UDPConn, msg string) string {
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("Unable to send %q: %v", msg, err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, udpBufferSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("No reply to %q: %v", msg, err)
+	}
+	return string(buf[:n])
+}
+
+func TestUDPProxy(t *testing.T) {
+	a := newUDPEchoServer(t, "a:")
+	defer a.Close()
+	b := newUDPEchoServer(t, "b:")
+	defer b.Close()
+
+	port := getFreeUDPPort(t)
+	p := newUDPProxier("127.0.0.1", 200*time.Millisecond)
+	svc := service{Name: "dns:53", FrontendPort: port, Ep: []string{a.LocalAddr().String(), b.LocalAddr().String()}}
+	if err := p.sync([]service{svc}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	proxy := p.proxies[port]
+
+	dial := func() *net.UDPConn {
+		conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: port})
+		if err != nil {
+			t.Fatalf("Unable to dial the proxy: %v", err)
+		}
+		return conn
+	}
+	client1, client2 := dial(), dial()
+	defer client1.Close()
+	defer client2.Close()
+
+	// Clients are spread round robin and stick to their endpoint
+	if reply := exchange(t, client1, "1"); reply != "a:1" {
+		t.Errorf("Expected the first client to get a:1, got %q", reply)
+	}
+	if reply := exchange(t, client2, "2"); reply != "b:2" {
+		t.Errorf("Expected the second client to get b:2, got %q", reply)
+	}
+	if reply := exchange(t, client1, "3"); reply != "a:3" {
+		t.Errorf("Expected the first client to keep its endpoint, got %q", reply)
+	}
+
+	// Removing an endpoint moves its clients
+	svc.Ep = []string{b.LocalAddr().String()}
+	if err := p.sync([]service{svc}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if reply := exchange(t, client1, "4"); reply != "b:4" {
+		t.Errorf("Expected the first client to move to b, got %q", reply)
+	}
+
+	// Idle sessions are closed
+	time.Sleep(500 * time.Millisecond)
+	proxy.lock.Lock()
+	sessions := len(proxy.sessions)
+	proxy.lock.Unlock()
+	if sessions != 0 {
+		t.Errorf("Expected idle sessions to be closed, %v are open", sessions)
+	}
+
+	// A port that's taken doesn't stop the other services from being proxied
+	taken := newUDPEchoServer(t, "taken:")
+	defer taken.Close()
+	takenSvc := service{Name: "ntp:123", FrontendPort: taken.LocalAddr().(*net.UDPAddr).Port}
+	if err := p.sync([]service{takenSvc, svc}); err == nil {
+		t.Errorf("Expected an error proxying a taken port")
+	}
+	if _, ok := p.proxies[takenSvc.FrontendPort]; ok || p.proxies[port] != proxy {
+		t.Errorf("Expected only the proxy on the free port, got %+v", p.proxies)
+	}
+
+	// Proxies of services that are gone are stopped
+	if err := p.sync(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(p.proxies) != 0 {
+		t.Errorf("Expected the proxy to be stopped, got %+v", p.proxies)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: port})
+	if err != nil {
+		t.Errorf("Expected the port of the stopped proxy to be free: %v", err)
+	} else {
+		conn.Close()
+	}
+}